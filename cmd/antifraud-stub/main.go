@@ -0,0 +1,61 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"solution/internal/pkg/antifraudstub"
+	"time"
+)
+
+//go:embed stub.yaml
+var defaultConfig []byte
+
+// antifraud-stub serves the /api/validate contract of the external antifraud
+// service with the behaviour of a config file: deny lists, random denials,
+// injected failures and latency. GET /api/requests lists the last requests
+// it received, for integration tests to check what the server asked.
+func main() {
+	addr := flag.String("addr", ":9090", "address to listen on")
+	file := flag.String("config", "", "behaviour file, the built-in one allows everyone")
+	keep := flag.Int("keep", 1000, "number of requests kept for /api/requests")
+	flag.Parse()
+
+	data := defaultConfig
+	if *file != "" {
+		var err error
+		if data, err = os.ReadFile(*file); err != nil {
+			log.Fatal(err)
+		}
+	}
+	cfg, err := antifraudstub.ParseConfig(data)
+	if err != nil {
+		log.Fatalf("antifraud stub config: %v", err)
+	}
+
+	stub := antifraudstub.New(antifraudstub.OK(time.Time{}))
+	stub.MaxRequests = *keep
+	stub.Decide = func(r antifraudstub.Request) antifraudstub.Response {
+		res := cfg.Decide(r)
+		log.Printf("%s %s: %d %s after %s", r.Email, r.PromoID, res.Status, res.Body, res.Delay)
+		return res
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/validate", stub)
+	mux.HandleFunc(
+		"GET /api/requests", func(w http.ResponseWriter, _ *http.Request) {
+			requests := stub.Requests()
+			if requests == nil {
+				requests = []antifraudstub.Request{}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(requests)
+		},
+	)
+	log.Printf("antifraud stub listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
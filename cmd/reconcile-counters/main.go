@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"log"
+	"os"
+	"solution/config"
+	"solution/internal/domain/promocode"
+	"solution/internal/infrastructure/persistence"
+	"text/tabwriter"
+)
+
+// reconcile-counters recomputes like_count, comment_count and used_count of
+// every promo code from the likes, comments and uses tables and prints the
+// drift it found. Exits with status 1 when drift was found in -dry-run mode.
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report drift without fixing it")
+	flag.Parse()
+
+	cfg := config.New()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: cfg.PostgresConn}), &gorm.Config{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	ds := promocode.NewDomainService(persistence.NewPromoCodeRepository(db), nil, nil, nil)
+
+	drift, er := ds.ReconcileCounters(!*dryRun)
+	if er != nil {
+		log.Fatalf("reconcile: %s: %s", er.Message, er.DebugDetail)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "promo_id\tcounter\tstored\tactual")
+	for _, d := range drift {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", d.PromoCodeID, d.Counter, d.Stored, d.Actual)
+	}
+	_ = w.Flush()
+
+	if *dryRun {
+		fmt.Printf("%d drifting counters found\n", len(drift))
+		if len(drift) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Printf("%d drifting counters fixed\n", len(drift))
+}
@@ -1,20 +1,38 @@
 package main
 
 import (
+	"context"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/log"
+	"github.com/gofiber/fiber/v2/middleware/expvar"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"solution/config"
+	antifraud2 "solution/internal/application/antifraud"
 	business2 "solution/internal/application/business"
+	category2 "solution/internal/application/category"
 	user2 "solution/internal/application/user"
+	webhook2 "solution/internal/application/webhook"
+	"solution/internal/domain/antifraud"
 	"solution/internal/domain/business"
+	"solution/internal/domain/category"
+	customerrors "solution/internal/domain/errors"
+	"solution/internal/domain/outbox"
 	"solution/internal/domain/promocode"
 	"solution/internal/domain/user"
+	"solution/internal/domain/webhook"
+	"solution/internal/infrastructure/broker"
+	"solution/internal/infrastructure/cache"
+	"solution/internal/infrastructure/events"
+	"solution/internal/infrastructure/moderation"
 	"solution/internal/infrastructure/persistence"
+	"solution/internal/infrastructure/webhooks"
 	"solution/internal/interfaces/http"
 	"solution/internal/interfaces/middleware"
+	"time"
 )
 
 func main() {
@@ -22,6 +40,8 @@ func main() {
 	zap.ReplaceGlobals(l)
 	server := fiber.New()
 	cfg := config.New()
+	// The metrics include the command line and memory stats: admins only.
+	server.Get("/debug/vars", middleware.AdminToken(cfg.AdminToken), expvar.New())
 
 	api := server.Group("/api")
 
@@ -41,35 +61,148 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	if err := persistence.MigrateSchema(db); err != nil {
+		log.Fatal(err)
+	}
 	_ = db.AutoMigrate(
 		&business.Business{},
 		&business.Session{},
+		&business.Follower{},
+		&business.ConsentChange{},
 		&promocode.PromoCode{},
 		&promocode.Like{},
+		&promocode.SavedPromo{},
+		&promocode.Rating{},
 		&promocode.Comment{},
+		&promocode.CommentReport{},
+		&promocode.CommentEdit{},
+		&promocode.CommentReaction{},
 		&promocode.Use{},
+		&promocode.Impression{},
+		&promocode.DailyRollup{},
+		&outbox.Record{},
+		&webhook.Webhook{},
+		&webhook.Delivery{},
+		&antifraud.Decision{},
+		&antifraud.Hold{},
+		&antifraud.RuleHit{},
 		&user.User{},
+		&category.Category{},
+		&category.Name{},
 	)
-	promocodeRepository := persistence.NewPromoCodeRepository(db)
+	if err := persistence.Migrate(db); err != nil {
+		log.Fatal(err)
+	}
+	var promocodeRepository promocode.Repository = persistence.NewPromoCodeRepository(db)
 	tokenManager := persistence.NewTokenManagerRepository(db, []byte(cfg.RandomSecret))
-	businessRepository := persistence.NewBusinessRepository(db)
-	userRepository := persistence.NewUserRepository(db)
+	var businessRepository business.Repository = persistence.NewBusinessRepository(db)
+	categoryRepository := persistence.NewCategoryRepository(db)
+	var userRepository user.Repository = persistence.NewUserRepository(db)
+
+	if cfg.CacheEnabled {
+		var store cache.Store
+		switch cfg.CacheStore() {
+		case "redis":
+			store = cache.NewRedisStore(redis.NewClient(&redis.Options{Addr: cfg.RedisHost + ":" + cfg.RedisPort}))
+		case "lru":
+			zap.S().Warn("cache: the lru backend is per instance, run a single instance or use redis")
+			store = cache.NewLRUStore(cfg.CacheLRUSize)
+		default:
+			log.Fatalf("unknown cache backend %q", cfg.CacheBackend)
+		}
+		promoCache := cache.New(store, cfg.CacheTTL, "promo")
+		businessRepository = cache.NewBusinessRepository(businessRepository, promocodeRepository, promoCache)
+		promocodeRepository = cache.NewPromoCodeRepository(promocodeRepository, promoCache)
+		userRepository = cache.NewUserRepository(userRepository, cache.New(store, cfg.CacheTTL, "user"))
+	}
 
 	authMiddleware := middleware.TokenAuth(tokenManager)
 
 	businessDS := business.NewDomainService(businessRepository, tokenManager)
-	promoDS := promocode.NewDomainService(promocodeRepository)
+	impressions := persistence.NewImpressionWriter(
+		db, cfg.ImpressionBuffer, cfg.ImpressionBatchSize, cfg.ImpressionFlushInterval,
+	)
+	defer impressions.Close()
+	promoDS := promocode.NewDomainService(
+		promocodeRepository, &promocode.Moderation{
+			Filter:          moderation.NewWordListFilter(cfg.CommentBlockedWords),
+			ReportThreshold: cfg.CommentReportThreshold,
+		},
+		impressions,
+		events.NewBus(cfg.StreamBufferSize, cfg.StreamMaxPerCompany),
+	)
+	if cfg.RollupInterval <= 0 {
+		log.Fatalf("ROLLUP_INTERVAL must be positive, got %s", cfg.RollupInterval)
+	}
+	go refreshRollups(promoDS, cfg.RollupInterval, cfg.RollupLookbackDays)
+	webhookDS := webhook.NewDomainService(
+		persistence.NewWebhookRepository(db),
+		webhooks.NewHTTPSender(cfg.WebhookTimeout, cfg.WebhookAllowPrivate),
+	)
+	go dispatchWebhooks(promoDS, webhookDS, cfg.WebhookPollInterval)
+
+	dispatcher := outbox.NewDispatcher(persistence.NewOutboxRepository(db))
+	dispatcher.Subscribe(
+		"live", promoDS.PublishLive,
+		outbox.TypePromoActivated, outbox.TypePromoLiked, outbox.TypeCommentPosted,
+	)
+	dispatcher.Subscribe("webhooks", webhookDS.HandleEvent, webhook.Events...)
+	switch cfg.OutboxBroker {
+	case "none":
+	case "log":
+		dispatcher.Attach(broker.NewLogBroker())
+	default:
+		log.Fatalf("unknown outbox broker %q", cfg.OutboxBroker)
+	}
+	go dispatcher.Run(context.Background(), cfg.OutboxPollInterval)
+	if cfg.OutboxPruneInterval <= 0 {
+		log.Fatalf("OUTBOX_PRUNE_INTERVAL must be positive, got %s", cfg.OutboxPruneInterval)
+	}
+	// One more day as the expired lookback starts at midnight.
+	if minRetention := (promocode.ExpiredLookbackDays + 1) * 24 * time.Hour; cfg.OutboxRetention < minRetention {
+		log.Fatalf("OUTBOX_RETENTION must be at least %s, got %s", minRetention, cfg.OutboxRetention)
+	}
+	go pruneOutbox(dispatcher, cfg.OutboxPruneInterval, cfg.OutboxRetention)
 	userDS := user.NewDomainService(userRepository, tokenManager)
+	antifraudDS := antifraud.NewDomainService(persistence.NewAntifraudRepository(db))
+	categoryDS := category.NewDomainService(categoryRepository)
 
-	businessAS := business2.NewApplicationService(businessDS, promoDS, cfg)
-	userAS := user2.NewApplicationService(userDS, promoDS)
+	seeds, err := cfg.Categories()
+	if err != nil {
+		log.Fatalf("category catalog: %v", err)
+	}
+	if er := categoryDS.Sync(seeds); er != nil {
+		log.Fatalf("category catalog: %s", er.DebugDetail)
+	}
+
+	businessAS := business2.NewApplicationService(businessDS, promoDS, categoryDS, cfg)
+	userAS := user2.NewApplicationService(userDS, promoDS, categoryDS, businessDS)
+	categoryAS := category2.NewApplicationService(categoryDS, promoDS)
+	webhookAS := webhook2.NewApplicationService(webhookDS)
+	antifraudAS := antifraud2.NewApplicationService(
+		antifraudDS, promoDS, func(userID uuid.UUID, promo uuid.UUID) (string, *customerrors.DomainError) {
+			res, err := userAS.ActivatePromoCode(userID, promo)
+			if err != nil {
+				return "", err
+			}
+			return res.Promo, nil
+		},
+	)
 
 	businessAPI := http.NewBusinessAPI(businessAS)
 	userAPI := http.NewUserAPI(userAS)
+	categoryAPI := http.NewCategoryAPI(categoryAS)
+	webhookAPI := http.NewWebhookAPI(webhookAS)
+	antifraudAPI := http.NewAntifraudAPI(antifraudAS)
+
+	api.Get("/categories", categoryAPI.GetCategories)
 
 	api.Post("/business/auth/sign-up", businessAPI.SignUp) // 02
 	api.Post("/business/auth/sign-in", businessAPI.SignIn) // 03
 
+	api.Get("/business/profile", authMiddleware, businessAPI.GetProfile)
+	api.Patch("/business/profile", authMiddleware, businessAPI.EditProfile)
+
 	api.Post("/business/promo/", authMiddleware, businessAPI.CreatePromoCode)   // 04
 	api.Get("/business/promo", authMiddleware, businessAPI.GetPromoCodes)       // 05
 	api.Get("/business/promo/:id", authMiddleware, businessAPI.GetPromoCode)    // 06
@@ -82,24 +215,201 @@ func main() {
 	api.Get("/user/feed", authMiddleware, userAPI.GetFeed)          // 10
 
 	api.Get("/user/promo/history", authMiddleware, userAPI.GetUseHistory)
+	api.Get("/user/wallet", authMiddleware, userAPI.GetWallet)
+	api.Get("/user/wallet/expiring", authMiddleware, userAPI.GetExpiringWallet)
+
+	api.Get("/user/company/:id", authMiddleware, userAPI.GetCompany)
+	api.Post("/user/company/:id/follow", authMiddleware, userAPI.FollowCompany)
+	api.Delete("/user/company/:id/follow", authMiddleware, userAPI.UnfollowCompany)
 
 	api.Get("/user/promo/:id", authMiddleware, userAPI.GetPromoCode) //10
 
 	api.Post("/user/promo/:id/like", authMiddleware, userAPI.Like)     // 11
 	api.Delete("/user/promo/:id/like", authMiddleware, userAPI.Unlike) // 11
 
+	api.Post("/user/promo/:id/save", authMiddleware, userAPI.SavePromoCode)
+	api.Delete("/user/promo/:id/save", authMiddleware, userAPI.UnsavePromoCode)
+
+	api.Post("/user/promo/:id/rating", authMiddleware, userAPI.RatePromoCode)
+	api.Delete("/user/promo/:id/rating", authMiddleware, userAPI.UnratePromoCode)
+
 	api.Get("/user/promo/:id/comments/:comment_id", authMiddleware, userAPI.GetPromoCodeComment)       // 12
 	api.Put("/user/promo/:id/comments/:comment_id", authMiddleware, userAPI.EditPromoCodeComment)      // 12
 	api.Delete("/user/promo/:id/comments/:comment_id", authMiddleware, userAPI.DeletePromoCodeComment) // 12
 	api.Post("/user/promo/:id/comments", authMiddleware, userAPI.CommentPromoCode)                     // 12
 	api.Get("/user/promo/:id/comments", authMiddleware, userAPI.GetPromoCodeComments)                  // 12
+	api.Post("/user/promo/:id/comments/:comment_id/report", authMiddleware, userAPI.ReportPromoCodeComment)
+	api.Get("/user/promo/:id/comments/:comment_id/history", authMiddleware, userAPI.GetPromoCodeCommentHistory)
+	api.Post("/user/promo/:id/comments/:comment_id/reactions/:reaction", authMiddleware, userAPI.ReactToPromoCodeComment)
+	api.Delete(
+		"/user/promo/:id/comments/:comment_id/reactions/:reaction",
+		authMiddleware,
+		userAPI.RemovePromoCodeCommentReaction,
+	)
 
-	antifraud := middleware.AntiFraud(cfg.RedisHost+":"+cfg.RedisPort, cfg.AntifraudAddress)
+	antifraudRedis := redis.NewClient(&redis.Options{Addr: cfg.RedisHost + ":" + cfg.RedisPort})
+	var checkers middleware.Chain
+	enforced := make(map[string]bool, len(cfg.AntifraudCheckers))
+	for _, name := range cfg.AntifraudCheckers {
+		enforced[name] = true
+		checkers = append(
+			checkers, middleware.ChainLink{Name: name, Checker: newAntifraudChecker(cfg, name, antifraudRedis, userDS)},
+		)
+	}
+	for _, name := range cfg.AntifraudShadowCheckers {
+		if enforced[name] {
+			log.Fatalf("antifraud checker %q is both enforced and shadow", name)
+		}
+		checkers = append(
+			checkers, middleware.ChainLink{
+				Name:    name,
+				Checker: newAntifraudChecker(cfg, name, antifraudRedis, userDS),
+				Shadow:  true,
+			},
+		)
+	}
+	if cfg.AntifraudFailPolicy != promocode.FailOpen && cfg.AntifraudFailPolicy != promocode.FailClosed {
+		log.Fatalf("unknown antifraud fail policy %q", cfg.AntifraudFailPolicy)
+	}
+	if cfg.AntifraudCacheBy != middleware.CacheByUserPromo && cfg.AntifraudCacheBy != middleware.CacheByUser {
+		log.Fatalf("unknown antifraud cache scope %q", cfg.AntifraudCacheBy)
+	}
+	antifraudMiddleware := middleware.AntiFraud(
+		antifraudRedis, checkers, middleware.AntiFraudOptions{
+			FailsOpen: func(_ context.Context, id uuid.UUID) bool {
+				byDefault := cfg.AntifraudFailPolicy == promocode.FailOpen
+				p, err := promoDS.Get(id)
+				if err != nil {
+					return byDefault
+				}
+				return p.FailsOpen(byDefault)
+			},
+			CacheBy:     cfg.AntifraudCacheBy,
+			DenyTTL:     cfg.AntifraudDenyTTL,
+			MaxCacheTTL: cfg.AntifraudMaxCacheTTL,
+			Decisions:   antifraudDS,
+			Shadow:      checkers.Shadows(),
+		},
+	)
+
+	api.Post("/user/promo/:id/activate", authMiddleware, antifraudMiddleware, userAPI.ActivatePromoCode)
+	api.Get("/user/promo/:id/activate/hold", authMiddleware, antifraudAPI.GetActivationHold)
 
-	api.Post("/user/promo/:id/activate", authMiddleware, antifraud, userAPI.ActivatePromoCode)
+	api.Get("/admin/antifraud/decisions", middleware.AdminToken(cfg.AdminToken), antifraudAPI.SearchDecisions)
+	api.Get("/admin/antifraud/shadow-report", middleware.AdminToken(cfg.AdminToken), antifraudAPI.GetShadowReport)
+	api.Put(
+		"/admin/business/:id/export-email-consent",
+		middleware.AdminToken(cfg.AdminToken),
+		businessAPI.SetExportEmailConsent,
+	)
 	// 13 POST user/promo/{id}/activate
 	// 13 GET /user/promo/history
 
 	api.Get("/business/promo/:id/stat", authMiddleware, businessAPI.UsageStatistic) // 14
+	api.Get("/business/promo/:id/stream", authMiddleware, businessAPI.StreamPromoCode)
+	api.Get("/business/promo/:id/antifraud/decisions", authMiddleware, antifraudAPI.GetDecisions)
+	api.Get("/business/promo/:id/antifraud/holds", authMiddleware, antifraudAPI.GetHolds)
+	api.Post("/business/promo/:id/antifraud/holds/:hold_id/approve", authMiddleware, antifraudAPI.ApproveHold)
+	api.Post("/business/promo/:id/antifraud/holds/:hold_id/reject", authMiddleware, antifraudAPI.RejectHold)
+
+	api.Post("/business/promo/:id/comments", authMiddleware, businessAPI.OfficialReply)
+	api.Delete("/business/promo/:id/comments/:comment_id", authMiddleware, businessAPI.DeleteOfficialReply)
+	api.Post("/business/promo/:id/comments/:comment_id/hide", authMiddleware, businessAPI.HideComment)
+	api.Delete("/business/promo/:id/comments/:comment_id/hide", authMiddleware, businessAPI.UnhideComment)
+	api.Get("/business/promo/:id/comments/:comment_id/history", authMiddleware, businessAPI.GetCommentHistory)
+	api.Get("/business/moderation", authMiddleware, businessAPI.GetModerationQueue)
+	api.Get("/business/promo/:id/funnel", authMiddleware, businessAPI.GetFunnel)
+	api.Get("/business/funnel", authMiddleware, businessAPI.GetCompanyFunnel)
+	api.Get("/business/stats", authMiddleware, businessAPI.CompanyStatistic)
+	api.Get("/business/stats/export", authMiddleware, businessAPI.ExportStatistic)
+	api.Get("/business/promo/:id/export/activations", authMiddleware, businessAPI.ExportUses)
+	api.Post("/business/promo/:id/redeem", authMiddleware, businessAPI.RedeemPromoCode)
+
+	api.Post("/business/webhooks", authMiddleware, webhookAPI.CreateWebhook)
+	api.Get("/business/webhooks", authMiddleware, webhookAPI.GetWebhooks)
+	api.Get("/business/webhooks/:id", authMiddleware, webhookAPI.GetWebhook)
+	api.Patch("/business/webhooks/:id", authMiddleware, webhookAPI.EditWebhook)
+	api.Delete("/business/webhooks/:id", authMiddleware, webhookAPI.DeleteWebhook)
+	api.Post("/business/webhooks/:id/test", authMiddleware, webhookAPI.TestWebhook)
+	api.Get("/business/webhooks/:id/deliveries", authMiddleware, webhookAPI.GetDeliveries)
+	api.Post("/business/webhooks/:id/deliveries/:delivery_id/retry", authMiddleware, webhookAPI.RetryDelivery)
 	log.Info(server.Listen(":" + cfg.ServerPort))
 }
+
+func newAntifraudChecker(
+	cfg *config.Config,
+	name string,
+	client *redis.Client,
+	userDS *user.DomainService,
+) middleware.AntiFraudChecker {
+	switch name {
+	case "http":
+		return middleware.NewHTTPChecker(
+			cfg.AntifraudAddress, middleware.HTTPCheckerOptions{
+				Timeout:          cfg.AntifraudTimeout,
+				Retries:          cfg.AntifraudRetries,
+				Backoff:          cfg.AntifraudBackoff,
+				BackoffMax:       cfg.AntifraudBackoffMax,
+				BreakerThreshold: cfg.AntifraudBreakerThreshold,
+				BreakerCooldown:  cfg.AntifraudBreakerCooldown,
+			},
+		)
+	case "rules":
+		data, err := cfg.AntifraudRules()
+		if err != nil {
+			log.Fatalf("antifraud rules: %v", err)
+		}
+		rules, err := middleware.ParseAntiFraudRules(data)
+		if err != nil {
+			log.Fatalf("antifraud rules: %v", err)
+		}
+		return middleware.NewRulesChecker(
+			rules, client, func(_ context.Context, id uuid.UUID) (time.Time, bool) {
+				u, err := userDS.GetByID(id)
+				if err != nil || u.CreatedAt == nil {
+					return time.Time{}, false
+				}
+				return *u.CreatedAt, true
+			},
+		)
+	}
+	log.Fatalf("unknown antifraud checker %q", name)
+	return nil
+}
+
+// dispatchWebhooks raises promo.expired for promo codes that ended and sends
+// the webhook deliveries that are due.
+func dispatchWebhooks(promoDS *promocode.DomainService, ds *webhook.DomainService, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		if err := promoDS.EnqueueExpired(); err != nil {
+			zap.S().Errorw("expired promo codes", "error", err.DebugDetail)
+		}
+		ds.Dispatch(context.Background())
+		<-ticker.C
+	}
+}
+
+// pruneOutbox deletes the processed outbox events older than retention.
+func pruneOutbox(d *outbox.Dispatcher, every time.Duration, retention time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		d.Prune(retention)
+		<-ticker.C
+	}
+}
+
+// refreshRollups keeps the rollups behind the company dashboard up to date,
+// starting right away so a fresh database gets its backfill.
+func refreshRollups(ds *promocode.DomainService, every time.Duration, lookbackDays int) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		if err := ds.RefreshRollups(lookbackDays); err != nil {
+			zap.S().Errorw("rollup refresh", "error", err.DebugDetail)
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,18 @@
+package config
+
+import (
+	_ "embed"
+	"os"
+)
+
+//go:embed antifraud.yaml
+var defaultAntifraudRules []byte
+
+// AntifraudRules reads the rules of the built-in antifraud engine from
+// ANTIFRAUD_RULES_FILE, falling back to the rules built into the binary.
+func (c *Config) AntifraudRules() ([]byte, error) {
+	if c.AntifraudRulesFile == "" {
+		return defaultAntifraudRules, nil
+	}
+	return os.ReadFile(c.AntifraudRulesFile)
+}
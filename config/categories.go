@@ -0,0 +1,28 @@
+package config
+
+import (
+	_ "embed"
+	"gopkg.in/yaml.v3"
+	"os"
+	"solution/internal/domain/category"
+)
+
+//go:embed categories.yaml
+var defaultCategories []byte
+
+// Categories reads the category catalog from CATEGORIES_FILE, falling back to
+// the catalog built into the binary.
+func (c *Config) Categories() ([]category.Seed, error) {
+	data := defaultCategories
+	if c.CategoriesFile != "" {
+		var err error
+		if data, err = os.ReadFile(c.CategoriesFile); err != nil {
+			return nil, err
+		}
+	}
+	var seeds []category.Seed
+	if err := yaml.Unmarshal(data, &seeds); err != nil {
+		return nil, err
+	}
+	return seeds, nil
+}
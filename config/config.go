@@ -3,6 +3,7 @@ package config
 import (
 	"github.com/ilyakaznacheev/cleanenv"
 	"log"
+	"time"
 )
 
 type Config struct {
@@ -12,6 +13,81 @@ type Config struct {
 	RedisPort        string `env:"REDIS_PORT"`
 	AntifraudAddress string `env:"ANTIFRAUD_ADDRESS"`
 	RandomSecret     string `env:"RANDOM_SECRET"`
+	// AdminToken guards the admin endpoints; without it they are closed.
+	AdminToken string `env:"ADMIN_TOKEN"`
+	// ExportPseudonymKey keys the pseudonyms of users in exports; without it
+	// only exports carrying emails are allowed.
+	ExportPseudonymKey string `env:"EXPORT_PSEUDONYM_KEY"`
+
+	CacheEnabled bool `env:"CACHE_ENABLED" env-default:"true"`
+	// CacheBackend is redis or lru. The lru cache is per instance, so only
+	// the instance handling a write invalidates it; it is meant for a single
+	// instance. Empty means redis when REDIS_HOST is set, lru otherwise.
+	CacheBackend string        `env:"CACHE_BACKEND"`
+	CacheTTL     time.Duration `env:"CACHE_TTL" env-default:"30s"`
+	CacheLRUSize int           `env:"CACHE_LRU_SIZE" env-default:"10000"`
+
+	CategoriesFile string `env:"CATEGORIES_FILE"`
+
+	// AntifraudCheckers are the checkers activations go through, in order:
+	// rules, the built-in engine, and http, the external service.
+	AntifraudCheckers []string `env:"ANTIFRAUD_CHECKERS" env-separator:"," env-default:"http"`
+	// AntifraudShadowCheckers run after them and are only recorded.
+	AntifraudShadowCheckers []string `env:"ANTIFRAUD_SHADOW_CHECKERS" env-separator:","`
+	AntifraudRulesFile      string   `env:"ANTIFRAUD_RULES_FILE"`
+
+	AntifraudTimeout          time.Duration `env:"ANTIFRAUD_TIMEOUT" env-default:"2s"`
+	AntifraudRetries          int           `env:"ANTIFRAUD_RETRIES" env-default:"2"`
+	AntifraudBackoff          time.Duration `env:"ANTIFRAUD_BACKOFF" env-default:"100ms"`
+	AntifraudBackoffMax       time.Duration `env:"ANTIFRAUD_BACKOFF_MAX" env-default:"1s"`
+	AntifraudBreakerThreshold int           `env:"ANTIFRAUD_BREAKER_THRESHOLD" env-default:"5"`
+	AntifraudBreakerCooldown  time.Duration `env:"ANTIFRAUD_BREAKER_COOLDOWN" env-default:"30s"`
+	// AntifraudFailPolicy is open or closed, for promo codes that don't set
+	// their own.
+	AntifraudFailPolicy string `env:"ANTIFRAUD_FAIL_POLICY" env-default:"closed"`
+	// AntifraudCacheBy is user_promo or user: what a cached verdict covers.
+	AntifraudCacheBy     string        `env:"ANTIFRAUD_CACHE_BY" env-default:"user_promo"`
+	AntifraudDenyTTL     time.Duration `env:"ANTIFRAUD_DENY_TTL" env-default:"1m"`
+	AntifraudMaxCacheTTL time.Duration `env:"ANTIFRAUD_MAX_CACHE_TTL" env-default:"1h"`
+
+	CommentBlockedWords    []string `env:"COMMENT_BLOCKED_WORDS" env-separator:","`
+	CommentReportThreshold int      `env:"COMMENT_REPORT_THRESHOLD" env-default:"3"`
+
+	ImpressionBuffer        int           `env:"IMPRESSION_BUFFER" env-default:"10000"`
+	ImpressionBatchSize     int           `env:"IMPRESSION_BATCH_SIZE" env-default:"500"`
+	ImpressionFlushInterval time.Duration `env:"IMPRESSION_FLUSH_INTERVAL" env-default:"2s"`
+
+	RollupInterval     time.Duration `env:"ROLLUP_INTERVAL" env-default:"5m"`
+	RollupLookbackDays int           `env:"ROLLUP_LOOKBACK_DAYS" env-default:"1"`
+
+	StreamBufferSize    int `env:"STREAM_BUFFER_SIZE" env-default:"1000"`
+	StreamMaxPerCompany int `env:"STREAM_MAX_PER_COMPANY" env-default:"5"`
+
+	OutboxPollInterval time.Duration `env:"OUTBOX_POLL_INTERVAL" env-default:"500ms"`
+	// Processed outbox events are deleted after OutboxRetention, checked
+	// every OutboxPruneInterval.
+	OutboxRetention     time.Duration `env:"OUTBOX_RETENTION" env-default:"720h"`
+	OutboxPruneInterval time.Duration `env:"OUTBOX_PRUNE_INTERVAL" env-default:"1h"`
+	// OutboxBroker is where outbox events are published besides the
+	// in-process subscribers: none or log.
+	OutboxBroker string `env:"OUTBOX_BROKER" env-default:"none"`
+
+	WebhookPollInterval time.Duration `env:"WEBHOOK_POLL_INTERVAL" env-default:"5s"`
+	WebhookTimeout      time.Duration `env:"WEBHOOK_TIMEOUT" env-default:"10s"`
+	// WebhookAllowPrivate lets webhooks reach private addresses, for local
+	// development.
+	WebhookAllowPrivate bool `env:"WEBHOOK_ALLOW_PRIVATE" env-default:"false"`
+}
+
+// CacheStore is the cache backend in use, CacheBackend or its default.
+func (c *Config) CacheStore() string {
+	switch {
+	case c.CacheBackend != "":
+		return c.CacheBackend
+	case c.RedisHost != "":
+		return "redis"
+	}
+	return "lru"
 }
 
 func New() *Config {
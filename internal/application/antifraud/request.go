@@ -0,0 +1,119 @@
+package antifraud
+
+import (
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"time"
+)
+
+type GetDecisionsQueryParams struct {
+	Outcome string `query:"outcome" validate:"omitempty,oneof=allow review deny"`
+	Limit   *int   `query:"limit" validate:"omitempty,gte=0"`
+	Offset  int    `query:"offset" validate:"omitempty,gte=0"`
+}
+
+func (r *GetDecisionsQueryParams) Bind(c *fiber.Ctx, v *validator.Validate) error {
+	if err := c.QueryParser(r); err != nil {
+		return err
+	}
+	return v.Struct(r)
+}
+
+type GetHoldsQueryParams struct {
+	Status string `query:"status" validate:"omitempty,oneof=pending activating approved rejected failed"`
+	Limit  *int   `query:"limit" validate:"omitempty,gte=0"`
+	Offset int    `query:"offset" validate:"omitempty,gte=0"`
+}
+
+func (r *GetHoldsQueryParams) Bind(c *fiber.Ctx, v *validator.Validate) error {
+	if err := c.QueryParser(r); err != nil {
+		return err
+	}
+	return v.Struct(r)
+}
+
+type ResolveHoldRequest struct {
+	Note string `json:"note" validate:"max=1000"`
+}
+
+func (r *ResolveHoldRequest) Bind(c *fiber.Ctx, v *validator.Validate) error {
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(r); err != nil {
+			return err
+		}
+	}
+	return v.Struct(r)
+}
+
+// SearchDecisionsQueryParams are the filters of the admin search. From and
+// To are RFC 3339 times.
+type SearchDecisionsQueryParams struct {
+	UserID  string `query:"user_id" validate:"omitempty,uuid"`
+	PromoID string `query:"promo_id" validate:"omitempty,uuid"`
+	Outcome string `query:"outcome" validate:"omitempty,oneof=allow review deny"`
+	Source  string `query:"source" validate:"omitempty,oneof=checker cache unavailable"`
+	From    string `query:"from" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	To      string `query:"to" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	Limit   *int   `query:"limit" validate:"omitempty,gte=0"`
+	Offset  int    `query:"offset" validate:"omitempty,gte=0"`
+
+	userID  *uuid.UUID
+	promoID *uuid.UUID
+	from    *time.Time
+	to      *time.Time
+}
+
+func (r *SearchDecisionsQueryParams) Bind(c *fiber.Ctx, v *validator.Validate) error {
+	if err := c.QueryParser(r); err != nil {
+		return err
+	}
+	if err := v.Struct(r); err != nil {
+		return err
+	}
+	if r.UserID != "" {
+		id := uuid.MustParse(r.UserID)
+		r.userID = &id
+	}
+	if r.PromoID != "" {
+		id := uuid.MustParse(r.PromoID)
+		r.promoID = &id
+	}
+	if r.From != "" {
+		t, _ := time.Parse(time.RFC3339, r.From)
+		r.from = &t
+	}
+	if r.To != "" {
+		t, _ := time.Parse(time.RFC3339, r.To)
+		r.to = &t
+	}
+	return nil
+}
+
+// ShadowReportQueryParams is the range of the report, RFC 3339 times. It
+// defaults to the last week.
+type ShadowReportQueryParams struct {
+	From string `query:"from" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	To   string `query:"to" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+
+	from time.Time
+	to   time.Time
+}
+
+func (r *ShadowReportQueryParams) Bind(c *fiber.Ctx, v *validator.Validate) error {
+	if err := c.QueryParser(r); err != nil {
+		return err
+	}
+	if err := v.Struct(r); err != nil {
+		return err
+	}
+	r.to = time.Now()
+	if r.To != "" {
+		r.to, _ = time.Parse(time.RFC3339, r.To)
+	}
+	r.from = r.to.AddDate(0, 0, -7)
+	if r.From != "" {
+		r.from, _ = time.Parse(time.RFC3339, r.From)
+	}
+	return nil
+}
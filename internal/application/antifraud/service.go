@@ -0,0 +1,188 @@
+package antifraud
+
+import (
+	"github.com/google/uuid"
+	"solution/internal/domain/antifraud"
+	customerrors "solution/internal/domain/errors"
+	"solution/internal/domain/promocode"
+	"time"
+)
+
+// Activator activates the promo code for the user, as the activation
+// endpoint does, and returns the activated code.
+type Activator func(user uuid.UUID, promo uuid.UUID) (string, *customerrors.DomainError)
+
+type ApplicationService struct {
+	ds       *antifraud.DomainService
+	promoDS  *promocode.DomainService
+	activate Activator
+}
+
+func NewApplicationService(
+	ds *antifraud.DomainService,
+	promoDS *promocode.DomainService,
+	activate Activator,
+) *ApplicationService {
+	return &ApplicationService{ds: ds, promoDS: promoDS, activate: activate}
+}
+
+func (s *ApplicationService) ownPromo(sub uuid.UUID, promo uuid.UUID) *customerrors.DomainError {
+	p, err := s.promoDS.Get(promo)
+	if err != nil {
+		return customerrors.NotFound()
+	}
+	if p.CompanyID != sub {
+		return customerrors.Forbidden()
+	}
+	return nil
+}
+
+// GetDecisions lists the antifraud decisions about activations of the
+// company's promo code.
+func (s *ApplicationService) GetDecisions(sub uuid.UUID, promo uuid.UUID, params *GetDecisionsQueryParams) (
+	[]map[string]interface{},
+	int,
+	*customerrors.DomainError,
+) {
+	if err := s.ownPromo(sub, promo); err != nil {
+		return nil, 0, err
+	}
+	decisions, count := s.ds.Decisions(promo, params.Outcome, params.Limit, params.Offset)
+	result := make([]map[string]interface{}, 0, len(decisions))
+	for _, d := range decisions {
+		result = append(result, d.ToView())
+	}
+	return result, count, nil
+}
+
+func (s *ApplicationService) GetHolds(sub uuid.UUID, promo uuid.UUID, params *GetHoldsQueryParams) (
+	[]map[string]interface{},
+	int,
+	*customerrors.DomainError,
+) {
+	if err := s.ownPromo(sub, promo); err != nil {
+		return nil, 0, err
+	}
+	holds, count := s.ds.Holds(promo, params.Status, params.Limit, params.Offset)
+	result := make([]map[string]interface{}, 0, len(holds))
+	for _, h := range holds {
+		result = append(result, h.ToView())
+	}
+	return result, count, nil
+}
+
+// ApproveHold activates the promo code for the user of the hold. The hold is
+// activating meanwhile, so an approval cut short is recovered later. When the
+// activation fails, the hold is marked failed with the reason.
+func (s *ApplicationService) ApproveHold(sub uuid.UUID, promo uuid.UUID, id uuid.UUID, request *ResolveHoldRequest) (
+	map[string]interface{},
+	*customerrors.DomainError,
+) {
+	h, err := s.hold(sub, promo, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ds.Approve(h, request.Note); err != nil {
+		return nil, err
+	}
+	code, err := s.activate(h.UserID, h.PromoCodeID)
+	if err != nil {
+		note := "activation failed: " + err.Message
+		if err := s.ds.Fail(h, note); err != nil {
+			return nil, err
+		}
+		return h.ToView(), nil
+	}
+	if err := s.ds.Activated(h, code); err != nil {
+		return nil, err
+	}
+	return h.ToView(), nil
+}
+
+func (s *ApplicationService) RejectHold(sub uuid.UUID, promo uuid.UUID, id uuid.UUID, request *ResolveHoldRequest) (
+	map[string]interface{},
+	*customerrors.DomainError,
+) {
+	h, err := s.hold(sub, promo, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ds.Resolve(h, antifraud.HoldRejected, request.Note); err != nil {
+		return nil, err
+	}
+	return h.ToView(), nil
+}
+
+func (s *ApplicationService) hold(sub uuid.UUID, promo uuid.UUID, id uuid.UUID) (
+	*antifraud.Hold,
+	*customerrors.DomainError,
+) {
+	if err := s.ownPromo(sub, promo); err != nil {
+		return nil, err
+	}
+	h, err := s.ds.GetHold(id, promo)
+	if err != nil {
+		return nil, customerrors.NotFound()
+	}
+	if err := s.recoverHold(h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// recoverHold ends an interrupted activation: the hold is approved with the
+// code when the activation went through, and pending again otherwise.
+func (s *ApplicationService) recoverHold(h *antifraud.Hold) *customerrors.DomainError {
+	if !h.Interrupted(time.Now()) {
+		return nil
+	}
+	use, err := s.promoDS.UseSince(h.PromoCodeID, h.UserID, *h.ResolvedAt)
+	if err != nil {
+		if err.Code != 404 {
+			return err
+		}
+		return s.ds.Reopen(h)
+	}
+	return s.ds.Activated(h, use.Code)
+}
+
+// GetActivationHold tells the user how their held activation of the promo
+// code is going.
+func (s *ApplicationService) GetActivationHold(sub uuid.UUID, promo uuid.UUID) (
+	map[string]interface{},
+	*customerrors.DomainError,
+) {
+	h, err := s.ds.LatestHold(sub, promo)
+	if err != nil {
+		return nil, customerrors.NotFound()
+	}
+	if err := s.recoverHold(h); err != nil {
+		return nil, err
+	}
+	return h.ToUserView(), nil
+}
+
+func (s *ApplicationService) SearchDecisions(params *SearchDecisionsQueryParams) ([]map[string]interface{}, int) {
+	decisions, count := s.ds.SearchDecisions(
+		&antifraud.DecisionFilter{
+			UserID:  params.userID,
+			PromoID: params.promoID,
+			Outcome: params.Outcome,
+			Source:  params.Source,
+			From:    params.from,
+			To:      params.to,
+		}, params.Limit, params.Offset,
+	)
+	result := make([]map[string]interface{}, 0, len(decisions))
+	for _, d := range decisions {
+		result = append(result, d.ToAdminView())
+	}
+	return result, count
+}
+
+func (s *ApplicationService) GetShadowReport(params *ShadowReportQueryParams) (
+	*antifraud.ShadowReport,
+	*customerrors.DomainError,
+) {
+	return s.ds.ShadowReport(params.from, params.to)
+}
@@ -1,12 +1,16 @@
 package business
 
 import (
+	"fmt"
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 	"solution/internal/domain/promocode"
 	"solution/internal/domain/types"
+	"solution/internal/pkg/export"
 	"solution/pkg"
 	"strings"
+	"time"
 )
 
 type CreateBusinessRequest struct {
@@ -49,6 +53,8 @@ type CreatePromoCodeRequest struct {
 	ImageURL    *string             `json:"image_url" validate:"omitempty,url"`
 	ActiveFrom  *types.SolutionDate `json:"active_from"`
 	ActiveUntil *types.SolutionDate `json:"active_until"`
+	// AntifraudFailPolicy is open or closed; empty means the service default.
+	AntifraudFailPolicy string `json:"antifraud_fail_policy" validate:"omitempty,oneof=open closed"`
 }
 
 func (r *CreatePromoCodeRequest) Bind(c *fiber.Ctx, v *validator.Validate) error {
@@ -67,9 +73,10 @@ type EditPromoCodeRequest struct {
 		Country    *string   `json:"country" validate:"omitempty,country"`
 		Categories *[]string `json:"categories" validate:"omitempty,dive,min=2,max=20"`
 	} `json:"target" validate:"omitempty"`
-	MaxCount    *int                `json:"max_count" validate:"omitempty,gte=0,lte=100000000"`
-	ActiveFrom  *types.SolutionDate `json:"active_from"`
-	ActiveUntil *types.SolutionDate `json:"active_until"`
+	MaxCount            *int                `json:"max_count" validate:"omitempty,gte=0,lte=100000000"`
+	ActiveFrom          *types.SolutionDate `json:"active_from"`
+	ActiveUntil         *types.SolutionDate `json:"active_until"`
+	AntifraudFailPolicy *string             `json:"antifraud_fail_policy" validate:"omitempty,oneof=open closed"`
 }
 
 func (r *EditPromoCodeRequest) Bind(c *fiber.Ctx, v *validator.Validate) error {
@@ -107,3 +114,222 @@ func (r *GetPromoCodesQueryParams) Bind(c *fiber.Ctx) error {
 	r.Countries = &countries
 	return nil
 }
+
+type EditProfileRequest struct {
+	Name         *string `json:"name" validate:"omitempty,min=5,max=50"`
+	LogoURL      *string `json:"logo_url" validate:"omitempty,url"`
+	Description  *string `json:"description" validate:"omitempty,max=1000"`
+	Website      *string `json:"website" validate:"omitempty,url"`
+	ContactEmail *string `json:"contact_email" validate:"omitempty,email"`
+	ContactPhone *string `json:"contact_phone" validate:"omitempty,e164"`
+}
+
+func (r *EditProfileRequest) Bind(c *fiber.Ctx, v *validator.Validate) error {
+	if err := c.BodyParser(r); err != nil {
+		return err
+	}
+	return v.Struct(r)
+}
+
+// SetExportEmailConsentRequest is an operator turning the export of user
+// emails on or off; operator and reason go to the audit log.
+type SetExportEmailConsentRequest struct {
+	Consent  *bool  `json:"consent" validate:"required"`
+	Operator string `json:"operator" validate:"required,max=255"`
+	Reason   string `json:"reason" validate:"required,max=1000"`
+}
+
+func (r *SetExportEmailConsentRequest) Bind(c *fiber.Ctx, v *validator.Validate) error {
+	if err := c.BodyParser(r); err != nil {
+		return err
+	}
+	return v.Struct(r)
+}
+
+type OfficialReplyRequest struct {
+	Content  string     `json:"text" validate:"required,min=10,max=1000"`
+	ParentID *uuid.UUID `json:"parent_id"`
+}
+
+func (r *OfficialReplyRequest) Bind(c *fiber.Ctx, v *validator.Validate) error {
+	if err := c.BodyParser(r); err != nil {
+		return err
+	}
+	return v.Struct(r)
+}
+
+type GetModerationQueueQueryParams struct {
+	Status string `query:"status" validate:"omitempty,oneof=reported hidden"`
+	Limit  *int   `query:"limit" validate:"omitempty,gte=0"`
+	Offset int    `query:"offset" validate:"omitempty,gte=0"`
+}
+
+func (r *GetModerationQueueQueryParams) Bind(c *fiber.Ctx, v *validator.Validate) error {
+	if err := c.QueryParser(r); err != nil {
+		return err
+	}
+	return v.Struct(r)
+}
+
+type UsageStatisticQueryParams struct {
+	From        string `query:"from"`
+	To          string `query:"to"`
+	Granularity string `query:"granularity" validate:"omitempty,oneof=hour day week"`
+}
+
+func (r *UsageStatisticQueryParams) Bind(c *fiber.Ctx, v *validator.Validate) error {
+	if err := c.QueryParser(r); err != nil {
+		return err
+	}
+	return v.Struct(r)
+}
+
+// ToStatsParams accepts RFC 3339 timestamps or dates. A date in to includes
+// the whole day.
+func (r *UsageStatisticQueryParams) ToStatsParams() (*promocode.StatsParams, error) {
+	params := &promocode.StatsParams{Granularity: r.Granularity}
+	var err error
+	if r.From != "" {
+		if params.From, err = parseStatsTime(r.From); err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+	}
+	if r.To != "" {
+		if params.To, err = parseStatsTime(r.To); err != nil {
+			return nil, fmt.Errorf("to: %w", err)
+		}
+		if len(r.To) == len(time.DateOnly) {
+			params.To = params.To.AddDate(0, 0, 1)
+		}
+	}
+	return params, nil
+}
+
+type FunnelQueryParams struct {
+	From string `query:"from"`
+	To   string `query:"to"`
+}
+
+func (r *FunnelQueryParams) Bind(c *fiber.Ctx, v *validator.Validate) error {
+	if err := c.QueryParser(r); err != nil {
+		return err
+	}
+	return v.Struct(r)
+}
+
+// Window returns [from, to), the last defaultFunnelDays days by default.
+func (r *FunnelQueryParams) Window() (time.Time, time.Time, error) {
+	return statsWindow(r.From, r.To, defaultFunnelDays, maxStatsWindowDays)
+}
+
+const defaultFunnelDays = 30
+
+type CompanyStatsQueryParams struct {
+	From string `query:"from"`
+	To   string `query:"to"`
+}
+
+func (r *CompanyStatsQueryParams) Bind(c *fiber.Ctx, v *validator.Validate) error {
+	if err := c.QueryParser(r); err != nil {
+		return err
+	}
+	return v.Struct(r)
+}
+
+// Window returns [from, to), the last defaultCompanyStatsDays days by default.
+func (r *CompanyStatsQueryParams) Window() (time.Time, time.Time, error) {
+	return statsWindow(r.From, r.To, defaultCompanyStatsDays, maxStatsWindowDays)
+}
+
+const defaultCompanyStatsDays = 30
+
+// maxStatsWindowDays bounds the dashboard and funnel windows, whose daily
+// series has a bucket per day, like the usage series of a promo code.
+const maxStatsWindowDays = 1000
+
+// statsWindow reads from and to as in ToStatsParams; a missing to is now and
+// a missing from is days before to. A window longer than maxDays is
+// rejected, zero means no limit.
+func statsWindow(fromParam, toParam string, days int, maxDays int) (time.Time, time.Time, error) {
+	to := time.Now()
+	if toParam != "" {
+		t, err := parseStatsTime(toParam)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("to: %w", err)
+		}
+		if len(toParam) == len(time.DateOnly) {
+			t = t.AddDate(0, 0, 1)
+		}
+		to = t
+	}
+	from := to.AddDate(0, 0, -days)
+	if fromParam != "" {
+		t, err := parseStatsTime(fromParam)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("from: %w", err)
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must be before to")
+	}
+	if maxDays > 0 && to.Sub(from) > time.Duration(maxDays)*24*time.Hour {
+		return time.Time{}, time.Time{}, fmt.Errorf("window must not be longer than %d days", maxDays)
+	}
+	return from, to, nil
+}
+
+type RedeemPromoRequest struct {
+	Code   string     `json:"code" validate:"required,min=1,max=30"`
+	UserID *uuid.UUID `json:"user_id"`
+}
+
+func (r *RedeemPromoRequest) Bind(c *fiber.Ctx, v *validator.Validate) error {
+	if err := c.BodyParser(r); err != nil {
+		return err
+	}
+	return v.Struct(r)
+}
+
+type ExportUsesQueryParams struct {
+	Format string `query:"format" validate:"omitempty,oneof=csv xlsx ndjson"`
+}
+
+func (r *ExportUsesQueryParams) Bind(c *fiber.Ctx, v *validator.Validate) error {
+	if err := c.QueryParser(r); err != nil {
+		return err
+	}
+	if r.Format == "" {
+		r.Format = export.CSV
+	}
+	return v.Struct(r)
+}
+
+type ExportStatsQueryParams struct {
+	Format string `query:"format" validate:"omitempty,oneof=csv xlsx ndjson"`
+	From   string `query:"from"`
+	To     string `query:"to"`
+}
+
+func (r *ExportStatsQueryParams) Bind(c *fiber.Ctx, v *validator.Validate) error {
+	if err := c.QueryParser(r); err != nil {
+		return err
+	}
+	if r.Format == "" {
+		r.Format = export.CSV
+	}
+	return v.Struct(r)
+}
+
+// Window is the window of the dashboard by default. It has no upper bound,
+// the export streams the rollups there are.
+func (r *ExportStatsQueryParams) Window() (time.Time, time.Time, error) {
+	return statsWindow(r.From, r.To, defaultCompanyStatsDays, 0)
+}
+
+func parseStatsTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
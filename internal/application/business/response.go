@@ -2,6 +2,7 @@ package business
 
 import (
 	"github.com/google/uuid"
+	"io"
 	"solution/internal/domain/promocode"
 	"solution/internal/domain/types"
 )
@@ -41,3 +42,11 @@ type EditPromoCodeResponse struct {
 	ActiveFrom  types.SolutionDate `json:"active_from"`
 	ActiveUntil types.SolutionDate `json:"active_until"`
 }
+
+// ExportResponse is a file streamed to the client by Write once the handler
+// has returned.
+type ExportResponse struct {
+	Filename    string
+	ContentType string
+	Write       func(w io.Writer) error
+}
@@ -1,29 +1,37 @@
 package business
 
 import (
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/lib/pq"
 	"go.uber.org/zap"
+	"io"
 	"solution/config"
 	"solution/internal/domain/business"
+	"solution/internal/domain/category"
 	customerrors "solution/internal/domain/errors"
 	"solution/internal/domain/promocode"
+	"solution/internal/pkg/export"
+	"solution/pkg"
+	"strconv"
 	"strings"
 	"time"
 )
 
 type ApplicationService struct {
-	ds      *business.DomainService
-	promoDS *promocode.DomainService
-	cfg     *config.Config
+	ds         *business.DomainService
+	promoDS    *promocode.DomainService
+	categoryDS *category.DomainService
+	cfg        *config.Config
 }
 
 func NewApplicationService(
 	ds *business.DomainService,
 	promoDS *promocode.DomainService,
+	categoryDS *category.DomainService,
 	cfg *config.Config,
 ) *ApplicationService {
-	return &ApplicationService{ds: ds, promoDS: promoDS, cfg: cfg}
+	return &ApplicationService{ds: ds, promoDS: promoDS, categoryDS: categoryDS, cfg: cfg}
 }
 
 func (s *ApplicationService) SignUp(
@@ -57,6 +65,63 @@ func (s *ApplicationService) SignIn(
 	}, nil
 }
 
+func (s *ApplicationService) GetProfile(sub uuid.UUID) (*business.Profile, *customerrors.DomainError) {
+	b, err := s.ds.GetByID(sub)
+	if err != nil {
+		return nil, err
+	}
+	return b.ToProfile(), nil
+}
+
+func (s *ApplicationService) EditProfile(sub uuid.UUID, request *EditProfileRequest) (
+	*business.Profile,
+	*customerrors.DomainError,
+) {
+	b, err := s.ds.GetByID(sub)
+	if err != nil {
+		return nil, err
+	}
+	if request.Name != nil {
+		b.CompanyName = *request.Name
+	}
+	if request.LogoURL != nil {
+		b.LogoURL = request.LogoURL
+	}
+	if request.Description != nil {
+		b.Description = request.Description
+	}
+	if request.Website != nil {
+		b.Website = request.Website
+	}
+	if request.ContactEmail != nil {
+		b.ContactEmail = request.ContactEmail
+	}
+	if request.ContactPhone != nil {
+		b.ContactPhone = request.ContactPhone
+	}
+	if err := s.ds.Save(b); err != nil {
+		return nil, err
+	}
+	return b.ToProfile(), nil
+}
+
+// SetExportEmailConsent is for operators only: the business itself can't
+// turn off the pseudonyms that protect users from it.
+func (s *ApplicationService) SetExportEmailConsent(id uuid.UUID, request *SetExportEmailConsentRequest) (
+	*business.Profile,
+	*customerrors.DomainError,
+) {
+	b, err := s.ds.SetExportEmailConsent(id, *request.Consent, request.Operator, request.Reason)
+	if err != nil {
+		return nil, err
+	}
+	zap.S().Infow(
+		"export email consent changed",
+		"business", id, "consent", *request.Consent, "operator", request.Operator, "reason", request.Reason,
+	)
+	return b.ToProfile(), nil
+}
+
 func (s *ApplicationService) CreatePromoCode(
 	sub uuid.UUID,
 	request *CreatePromoCodeRequest,
@@ -77,6 +142,13 @@ func (s *ApplicationService) CreatePromoCode(
 	if er != nil {
 		return nil, customerrors.NotFound(er.Message)
 	}
+	if request.Target.Categories != nil {
+		slugs, er := s.categoryDS.Canonicalize(*request.Target.Categories)
+		if er != nil {
+			return nil, er
+		}
+		request.Target.Categories = &slugs
+	}
 	var promoID uuid.UUID
 	var countryLower *string
 	if request.Target.Country != nil {
@@ -108,6 +180,7 @@ func (s *ApplicationService) CreatePromoCode(
 			ActiveUntil:           &activeUntil,
 			Mode:                  promocode.COMMON,
 			TargetCategoriesLower: (*pq.StringArray)(&categoriesLower),
+			AntifraudFailPolicy:   request.AntifraudFailPolicy,
 		}
 		_ = s.promoDS.Create(promo)
 		promoID = promo.ID
@@ -129,6 +202,7 @@ func (s *ApplicationService) CreatePromoCode(
 			ActiveUntil:           &activeUntil,
 			Mode:                  promocode.UNIQUE,
 			TargetCategoriesLower: (*pq.StringArray)(&categoriesLower),
+			AntifraudFailPolicy:   request.AntifraudFailPolicy,
 		}
 		_ = s.promoDS.Create(promo)
 		promoID = promo.ID
@@ -156,6 +230,13 @@ func (s *ApplicationService) EditPromoCode(
 	if promo.Mode == promocode.UNIQUE && request.MaxCount != nil {
 		return nil, customerrors.BadRequest("max count")
 	}
+	if request.Target != nil && request.Target.Categories != nil {
+		slugs, er := s.categoryDS.Canonicalize(*request.Target.Categories)
+		if er != nil {
+			return nil, er
+		}
+		request.Target.Categories = &slugs
+	}
 	zap.S().Infow("EditPromoCode", "request", request)
 	p, d, er := s.promoDS.Update(promo.ID, (*promocode.UpdatePromoCode)(request))
 	if er != nil {
@@ -172,43 +253,114 @@ func (s *ApplicationService) EditPromoCode(
 func (s *ApplicationService) GetAllPromoCodes(
 	sub uuid.UUID,
 	p *GetPromoCodesQueryParams,
-) ([]map[string]interface{}, int, *customerrors.DomainError) {
+) ([]map[string]interface{}, int, string, *customerrors.DomainError) {
 	company, err := s.ds.GetByID(sub)
 	if err != nil {
-		return nil, 0, customerrors.NotFound()
+		return nil, 0, "", customerrors.NotFound()
 	}
 	zap.S().Debugf("GetAllPromoCodes: %+v", p)
-	res, c := s.promoDS.GetByCompanyID(company.ID, p.Limit, p.Offset, p.SortBy, p.Countries)
-	return res, c, nil
+	res, c, etag := s.promoDS.GetByCompanyID(company.ID, p.Limit, p.Offset, p.SortBy, p.Countries)
+	return res, c, etag, nil
+}
+
+func (s *ApplicationService) PromoCodesLastModified(sub uuid.UUID) time.Time {
+	return s.promoDS.CompanyLastModified(sub)
 }
 
 func (s *ApplicationService) GetPromoCode(
 	sub uuid.UUID,
 	promoID uuid.UUID,
-) (map[string]interface{}, *customerrors.DomainError) {
+) (map[string]interface{}, *promocode.Revision, *customerrors.DomainError) {
 	company, err := s.ds.GetByID(sub)
 	if err != nil {
 		zap.S().Info(err.Message)
-		return nil, customerrors.NotFound()
+		return nil, nil, customerrors.NotFound()
 	}
 	p, d, er := s.promoDS.Get2(promoID)
 	if er != nil {
 		zap.S().Info(er.Message)
-		return nil, customerrors.NotFound()
+		return nil, nil, customerrors.NotFound()
 	}
 	if p.CompanyID != company.ID {
-		return nil, customerrors.Forbidden()
+		return nil, nil, customerrors.Forbidden()
+	}
+	rev := &promocode.Revision{
+		ETag:         p.ETag(strconv.FormatBool(d.Active)),
+		LastModified: p.LastModified(),
 	}
 	if p.Mode == promocode.UNIQUE {
-		return p.ToOwnerViewUNIQUE(d.Active, d.Likes, d.Uses), nil
+		return p.ToOwnerViewUNIQUE(d.Active, d.Likes, d.Uses), rev, nil
 	} else if p.Mode == promocode.COMMON {
-		return p.ToOwnerViewCOMMON(d.Active, d.Likes, d.Uses), nil
+		return p.ToOwnerViewCOMMON(d.Active, d.Likes, d.Uses), rev, nil
 	}
 	zap.S().Info(p.Mode)
-	return nil, customerrors.NotFound()
+	return nil, nil, customerrors.NotFound()
 }
 
-func (s *ApplicationService) GetUsageStatistic(sub uuid.UUID, promo uuid.UUID) (
+func (s *ApplicationService) OfficialReply(sub uuid.UUID, promo uuid.UUID, request *OfficialReplyRequest) (
+	*promocode.CommentView,
+	*customerrors.DomainError,
+) {
+	p, err := s.promoDS.Get(promo)
+	if err != nil {
+		return nil, customerrors.NotFound()
+	}
+	if p.CompanyID != sub {
+		return nil, customerrors.Forbidden()
+	}
+	return s.promoDS.OfficialReply(promo, sub, request.Content, request.ParentID)
+}
+
+func (s *ApplicationService) DeleteOfficialReply(sub uuid.UUID, comment uuid.UUID, promo uuid.UUID) *customerrors.DomainError {
+	c, err := s.promoDS.FindComment(comment, promo)
+	if err != nil {
+		return err
+	}
+	if c.UserID != sub || c.AuthorType != promocode.CommentAuthorBusiness {
+		return customerrors.Forbidden()
+	}
+	return s.promoDS.DeleteComment(comment, promo)
+}
+
+func (s *ApplicationService) SetCommentHidden(sub uuid.UUID, comment uuid.UUID, promo uuid.UUID, hidden bool) *customerrors.DomainError {
+	p, err := s.promoDS.Get(promo)
+	if err != nil {
+		return customerrors.NotFound()
+	}
+	if p.CompanyID != sub {
+		return customerrors.Forbidden()
+	}
+	return s.promoDS.SetCommentHidden(comment, promo, hidden)
+}
+
+// GetCommentHistory shows previous texts of any comment on the company's promo.
+func (s *ApplicationService) GetCommentHistory(sub uuid.UUID, comment uuid.UUID, promo uuid.UUID) (
+	[]*promocode.CommentEditView,
+	*customerrors.DomainError,
+) {
+	p, err := s.promoDS.Get(promo)
+	if err != nil {
+		return nil, customerrors.NotFound()
+	}
+	if p.CompanyID != sub {
+		return nil, customerrors.Forbidden()
+	}
+	if _, err := s.promoDS.FindComment(comment, promo); err != nil {
+		return nil, err
+	}
+	return s.promoDS.CommentHistory(comment), nil
+}
+
+func (s *ApplicationService) GetModerationQueue(sub uuid.UUID, params *GetModerationQueueQueryParams) (
+	[]*promocode.ModerationItem,
+	int,
+	*customerrors.DomainError,
+) {
+	items, count := s.promoDS.ModerationQueue(sub, params.Status == "hidden", params.Limit, params.Offset)
+	return items, count, nil
+}
+
+func (s *ApplicationService) GetFunnel(sub uuid.UUID, promo uuid.UUID, params *FunnelQueryParams) (
 	map[string]interface{},
 	*customerrors.DomainError,
 ) {
@@ -219,5 +371,219 @@ func (s *ApplicationService) GetUsageStatistic(sub uuid.UUID, promo uuid.UUID) (
 	if p.CompanyID != sub {
 		return nil, customerrors.Forbidden()
 	}
-	return s.promoDS.UsageStatistic(promo), nil
+	from, to, er := params.Window()
+	if er != nil {
+		return nil, customerrors.BadRequest(er.Error())
+	}
+	r := s.promoDS.Funnel(sub, promo, from, to)
+	r["from"], r["to"] = from, to
+	return r, nil
+}
+
+func (s *ApplicationService) GetCompanyFunnel(sub uuid.UUID, params *FunnelQueryParams) (
+	map[string]interface{},
+	*customerrors.DomainError,
+) {
+	from, to, er := params.Window()
+	if er != nil {
+		return nil, customerrors.BadRequest(er.Error())
+	}
+	r := s.promoDS.CompanyFunnel(sub, from, to)
+	r["from"], r["to"] = from, to
+	return r, nil
 }
+
+// RedeemPromoCode marks an activated code as used at the point of sale.
+func (s *ApplicationService) RedeemPromoCode(sub uuid.UUID, promo uuid.UUID, request *RedeemPromoRequest) (
+	map[string]interface{},
+	*customerrors.DomainError,
+) {
+	p, _, err := s.promoDS.Get2(promo)
+	if err != nil {
+		return nil, customerrors.NotFound()
+	}
+	if p.CompanyID != sub {
+		return nil, customerrors.Forbidden()
+	}
+	use, err := s.promoDS.Redeem(promo, request.Code, request.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"promo_id":     use.PromoCodeID,
+		"user_id":      use.UserID,
+		"code":         use.Code,
+		"activated_at": use.CreatedAt,
+		"redeemed_at":  use.RedeemedAt,
+	}, nil
+}
+
+func (s *ApplicationService) GetUsageStatistic(sub uuid.UUID, promo uuid.UUID, params *UsageStatisticQueryParams) (
+	map[string]interface{},
+	*customerrors.DomainError,
+) {
+	p, _, err := s.promoDS.Get2(promo)
+	if err != nil {
+		return nil, customerrors.NotFound()
+	}
+	if p.CompanyID != sub {
+		return nil, customerrors.Forbidden()
+	}
+	window, er := params.ToStatsParams()
+	if er != nil {
+		return nil, customerrors.BadRequest(er.Error())
+	}
+	stats, err := s.promoDS.UsageStatistic(promo, window)
+	if err != nil {
+		return nil, err
+	}
+	stats["rating"] = p.RatingSummary()
+	stats["followers"] = s.followers(sub)
+	return stats, nil
+}
+
+// GetCompanyStatistic is the dashboard across all promo codes of the company.
+func (s *ApplicationService) GetCompanyStatistic(sub uuid.UUID, params *CompanyStatsQueryParams) (
+	map[string]interface{},
+	*customerrors.DomainError,
+) {
+	from, to, err := params.Window()
+	if err != nil {
+		return nil, customerrors.BadRequest(err.Error())
+	}
+	// The dashboard is by whole days, to is exclusive.
+	stats := s.promoDS.CompanyStatistic(sub, from, to.Add(-time.Nanosecond))
+	stats["followers"] = s.followers(sub)
+	return stats, nil
+}
+
+var (
+	useExportColumns = []string{
+		"activated_at", "country", "age_band", "code", "redeemed", "redeemed_at", "user",
+	}
+	statsExportColumns = []string{"date", "promo_id", "country", "age_band", "activations_count"}
+)
+
+// ExportUses exports every activation of the promo code. Users are
+// identified by a pseudonym unless the business consented to get emails.
+func (s *ApplicationService) ExportUses(sub uuid.UUID, promo uuid.UUID, params *ExportUsesQueryParams) (
+	*ExportResponse,
+	*customerrors.DomainError,
+) {
+	p, _, err := s.promoDS.Get2(promo)
+	if err != nil {
+		return nil, customerrors.NotFound()
+	}
+	if p.CompanyID != sub {
+		return nil, customerrors.Forbidden()
+	}
+	b, err := s.ds.GetByID(sub)
+	if err != nil {
+		return nil, err
+	}
+	if !b.ExportEmailConsent && s.cfg.ExportPseudonymKey == "" {
+		return nil, &customerrors.DomainError{Code: 503, Message: "export pseudonym key is not configured"}
+	}
+	user := func(u *promocode.UseRecord) string {
+		if u.Email == "" || b.ExportEmailConsent {
+			return u.Email
+		}
+		return pkg.Pseudonym(s.cfg.ExportPseudonymKey, u.Email)
+	}
+	return &ExportResponse{
+		Filename:    "promo-" + promo.String() + "-activations." + params.Format,
+		ContentType: export.ContentType(params.Format),
+		Write: func(w io.Writer) error {
+			out, err := export.New(params.Format, w, useExportColumns)
+			if err != nil {
+				return err
+			}
+			err = s.promoDS.StreamUses(
+				promo, func(u *promocode.UseRecord) error {
+					return out.Row(
+						u.CreatedAt, u.Country, u.AgeBand, u.Code, u.RedeemedAt != nil, u.RedeemedAt, user(u),
+					)
+				},
+			)
+			if err != nil {
+				return err
+			}
+			return out.Close()
+		},
+	}, nil
+}
+
+// ExportStatistic exports the daily rollups behind the dashboard.
+func (s *ApplicationService) ExportStatistic(sub uuid.UUID, params *ExportStatsQueryParams) (
+	*ExportResponse,
+	*customerrors.DomainError,
+) {
+	from, to, er := params.Window()
+	if er != nil {
+		return nil, customerrors.BadRequest(er.Error())
+	}
+	to = to.Add(-time.Nanosecond)
+	return &ExportResponse{
+		Filename: fmt.Sprintf(
+			"stats-%s-%s.%s", from.UTC().Format(time.DateOnly), to.UTC().Format(time.DateOnly), params.Format,
+		),
+		ContentType: export.ContentType(params.Format),
+		Write: func(w io.Writer) error {
+			out, err := export.New(params.Format, w, statsExportColumns)
+			if err != nil {
+				return err
+			}
+			err = s.promoDS.StreamRollups(
+				sub, from, to, func(r *promocode.DailyRollup) error {
+					return out.Row(r.Day.Format(time.DateOnly), r.PromoCodeID, r.Country, r.AgeBand, r.Activations)
+				},
+			)
+			if err != nil {
+				return err
+			}
+			return out.Close()
+		},
+	}, nil
+}
+
+// StreamPromoCode subscribes the company to the live events of its promo
+// code, resuming after lastEventID when the client sent one.
+func (s *ApplicationService) StreamPromoCode(sub uuid.UUID, promo uuid.UUID, lastEventID string) (
+	*promocode.Subscription,
+	*customerrors.DomainError,
+) {
+	p, _, err := s.promoDS.Get2(promo)
+	if err != nil {
+		return nil, customerrors.NotFound()
+	}
+	if p.CompanyID != sub {
+		return nil, customerrors.Forbidden()
+	}
+	var after uint64
+	if lastEventID != "" {
+		id, er := strconv.ParseUint(lastEventID, 10, 64)
+		if er != nil {
+			return nil, customerrors.BadRequest("Last-Event-ID " + er.Error())
+		}
+		after = id
+	}
+	return s.promoDS.Subscribe(sub, promo, after)
+}
+
+func (s *ApplicationService) followers(sub uuid.UUID) map[string]interface{} {
+	var growth []map[string]interface{}
+	for _, d := range s.ds.FollowerGrowth(sub, followerGrowthDays) {
+		growth = append(
+			growth, map[string]interface{}{
+				"date":          d.Day.Format(time.DateOnly),
+				"new_followers": d.Count,
+			},
+		)
+	}
+	return map[string]interface{}{
+		"count":  s.ds.FollowersCount(sub),
+		"growth": growth,
+	}
+}
+
+const followerGrowthDays = 30
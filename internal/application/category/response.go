@@ -0,0 +1,9 @@
+package category
+
+type CategoryResponse struct {
+	Slug       string              `json:"slug"`
+	Name       string              `json:"name"`
+	Synonyms   []string            `json:"synonyms,omitempty"`
+	PromoCount int                 `json:"promo_count"`
+	Children   []*CategoryResponse `json:"children,omitempty"`
+}
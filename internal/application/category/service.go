@@ -0,0 +1,38 @@
+package category
+
+import (
+	"solution/internal/domain/category"
+	"solution/internal/domain/promocode"
+)
+
+type ApplicationService struct {
+	ds      *category.DomainService
+	promoDS *promocode.DomainService
+}
+
+func NewApplicationService(ds *category.DomainService, promoDS *promocode.DomainService) *ApplicationService {
+	return &ApplicationService{ds: ds, promoDS: promoDS}
+}
+
+// GetCategories returns the catalog tree. A promo count includes promo codes
+// tagged with any descendant category.
+func (s *ApplicationService) GetCategories(locale string) []*CategoryResponse {
+	counts := s.promoDS.CategoryCounts(s.ds.Subtrees())
+	var build func(nodes []*category.Node) []*CategoryResponse
+	build = func(nodes []*category.Node) []*CategoryResponse {
+		result := make([]*CategoryResponse, 0, len(nodes))
+		for _, n := range nodes {
+			result = append(
+				result, &CategoryResponse{
+					Slug:       n.Category.Slug,
+					Name:       n.Category.Name(locale),
+					Synonyms:   n.Category.Synonyms,
+					PromoCount: counts[n.Category.Slug],
+					Children:   build(n.Children),
+				},
+			)
+		}
+		return result
+	}
+	return build(s.ds.Tree())
+}
@@ -1,6 +1 @@
 package promocode
-
-import (
-	"solution/internal/domain/promocode"
-	"solution/internal/domain/types"
-)
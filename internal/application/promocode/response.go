@@ -1,7 +1 @@
 package promocode
-
-import (
-	"github.com/google/uuid"
-	"solution/internal/domain/promocode"
-	"solution/internal/domain/types"
-)
@@ -3,6 +3,7 @@ package user
 import (
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 )
 
 type CreateUserRequest struct {
@@ -51,10 +52,12 @@ func (r *EditProfileRequest) Bind(c *fiber.Ctx, v *validator.Validate) error {
 }
 
 type GetPromoFeedQueryParams struct {
-	Limit    *int   `json:"limit" validate:"omitempty,gte=0"`
-	Offset   int    `json:"offset" validate:"omitempty,gte=0"`
-	Category string `json:"category"`
-	Active   *bool  `json:"active"`
+	Limit     *int   `json:"limit" validate:"omitempty,gte=0"`
+	Offset    int    `json:"offset" validate:"omitempty,gte=0"`
+	Category  string `json:"category"`
+	Active    *bool  `json:"active"`
+	Following bool   `json:"following"`
+	Sort      string `json:"sort" validate:"omitempty,oneof=newest rating"`
 }
 
 func (r *GetPromoFeedQueryParams) Bind(c *fiber.Ctx, v *validator.Validate) error {
@@ -65,7 +68,8 @@ func (r *GetPromoFeedQueryParams) Bind(c *fiber.Ctx, v *validator.Validate) erro
 }
 
 type CommentPromoRequest struct {
-	Content string `json:"text" validate:"required,min=10,max=1000"`
+	Content  string     `json:"text" validate:"required,min=10,max=1000"`
+	ParentID *uuid.UUID `json:"parent_id"`
 }
 
 func (r *CommentPromoRequest) Bind(c *fiber.Ctx, v *validator.Validate) error {
@@ -74,3 +78,79 @@ func (r *CommentPromoRequest) Bind(c *fiber.Ctx, v *validator.Validate) error {
 	}
 	return v.Struct(r)
 }
+
+type GetCommentsQueryParams struct {
+	Limit  *int   `query:"limit" validate:"omitempty,gte=0"`
+	Offset int    `query:"offset" validate:"omitempty,gte=0"`
+	Sort   string `query:"sort" validate:"omitempty,oneof=newest most_reacted"`
+}
+
+func (r *GetCommentsQueryParams) Bind(c *fiber.Ctx, v *validator.Validate) error {
+	if err := c.QueryParser(r); err != nil {
+		return err
+	}
+	return v.Struct(r)
+}
+
+type GetCompanyQueryParams struct {
+	Limit  *int `query:"limit" validate:"omitempty,gte=0"`
+	Offset int  `query:"offset" validate:"omitempty,gte=0"`
+}
+
+func (r *GetCompanyQueryParams) Bind(c *fiber.Ctx, v *validator.Validate) error {
+	if err := c.QueryParser(r); err != nil {
+		return err
+	}
+	return v.Struct(r)
+}
+
+type GetWalletQueryParams struct {
+	Limit  *int `query:"limit" validate:"omitempty,gte=0"`
+	Offset int  `query:"offset" validate:"omitempty,gte=0"`
+}
+
+func (r *GetWalletQueryParams) Bind(c *fiber.Ctx, v *validator.Validate) error {
+	if err := c.QueryParser(r); err != nil {
+		return err
+	}
+	return v.Struct(r)
+}
+
+// defaultExpiringDays is the window of the expiring wallet endpoint when no
+// days parameter is given.
+const defaultExpiringDays = 3
+
+type GetExpiringWalletQueryParams struct {
+	Days *int `query:"days" validate:"omitempty,gte=0,lte=365"`
+}
+
+func (r *GetExpiringWalletQueryParams) Bind(c *fiber.Ctx, v *validator.Validate) error {
+	if err := c.QueryParser(r); err != nil {
+		return err
+	}
+	return v.Struct(r)
+}
+
+type ReportCommentRequest struct {
+	Reason  string `json:"reason" validate:"required,oneof=spam abuse off_topic misleading other"`
+	Details string `json:"details" validate:"max=500"`
+}
+
+func (r *ReportCommentRequest) Bind(c *fiber.Ctx, v *validator.Validate) error {
+	if err := c.BodyParser(r); err != nil {
+		return err
+	}
+	return v.Struct(r)
+}
+
+type RatePromoRequest struct {
+	Stars   int    `json:"stars" validate:"required,gte=1,lte=5"`
+	Content string `json:"text" validate:"omitempty,min=10,max=1000"`
+}
+
+func (r *RatePromoRequest) Bind(c *fiber.Ctx, v *validator.Validate) error {
+	if err := c.BodyParser(r); err != nil {
+		return err
+	}
+	return v.Struct(r)
+}
@@ -4,18 +4,29 @@ import (
 	"github.com/google/uuid"
 	"github.com/intezya/pkglib"
 	"go.uber.org/zap"
+	"solution/internal/domain/business"
+	"solution/internal/domain/category"
 	customerrors "solution/internal/domain/errors"
 	"solution/internal/domain/promocode"
 	"solution/internal/domain/user"
+	"strconv"
+	"strings"
 )
 
 type ApplicationService struct {
-	ds      *user.DomainService
-	promoDS *promocode.DomainService
+	ds         *user.DomainService
+	promoDS    *promocode.DomainService
+	categoryDS *category.DomainService
+	businessDS *business.DomainService
 }
 
-func NewApplicationService(ds *user.DomainService, promoDS *promocode.DomainService) *ApplicationService {
-	return &ApplicationService{ds: ds, promoDS: promoDS}
+func NewApplicationService(
+	ds *user.DomainService,
+	promoDS *promocode.DomainService,
+	categoryDS *category.DomainService,
+	businessDS *business.DomainService,
+) *ApplicationService {
+	return &ApplicationService{ds: ds, promoDS: promoDS, categoryDS: categoryDS, businessDS: businessDS}
 }
 
 func (s *ApplicationService) SignUp(request *CreateUserRequest) (*CreateUserResponse, *customerrors.DomainError) {
@@ -105,47 +116,158 @@ func (s *ApplicationService) EditProfile(sub uuid.UUID, request *EditProfileRequ
 func (s *ApplicationService) GetFeed(sub uuid.UUID, params *GetPromoFeedQueryParams) (
 	[]map[string]interface{},
 	int,
+	string,
 	*customerrors.DomainError,
 ) {
 	u, err := s.ds.GetByID(sub)
 	if err != nil {
-		return nil, 0, err
-	}
-	res, c := s.promoDS.GetFeed(
-		sub,
-		params.Limit,
-		params.Offset,
-		params.Category,
-		params.Active,
-		u.Age,
-		u.Country,
+		return nil, 0, "", err
+	}
+	var categories []string
+	if params.Category != "" {
+		var ok bool
+		if categories, ok = s.categoryDS.Expand(params.Category); !ok {
+			// Promo codes created before the catalog may carry free-form categories.
+			categories = []string{strings.ToLower(params.Category)}
+		}
+	}
+	feed := &promocode.GetAsUserFeedParams{
+		Limit:      params.Limit,
+		Offset:     params.Offset,
+		Categories: categories,
+		Active:     params.Active,
+		Age:        u.Age,
+		Country:    u.Country,
+	}
+	if params.Sort == promocode.SortByRating {
+		feed.SortBy = promocode.SortByRating
+	}
+	following := s.businessDS.Following(sub)
+	if params.Following {
+		feed.CompanyIDs = following
+	} else {
+		feed.BoostCompanyIDs = following
+	}
+	res, c, etag := s.promoDS.GetFeed(sub, feed)
+	return res, c, etag, nil
+}
+
+func (s *ApplicationService) GetCompany(sub uuid.UUID, companyID uuid.UUID, params *GetCompanyQueryParams) (
+	map[string]interface{},
+	*customerrors.DomainError,
+) {
+	u, err := s.ds.GetByID(sub)
+	if err != nil {
+		return nil, err
+	}
+	company, err := s.businessDS.GetByID(companyID)
+	if err != nil {
+		return nil, customerrors.NotFound()
+	}
+	active := true
+	promos, count, _ := s.promoDS.GetFeed(
+		sub, &promocode.GetAsUserFeedParams{
+			Limit:      params.Limit,
+			Offset:     params.Offset,
+			Active:     &active,
+			Age:        u.Age,
+			Country:    u.Country,
+			CompanyIDs: []uuid.UUID{company.ID},
+		},
 	)
-	return res, c, nil
+	r := company.ToPublicView(s.businessDS.FollowersCount(company.ID))
+	r["is_followed_by_user"] = s.businessDS.IsFollowing(company.ID, sub)
+	r["like_count"] = s.promoDS.CompanyLikes(company.ID)
+	r["active_promo_count"] = count
+	r["promos"] = promos
+	return r, nil
+}
+
+func (s *ApplicationService) FollowCompany(sub uuid.UUID, companyID uuid.UUID) *customerrors.DomainError {
+	if _, err := s.businessDS.GetByID(companyID); err != nil {
+		return customerrors.NotFound()
+	}
+	return s.businessDS.Follow(companyID, sub)
+}
+
+func (s *ApplicationService) UnfollowCompany(sub uuid.UUID, companyID uuid.UUID) *customerrors.DomainError {
+	if _, err := s.businessDS.GetByID(companyID); err != nil {
+		return customerrors.NotFound()
+	}
+	return s.businessDS.Unfollow(companyID, sub)
 }
 
 func (s *ApplicationService) GetPromoCode(sub uuid.UUID, promo uuid.UUID) (
 	map[string]interface{},
+	*promocode.Revision,
 	*customerrors.DomainError,
 ) {
 	p, d, err := s.promoDS.Get2(promo)
 	if err != nil {
-		return nil, customerrors.NotFound()
+		return nil, nil, customerrors.NotFound()
+	}
+	activated := s.promoDS.Activated(p.ID, sub)
+	liked := s.promoDS.Liked(p.ID, sub)
+	saved := s.promoDS.Saved(p.ID, sub)
+	stars := s.promoDS.UserRating(p.ID, sub)
+	s.promoDS.RecordView(p.ID, sub)
+	rev := &promocode.Revision{
+		ETag: p.ETag(
+			strconv.FormatBool(d.Active),
+			strconv.FormatBool(activated),
+			strconv.FormatBool(liked),
+			strconv.FormatBool(saved),
+			strconv.Itoa(stars),
+		),
+		LastModified: p.LastModified(),
 	}
 
-	return p.ToUserView(
+	r := p.ToUserView(
 		d.Active,
-		s.promoDS.Activated(p.ID, sub),
-		s.promoDS.Liked(p.ID, sub),
+		activated,
+		liked,
+		saved,
 		d.Likes,
 		d.Comments,
-	), nil
+	)
+	if stars > 0 {
+		r["user_rating"] = stars
+	}
+	return r, rev, nil
 }
 
+func (s *ApplicationService) RatePromoCode(sub uuid.UUID, promo uuid.UUID, request *RatePromoRequest) (
+	map[string]interface{},
+	*customerrors.DomainError,
+) {
+	rating, comment, err := s.promoDS.Rate(promo, sub, request.Stars, request.Content)
+	if err != nil {
+		return nil, err
+	}
+	r := map[string]interface{}{
+		"stars": rating.Stars,
+	}
+	if comment != nil {
+		r["comment"] = comment
+	}
+	if p, err := s.promoDS.Get(promo); err == nil {
+		r["rating"] = p.RatingSummary()
+	}
+	return r, nil
+}
+
+func (s *ApplicationService) UnratePromoCode(sub uuid.UUID, promo uuid.UUID) *customerrors.DomainError {
+	return s.promoDS.Unrate(promo, sub)
+}
+
+// LikePromoCode is idempotent: liking a promo code twice is not an error.
 func (s *ApplicationService) LikePromoCode(sub uuid.UUID, promo uuid.UUID) *customerrors.DomainError {
 	if _, err := s.promoDS.Get(promo); err != nil {
 		return customerrors.NotFound()
 	}
-	_ = s.promoDS.Like(promo, sub)
+	if err := s.promoDS.Like(promo, sub); err != nil && err.Code != 409 {
+		return err
+	}
 	return nil
 }
 
@@ -153,42 +275,74 @@ func (s *ApplicationService) UnlikePromoCode(sub uuid.UUID, promo uuid.UUID) *cu
 	return s.promoDS.Unlike(promo, sub)
 }
 
+func (s *ApplicationService) SavePromoCode(sub uuid.UUID, promo uuid.UUID) *customerrors.DomainError {
+	if _, err := s.promoDS.Get(promo); err != nil {
+		return customerrors.NotFound()
+	}
+	return s.promoDS.AddToWallet(promo, sub)
+}
+
+func (s *ApplicationService) UnsavePromoCode(sub uuid.UUID, promo uuid.UUID) *customerrors.DomainError {
+	return s.promoDS.RemoveFromWallet(promo, sub)
+}
+
+func (s *ApplicationService) GetWallet(sub uuid.UUID, params *GetWalletQueryParams) (
+	[]map[string]interface{},
+	int,
+	*customerrors.DomainError,
+) {
+	res, c := s.promoDS.Wallet(sub, params.Limit, params.Offset)
+	return res, c, nil
+}
+
+func (s *ApplicationService) GetExpiringWallet(sub uuid.UUID, params *GetExpiringWalletQueryParams) (
+	[]map[string]interface{},
+	*customerrors.DomainError,
+) {
+	days := defaultExpiringDays
+	if params.Days != nil {
+		days = *params.Days
+	}
+	return s.promoDS.ExpiringWallet(sub, days), nil
+}
+
 func (s *ApplicationService) CommentPromoCode(
 	sub uuid.UUID,
 	promo uuid.UUID,
-	comment string,
+	request *CommentPromoRequest,
 ) (*promocode.CommentView, *customerrors.DomainError) {
 	if _, err := s.promoDS.Get(promo); err != nil {
 		zap.S().Error(err)
 		return nil, customerrors.NotFound()
 	}
-	return s.promoDS.Comment(promo, sub, comment)
+	return s.promoDS.Comment(promo, sub, request.Content, request.ParentID)
 }
 
-func (s *ApplicationService) GetPromoCodeComments(promo uuid.UUID) (
+func (s *ApplicationService) GetPromoCodeComments(sub uuid.UUID, promo uuid.UUID, params *GetCommentsQueryParams) (
 	[]*promocode.CommentView,
+	int,
 	*customerrors.DomainError,
 ) {
 	_, err := s.promoDS.Get(promo)
 	if err != nil {
-		return nil, customerrors.NotFound()
+		return nil, 0, customerrors.NotFound()
 	}
-	return s.promoDS.GetComments(promo), nil
+	res, c := s.promoDS.GetComments(promo, sub, params.Limit, params.Offset, params.Sort)
+	return res, c, nil
 }
 
 func (s *ApplicationService) EditComment(sub uuid.UUID, commentID uuid.UUID, comment string, promo uuid.UUID) (
 	*promocode.CommentView,
 	*customerrors.DomainError,
 ) {
-	c, err := s.promoDS.GetComment(commentID, promo)
+	c, err := s.promoDS.FindComment(commentID, promo)
 	if err != nil {
 		return nil, err
 	}
-	if c.Author.Id != sub {
+	if c.UserID != sub || c.AuthorType != promocode.CommentAuthorUser {
 		return nil, customerrors.Forbidden()
 	}
-	v, _ := s.promoDS.EditComment(commentID, promo, comment)
-	return v, nil
+	return s.promoDS.EditComment(commentID, promo, comment)
 }
 
 func (s *ApplicationService) DeleteComment(
@@ -196,21 +350,68 @@ func (s *ApplicationService) DeleteComment(
 	comment uuid.UUID,
 	promo uuid.UUID,
 ) *customerrors.DomainError {
-	c, err := s.promoDS.GetComment(comment, promo)
+	c, err := s.promoDS.FindComment(comment, promo)
 	if err != nil {
 		return err
 	}
-	if c.Author.Id != sub {
+	if c.UserID != sub || c.AuthorType != promocode.CommentAuthorUser {
 		return customerrors.Forbidden()
 	}
 	return s.promoDS.DeleteComment(comment, promo)
 }
 
-func (s *ApplicationService) GetComment(comment uuid.UUID, promo uuid.UUID) (
+// ReportComment is idempotent: a repeated report of the same user is ignored.
+func (s *ApplicationService) ReportComment(
+	sub uuid.UUID,
+	comment uuid.UUID,
+	promo uuid.UUID,
+	request *ReportCommentRequest,
+) *customerrors.DomainError {
+	err := s.promoDS.ReportComment(comment, promo, sub, promocode.ReportReason(request.Reason), request.Details)
+	if err != nil && err.Code != 409 {
+		return err
+	}
+	return nil
+}
+
+func (s *ApplicationService) GetComment(sub uuid.UUID, comment uuid.UUID, promo uuid.UUID) (
 	*promocode.CommentView,
 	*customerrors.DomainError,
 ) {
-	return s.promoDS.GetComment(comment, promo)
+	return s.promoDS.GetCommentFor(comment, promo, sub)
+}
+
+// GetCommentHistory shows previous texts of a comment to its author.
+func (s *ApplicationService) GetCommentHistory(sub uuid.UUID, comment uuid.UUID, promo uuid.UUID) (
+	[]*promocode.CommentEditView,
+	*customerrors.DomainError,
+) {
+	c, err := s.promoDS.FindComment(comment, promo)
+	if err != nil {
+		return nil, err
+	}
+	if c.UserID != sub || c.AuthorType != promocode.CommentAuthorUser {
+		return nil, customerrors.Forbidden()
+	}
+	return s.promoDS.CommentHistory(comment), nil
+}
+
+// ReactToComment is idempotent: repeating a reaction is not an error.
+func (s *ApplicationService) ReactToComment(sub uuid.UUID, comment uuid.UUID, promo uuid.UUID, reaction string) *customerrors.DomainError {
+	err := s.promoDS.React(comment, promo, sub, reaction)
+	if err != nil && err.Code != 409 {
+		return err
+	}
+	return nil
+}
+
+func (s *ApplicationService) RemoveCommentReaction(
+	sub uuid.UUID,
+	comment uuid.UUID,
+	promo uuid.UUID,
+	reaction string,
+) *customerrors.DomainError {
+	return s.promoDS.Unreact(comment, promo, sub, reaction)
 }
 
 func (s *ApplicationService) ActivatePromoCode(sub uuid.UUID, promo uuid.UUID) (
@@ -253,14 +454,12 @@ func (s *ApplicationService) ActivatePromoCode(sub uuid.UUID, promo uuid.UUID) (
 			return nil, customerrors.Forbidden()
 		}
 		usedValue = p.Promo[0]
-		p.UsedCount += 1
 	}
 
-	er := s.promoDS.UsePromo(p.ID, sub, usedValue)
+	er := s.promoDS.UsePromo(p.ID, sub, u.Country, usedValue)
 	if er != nil {
 		return nil, er
 	}
-	s.promoDS.SavePromo(p)
 
 	return &ActivatePromoResponse{
 		Promo: usedValue,
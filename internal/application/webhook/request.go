@@ -0,0 +1,54 @@
+package webhook
+
+import (
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+)
+
+const eventsRule = "omitempty,dive,oneof=promo.activated promo.exhausted promo.expired promo.commented"
+
+type CreateWebhookRequest struct {
+	URL    string   `json:"url" validate:"required,http_url,max=2048"`
+	Events []string `json:"events" validate:"omitempty,dive,oneof=promo.activated promo.exhausted promo.expired promo.commented"`
+	Secret string   `json:"secret" validate:"omitempty,min=16,max=128"`
+}
+
+func (r *CreateWebhookRequest) Bind(c *fiber.Ctx, v *validator.Validate) error {
+	if err := c.BodyParser(r); err != nil {
+		return err
+	}
+	return v.Struct(r)
+}
+
+type EditWebhookRequest struct {
+	URL          *string   `json:"url" validate:"omitempty,http_url,max=2048"`
+	Events       *[]string `json:"events"`
+	Active       *bool     `json:"active"`
+	RotateSecret bool      `json:"rotate_secret"`
+}
+
+func (r *EditWebhookRequest) Bind(c *fiber.Ctx, v *validator.Validate) error {
+	if err := c.BodyParser(r); err != nil {
+		return err
+	}
+	if err := v.Struct(r); err != nil {
+		return err
+	}
+	if r.Events != nil {
+		return v.Var(*r.Events, eventsRule)
+	}
+	return nil
+}
+
+type GetDeliveriesQueryParams struct {
+	Status string `query:"status" validate:"omitempty,oneof=pending delivered dead"`
+	Limit  *int   `query:"limit" validate:"omitempty,gte=0"`
+	Offset int    `query:"offset" validate:"omitempty,gte=0"`
+}
+
+func (r *GetDeliveriesQueryParams) Bind(c *fiber.Ctx, v *validator.Validate) error {
+	if err := c.QueryParser(r); err != nil {
+		return err
+	}
+	return v.Struct(r)
+}
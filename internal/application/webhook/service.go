@@ -0,0 +1,118 @@
+package webhook
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	customerrors "solution/internal/domain/errors"
+	"solution/internal/domain/webhook"
+)
+
+type ApplicationService struct {
+	ds *webhook.DomainService
+}
+
+func NewApplicationService(ds *webhook.DomainService) *ApplicationService {
+	return &ApplicationService{ds: ds}
+}
+
+// CreateWebhook returns the secret; later reads don't.
+func (s *ApplicationService) CreateWebhook(sub uuid.UUID, request *CreateWebhookRequest) (
+	map[string]interface{},
+	*customerrors.DomainError,
+) {
+	w, err := s.ds.Register(sub, request.URL, request.Events, request.Secret)
+	if err != nil {
+		return nil, err
+	}
+	return w.ToView(true), nil
+}
+
+func (s *ApplicationService) GetWebhooks(sub uuid.UUID) []map[string]interface{} {
+	webhooks := s.ds.List(sub)
+	result := make([]map[string]interface{}, 0, len(webhooks))
+	for _, w := range webhooks {
+		result = append(result, w.ToView(false))
+	}
+	return result
+}
+
+func (s *ApplicationService) GetWebhook(sub uuid.UUID, id uuid.UUID) (
+	map[string]interface{},
+	*customerrors.DomainError,
+) {
+	w, err := s.ds.Get(sub, id)
+	if err != nil {
+		return nil, err
+	}
+	return w.ToView(false), nil
+}
+
+// EditWebhook returns the new secret when it was rotated.
+func (s *ApplicationService) EditWebhook(sub uuid.UUID, id uuid.UUID, request *EditWebhookRequest) (
+	map[string]interface{},
+	*customerrors.DomainError,
+) {
+	w, err := s.ds.Get(sub, id)
+	if err != nil {
+		return nil, err
+	}
+	if request.URL != nil {
+		w.URL = *request.URL
+	}
+	if request.Events != nil {
+		w.Events = pq.StringArray(*request.Events)
+	}
+	if request.Active != nil {
+		w.Active = *request.Active
+	}
+	if request.RotateSecret {
+		w.Secret = webhook.NewSecret()
+	}
+	if err := s.ds.Save(w); err != nil {
+		return nil, err
+	}
+	return w.ToView(request.RotateSecret), nil
+}
+
+func (s *ApplicationService) DeleteWebhook(sub uuid.UUID, id uuid.UUID) *customerrors.DomainError {
+	return s.ds.Delete(sub, id)
+}
+
+func (s *ApplicationService) TestWebhook(ctx context.Context, sub uuid.UUID, id uuid.UUID) (
+	map[string]interface{},
+	*customerrors.DomainError,
+) {
+	d, err := s.ds.Test(ctx, sub, id)
+	if err != nil {
+		return nil, err
+	}
+	return d.ToView(), nil
+}
+
+func (s *ApplicationService) GetDeliveries(sub uuid.UUID, id uuid.UUID, params *GetDeliveriesQueryParams) (
+	[]map[string]interface{},
+	int,
+	*customerrors.DomainError,
+) {
+	deliveries, count, err := s.ds.Deliveries(sub, id, params.Status, params.Limit, params.Offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	result := make([]map[string]interface{}, 0, len(deliveries))
+	for _, d := range deliveries {
+		result = append(result, d.ToView())
+	}
+	return result, count, nil
+}
+
+func (s *ApplicationService) RetryDelivery(sub uuid.UUID, id uuid.UUID, delivery uuid.UUID) (
+	map[string]interface{},
+	*customerrors.DomainError,
+) {
+	d, err := s.ds.Retry(sub, id, delivery)
+	if err != nil {
+		return nil, err
+	}
+	return d.ToView(), nil
+}
@@ -0,0 +1,69 @@
+package antifraud
+
+// ToView is what the company that owns the promo code sees.
+func (d *Decision) ToView() map[string]interface{} {
+	return map[string]interface{}{
+		"id":         d.ID,
+		"user_id":    d.UserID,
+		"outcome":    d.Outcome,
+		"reason":     d.Reason,
+		"source":     d.Source,
+		"created_at": d.CreatedAt,
+	}
+}
+
+// ToAdminView adds what support needs to investigate disputes.
+func (d *Decision) ToAdminView() map[string]interface{} {
+	r := d.ToView()
+	r["promo_id"] = d.PromoCodeID
+	r["email"] = d.Email
+	r["latency_ms"] = d.LatencyMS
+	r["raw_response"] = d.Raw
+	hits := make([]map[string]interface{}, 0, len(d.Hits))
+	for _, h := range d.Hits {
+		hits = append(
+			hits, map[string]interface{}{
+				"rule":    h.Rule,
+				"outcome": h.Outcome,
+				"reason":  h.Reason,
+				"shadow":  h.Shadow,
+			},
+		)
+	}
+	r["hits"] = hits
+	return r
+}
+
+func (h *Hold) ToView() map[string]interface{} {
+	r := map[string]interface{}{
+		"id":          h.ID,
+		"decision_id": h.DecisionID,
+		"promo_id":    h.PromoCodeID,
+		"user_id":     h.UserID,
+		"status":      h.Status,
+		"reason":      h.Reason,
+		"created_at":  h.CreatedAt,
+	}
+	if h.ResolvedAt != nil {
+		r["resolved_at"] = h.ResolvedAt
+	}
+	if h.Note != "" {
+		r["note"] = h.Note
+	}
+	return r
+}
+
+// ToUserView shows the user the state of the activation, and the promo code
+// once it went through.
+func (h *Hold) ToUserView() map[string]interface{} {
+	r := map[string]interface{}{
+		"id":         h.ID,
+		"promo_id":   h.PromoCodeID,
+		"status":     h.Status,
+		"created_at": h.CreatedAt,
+	}
+	if h.Code != nil {
+		r["promo"] = *h.Code
+	}
+	return r
+}
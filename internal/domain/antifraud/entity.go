@@ -0,0 +1,143 @@
+package antifraud
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Where a decision came from.
+const (
+	// SourceChecker is a verdict of the checkers.
+	SourceChecker = "checker"
+	// SourceCache is a verdict of the checkers reused from the cache.
+	SourceCache = "cache"
+	// SourceUnavailable is the fail policy of the promo code, applied when
+	// the checkers could not decide.
+	SourceUnavailable = "unavailable"
+)
+
+// Outcomes as recorded; they match the outcomes of the checkers.
+const (
+	OutcomeAllow  = "allow"
+	OutcomeReview = "review"
+	OutcomeDeny   = "deny"
+)
+
+// Decision is the record of one antifraud decision about an activation.
+// Raw is the response of the external service, when it was asked. Hits are
+// the rules that did not allow it, shadow rules included.
+type Decision struct {
+	ID          uuid.UUID `gorm:"type:uuid;primaryKey"`
+	PromoCodeID uuid.UUID `gorm:"type:uuid;not null;index:idx_antifraud_decisions_promo,priority:1"`
+	UserID      uuid.UUID `gorm:"type:uuid;not null;index:idx_antifraud_decisions_user,priority:1"`
+	Email       string    `gorm:"type:varchar(255);not null;default:''"`
+	Outcome     string    `gorm:"type:varchar(16);not null"`
+	Reason      string    `gorm:"type:text;not null"`
+	Source      string    `gorm:"type:varchar(16);not null"`
+	LatencyMS   int64     `gorm:"not null;default:0"`
+	Raw         string    `gorm:"type:text;not null;default:''"`
+	CreatedAt   time.Time `gorm:"not null;index:idx_antifraud_decisions_promo,priority:2;index:idx_antifraud_decisions_user,priority:2"`
+	Hits        []RuleHit `gorm:"foreignKey:DecisionID"`
+}
+
+func (*Decision) TableName() string {
+	return "antifraud_decisions"
+}
+
+// RuleHit is a rule that wanted to review or deny an activation. The hits of
+// shadow rules don't count toward the decision.
+type RuleHit struct {
+	ID         uint      `gorm:"primaryKey"`
+	DecisionID uuid.UUID `gorm:"type:uuid;not null;index"`
+	Rule       string    `gorm:"type:varchar(128);not null"`
+	Outcome    string    `gorm:"type:varchar(16);not null"`
+	Reason     string    `gorm:"type:text;not null"`
+	Shadow     bool      `gorm:"not null"`
+}
+
+func (*RuleHit) TableName() string {
+	return "antifraud_rule_hits"
+}
+
+// RuleReport compares what a rule wanted with what was decided, over the
+// decisions of the checkers and of the cache in a time range. Allowed counts the hits on
+// activations that went through: for a shadow rule, those it would have
+// blocked on top of the enforced ones.
+type RuleReport struct {
+	Rule    string `json:"rule"`
+	Shadow  bool   `json:"shadow"`
+	Hits    int    `json:"hits"`
+	Deny    int    `json:"deny"`
+	Review  int    `json:"review"`
+	Allowed int    `json:"allowed"`
+}
+
+type ShadowReport struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// Decisions is the number of decisions made by the checkers or reused
+	// from the cache; Blocked those of them that were not allowed. Cached is
+	// those reused from the cache: only shadow checkers ran on them, so
+	// neither enforced checkers nor the shadow rules within them hit there.
+	Decisions int           `json:"decisions"`
+	Blocked   int           `json:"blocked"`
+	Cached    int           `json:"cached"`
+	Rules     []*RuleReport `json:"rules"`
+}
+
+// DecisionFilter narrows the decisions admins search; zero fields match all.
+type DecisionFilter struct {
+	UserID  *uuid.UUID
+	PromoID *uuid.UUID
+	Outcome string
+	Source  string
+	From    *time.Time
+	To      *time.Time
+}
+
+const (
+	HoldPending = "pending"
+	// HoldActivating is a hold approved by the company whose activation is
+	// under way. One left so for longer than ActivationLease was interrupted.
+	HoldActivating = "activating"
+	HoldApproved   = "approved"
+	HoldRejected   = "rejected"
+	// HoldFailed is an approved hold whose activation did not go through,
+	// for example because the promo code ran out meanwhile.
+	HoldFailed = "failed"
+)
+
+// ActivationLease is how long the activation of an approved hold may take
+// before the hold is taken as interrupted.
+const ActivationLease = time.Minute
+
+// Hold is an activation put on hold by a review verdict, until the company
+// approves or rejects it. Code is the activated promo code once approved.
+// A user has at most one open hold, pending or activating, per promo code.
+type Hold struct {
+	ID          uuid.UUID `gorm:"type:uuid;primaryKey"`
+	DecisionID  uuid.UUID `gorm:"type:uuid;not null"`
+	PromoCodeID uuid.UUID `gorm:"type:uuid;not null;index:idx_antifraud_holds_promo,priority:1"`
+	UserID      uuid.UUID `gorm:"type:uuid;not null;index"`
+	Status      string    `gorm:"type:varchar(16);not null;index:idx_antifraud_holds_promo,priority:2"`
+	Reason      string    `gorm:"type:text;not null"`
+	Code        *string   `gorm:"type:text"`
+	Note        string    `gorm:"type:text;not null;default:''"`
+	CreatedAt   time.Time `gorm:"not null"`
+	ResolvedAt  *time.Time
+}
+
+func (*Hold) TableName() string {
+	return "antifraud_holds"
+}
+
+// Open tells whether the hold still waits for its outcome.
+func (h *Hold) Open() bool {
+	return h.Status == HoldPending || h.Status == HoldActivating
+}
+
+// Interrupted tells whether the activation of the hold stopped halfway, as
+// when the process died.
+func (h *Hold) Interrupted(now time.Time) bool {
+	return h.Status == HoldActivating && h.ResolvedAt != nil && now.Sub(*h.ResolvedAt) > ActivationLease
+}
@@ -0,0 +1,27 @@
+package antifraud
+
+import (
+	"github.com/google/uuid"
+	customerrors "solution/internal/domain/errors"
+	"time"
+)
+
+type Repository interface {
+	AddDecision(d *Decision) *customerrors.RepositoryError
+	// GetDecisions returns the decisions about the promo code, newest first,
+	// and their total number.
+	GetDecisions(promo uuid.UUID, outcome string, limit *int, offset int) ([]*Decision, int)
+	SearchDecisions(filter *DecisionFilter, limit *int, offset int) ([]*Decision, int)
+	GetShadowReport(from time.Time, to time.Time) (*ShadowReport, *customerrors.RepositoryError)
+
+	// AddHold adds the hold unless the user already has an open hold on the
+	// promo code, and tells whether it did.
+	AddHold(h *Hold) (bool, *customerrors.RepositoryError)
+	GetHold(id uuid.UUID, promo uuid.UUID) (*Hold, *customerrors.RepositoryError)
+	// GetLatestHold returns the newest hold of the user on the promo code.
+	GetLatestHold(user uuid.UUID, promo uuid.UUID) (*Hold, *customerrors.RepositoryError)
+	GetHolds(promo uuid.UUID, status string, limit *int, offset int) ([]*Hold, int)
+	// SaveHold saves the hold only if it still has status from, and tells
+	// whether it did.
+	SaveHold(h *Hold, from string) (bool, *customerrors.RepositoryError)
+}
@@ -0,0 +1,144 @@
+package antifraud
+
+import (
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	customerrors "solution/internal/domain/errors"
+	"time"
+)
+
+type DomainService struct {
+	repo Repository
+}
+
+func NewDomainService(repo Repository) *DomainService {
+	return &DomainService{repo: repo}
+}
+
+// Record saves the decision. Failing to save it doesn't change the outcome.
+func (d *DomainService) Record(decision *Decision) {
+	if decision.ID == uuid.Nil {
+		decision.ID = uuid.New()
+	}
+	if decision.CreatedAt.IsZero() {
+		decision.CreatedAt = time.Now()
+	}
+	if err := d.repo.AddDecision(decision); err != nil {
+		zap.S().Errorw("antifraud decision", "promo", decision.PromoCodeID, "error", err.DebugDetail)
+	}
+}
+
+func (d *DomainService) Decisions(promo uuid.UUID, outcome string, limit *int, offset int) ([]*Decision, int) {
+	return d.repo.GetDecisions(promo, outcome, limit, offset)
+}
+
+func (d *DomainService) SearchDecisions(filter *DecisionFilter, limit *int, offset int) ([]*Decision, int) {
+	return d.repo.SearchDecisions(filter, limit, offset)
+}
+
+// ShadowReport compares shadow rules with enforced ones over [from, to).
+func (d *DomainService) ShadowReport(from time.Time, to time.Time) (*ShadowReport, *customerrors.DomainError) {
+	if !from.Before(to) {
+		return nil, customerrors.BadRequest("from must be before to")
+	}
+	report, err := d.repo.GetShadowReport(from, to)
+	if err != nil {
+		return nil, err.ToDomain()
+	}
+	return report, nil
+}
+
+// Hold puts the activation the review decision is about on hold. A user
+// with an open hold on the promo code keeps that one.
+func (d *DomainService) Hold(decision *Decision) (*Hold, *customerrors.DomainError) {
+	if h, err := d.repo.GetLatestHold(decision.UserID, decision.PromoCodeID); err == nil && h.Open() {
+		return h, nil
+	}
+	h := &Hold{
+		ID:          uuid.New(),
+		DecisionID:  decision.ID,
+		PromoCodeID: decision.PromoCodeID,
+		UserID:      decision.UserID,
+		Status:      HoldPending,
+		Reason:      decision.Reason,
+		CreatedAt:   time.Now(),
+	}
+	added, err := d.repo.AddHold(h)
+	if err != nil {
+		return nil, err.ToDomain()
+	}
+	if !added {
+		// Another request of the user put it on hold meanwhile.
+		return d.LatestHold(decision.UserID, decision.PromoCodeID)
+	}
+	return h, nil
+}
+
+func (d *DomainService) GetHold(id uuid.UUID, promo uuid.UUID) (*Hold, *customerrors.DomainError) {
+	h, err := d.repo.GetHold(id, promo)
+	if err != nil {
+		return nil, err.ToDomain()
+	}
+	return h, nil
+}
+
+func (d *DomainService) LatestHold(user uuid.UUID, promo uuid.UUID) (*Hold, *customerrors.DomainError) {
+	h, err := d.repo.GetLatestHold(user, promo)
+	if err != nil {
+		return nil, err.ToDomain()
+	}
+	return h, nil
+}
+
+func (d *DomainService) Holds(promo uuid.UUID, status string, limit *int, offset int) ([]*Hold, int) {
+	return d.repo.GetHolds(promo, status, limit, offset)
+}
+
+// Resolve moves a pending hold to status. It fails with 409 when someone
+// resolved the hold first.
+func (d *DomainService) Resolve(h *Hold, status string, note string) *customerrors.DomainError {
+	return d.transition(h, HoldPending, status, note)
+}
+
+// Approve starts the activation of a pending hold; Activated or Fail end it.
+func (d *DomainService) Approve(h *Hold, note string) *customerrors.DomainError {
+	return d.transition(h, HoldPending, HoldActivating, note)
+}
+
+// Fail marks a hold whose activation did not go through.
+func (d *DomainService) Fail(h *Hold, note string) *customerrors.DomainError {
+	return d.transition(h, HoldActivating, HoldFailed, note)
+}
+
+// Activated approves the hold with the promo code its activation issued.
+func (d *DomainService) Activated(h *Hold, code string) *customerrors.DomainError {
+	h.Code = &code
+	return d.transition(h, HoldActivating, HoldApproved, h.Note)
+}
+
+// Reopen puts a hold whose activation was interrupted before it went
+// through back to pending, for the company to approve again.
+func (d *DomainService) Reopen(h *Hold) *customerrors.DomainError {
+	return d.transition(h, HoldActivating, HoldPending, "activation interrupted, approve again")
+}
+
+func (d *DomainService) transition(h *Hold, from, to, note string) *customerrors.DomainError {
+	if h.Status != from {
+		return &customerrors.DomainError{Code: 409, Message: "hold is " + h.Status}
+	}
+	now := time.Now()
+	h.Status = to
+	h.Note = note
+	h.ResolvedAt = &now
+	if to == HoldPending {
+		h.ResolvedAt = nil
+	}
+	ok, err := d.repo.SaveHold(h, from)
+	if err != nil {
+		return err.ToDomain()
+	}
+	if !ok {
+		return &customerrors.DomainError{Code: 409, Message: "hold was resolved meanwhile"}
+	}
+	return nil
+}
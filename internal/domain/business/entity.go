@@ -3,6 +3,7 @@ package business
 import (
 	"github.com/google/uuid"
 	"github.com/intezya/pkglib"
+	"time"
 )
 
 type Business struct {
@@ -11,6 +12,16 @@ type Business struct {
 	Email       string    `gorm:"type:varchar(255);not null;unique"`
 
 	PasswordHash string `gorm:"type:TEXT;not null"`
+
+	LogoURL      *string `gorm:"type:TEXT"`
+	Description  *string `gorm:"type:TEXT"`
+	Website      *string `gorm:"type:TEXT"`
+	ContactEmail *string `gorm:"type:varchar(255)"`
+	ContactPhone *string `gorm:"type:varchar(32)"`
+
+	// ExportEmailConsent lets exports carry user emails instead of pseudonyms.
+	// Only operators set it, see ConsentChange.
+	ExportEmailConsent bool `gorm:"not null;default:false"`
 }
 
 func (*Business) TableName() string {
@@ -24,3 +35,75 @@ func (b *Business) Token(secret string) string {
 		}, secret,
 	)
 }
+
+// ConsentChange is the audit record of an operator setting the export email
+// consent of a business.
+type ConsentChange struct {
+	ID         uint      `gorm:"primaryKey"`
+	BusinessID uuid.UUID `gorm:"type:uuid;not null;index"`
+	Consent    bool      `gorm:"not null"`
+	Operator   string    `gorm:"type:varchar(255);not null"`
+	Reason     string    `gorm:"type:text;not null"`
+	CreatedAt  time.Time `gorm:"not null"`
+}
+
+func (*ConsentChange) TableName() string {
+	return "business_consent_changes"
+}
+
+type Follower struct {
+	BusinessID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserID     uuid.UUID `gorm:"type:uuid;primaryKey;index"`
+	CreatedAt  time.Time
+}
+
+type DailyCount struct {
+	Day   time.Time
+	Count int
+}
+
+type Contact struct {
+	Email *string `json:"email,omitempty"`
+	Phone *string `json:"phone,omitempty"`
+}
+
+type Profile struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	Email       string    `json:"email"`
+	LogoURL     *string   `json:"logo_url,omitempty"`
+	Description *string   `json:"description,omitempty"`
+	Website     *string   `json:"website,omitempty"`
+	Contact     Contact   `json:"contact"`
+
+	ExportEmailConsent bool `json:"export_email_consent"`
+}
+
+func (b *Business) ToProfile() *Profile {
+	return &Profile{
+		ID:          b.ID,
+		Name:        b.CompanyName,
+		Email:       b.Email,
+		LogoURL:     b.LogoURL,
+		Description: b.Description,
+		Website:     b.Website,
+		Contact: Contact{
+			Email: b.ContactEmail,
+			Phone: b.ContactPhone,
+		},
+		ExportEmailConsent: b.ExportEmailConsent,
+	}
+}
+
+func (b *Business) ToPublicView(followers int) map[string]interface{} {
+	return map[string]interface{}{
+		"company_id":     b.ID,
+		"company_name":   b.CompanyName,
+		"logo_url":       b.LogoURL,
+		"description":    b.Description,
+		"website":        b.Website,
+		"contact_email":  b.ContactEmail,
+		"contact_phone":  b.ContactPhone,
+		"follower_count": followers,
+	}
+}
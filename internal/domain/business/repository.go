@@ -31,4 +31,14 @@ type Repository interface {
 	Create(b *Business) *customerrors.RepositoryError
 	GetByEmail(email string) (*Business, *customerrors.RepositoryError)
 	Get(id uuid.UUID) (*Business, *customerrors.RepositoryError)
+	Save(b *Business) *customerrors.RepositoryError
+	// SetExportEmailConsent applies the change to the business and records
+	// it, in one transaction.
+	SetExportEmailConsent(change *ConsentChange) *customerrors.RepositoryError
+	CountFollowers(id uuid.UUID) int
+	Follow(id uuid.UUID, userID uuid.UUID) *customerrors.RepositoryError
+	Unfollow(id uuid.UUID, userID uuid.UUID) *customerrors.RepositoryError
+	IsFollowing(id uuid.UUID, userID uuid.UUID) bool
+	GetFollowedIDs(userID uuid.UUID) []uuid.UUID
+	GetFollowerGrowth(id uuid.UUID, since time.Time) []*DailyCount
 }
@@ -4,6 +4,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/intezya/pkglib"
 	"solution/internal/domain/errors"
+	"time"
 )
 
 type DomainService struct {
@@ -53,3 +54,66 @@ func (s *DomainService) GetByID(id uuid.UUID) (*Business, *customerrors.DomainEr
 	}
 	return b, nil
 }
+
+func (s *DomainService) Save(b *Business) *customerrors.DomainError {
+	if err := s.repo.Save(b); err != nil {
+		return err.ToDomain()
+	}
+	return nil
+}
+
+// SetExportEmailConsent lets an operator turn the export of user emails on or
+// off for the business; the change is audited.
+func (s *DomainService) SetExportEmailConsent(
+	id uuid.UUID,
+	consent bool,
+	operator, reason string,
+) (*Business, *customerrors.DomainError) {
+	change := &ConsentChange{BusinessID: id, Consent: consent, Operator: operator, Reason: reason}
+	if err := s.repo.SetExportEmailConsent(change); err != nil {
+		return nil, err.ToDomain()
+	}
+	return s.GetByID(id)
+}
+
+func (s *DomainService) FollowersCount(id uuid.UUID) int {
+	return s.repo.CountFollowers(id)
+}
+
+func (s *DomainService) Follow(id uuid.UUID, userID uuid.UUID) *customerrors.DomainError {
+	if err := s.repo.Follow(id, userID); err != nil {
+		return err.ToDomain()
+	}
+	return nil
+}
+
+func (s *DomainService) Unfollow(id uuid.UUID, userID uuid.UUID) *customerrors.DomainError {
+	if err := s.repo.Unfollow(id, userID); err != nil {
+		return err.ToDomain()
+	}
+	return nil
+}
+
+func (s *DomainService) IsFollowing(id uuid.UUID, userID uuid.UUID) bool {
+	return s.repo.IsFollowing(id, userID)
+}
+
+func (s *DomainService) Following(userID uuid.UUID) []uuid.UUID {
+	return s.repo.GetFollowedIDs(userID)
+}
+
+// FollowerGrowth counts new followers per day for the last days days,
+// including days without any.
+func (s *DomainService) FollowerGrowth(id uuid.UUID, days int) []*DailyCount {
+	now := time.Now()
+	since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, -days+1)
+	byDay := make(map[string]int)
+	for _, d := range s.repo.GetFollowerGrowth(id, since) {
+		byDay[d.Day.Format(time.DateOnly)] = d.Count
+	}
+	growth := make([]*DailyCount, 0, days)
+	for day := since; !day.After(now); day = day.AddDate(0, 0, 1) {
+		growth = append(growth, &DailyCount{Day: day, Count: byDay[day.Format(time.DateOnly)]})
+	}
+	return growth
+}
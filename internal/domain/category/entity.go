@@ -0,0 +1,57 @@
+package category
+
+import (
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type Category struct {
+	ID       uuid.UUID      `gorm:"type:uuid;primaryKey"`
+	Slug     string         `gorm:"type:varchar(64);not null;unique"`
+	ParentID *uuid.UUID     `gorm:"type:uuid"`
+	Synonyms pq.StringArray `gorm:"type:text[]"`
+	Names    []Name         `gorm:"foreignKey:CategoryID;constraint:OnDelete:CASCADE"`
+}
+
+func (*Category) TableName() string {
+	return "categories"
+}
+
+type Name struct {
+	CategoryID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Locale     string    `gorm:"type:varchar(8);primaryKey"`
+	Name       string    `gorm:"type:varchar(255);not null"`
+}
+
+func (*Name) TableName() string {
+	return "category_names"
+}
+
+// Seed is one node of the catalog file.
+type Seed struct {
+	Slug     string            `yaml:"slug"`
+	Names    map[string]string `yaml:"names"`
+	Synonyms []string          `yaml:"synonyms"`
+	Children []Seed            `yaml:"children"`
+}
+
+type Node struct {
+	Category *Category
+	Children []*Node
+}
+
+func (c *Category) Name(locale string) string {
+	var fallback string
+	for _, n := range c.Names {
+		if n.Locale == locale {
+			return n.Name
+		}
+		if n.Locale == DefaultLocale {
+			fallback = n.Name
+		}
+	}
+	if fallback != "" {
+		return fallback
+	}
+	return c.Slug
+}
@@ -0,0 +1,8 @@
+package category
+
+import customerrors "solution/internal/domain/errors"
+
+type Repository interface {
+	GetAll() ([]*Category, *customerrors.RepositoryError)
+	Upsert(c *Category) *customerrors.RepositoryError
+}
@@ -0,0 +1,174 @@
+package category
+
+import (
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	customerrors "solution/internal/domain/errors"
+	"strings"
+	"sync"
+)
+
+const DefaultLocale = "en"
+
+var slugNamespace = uuid.MustParse("6f0d5b0e-3c1f-4c33-9d4a-0d3b0a8f6e51")
+
+type DomainService struct {
+	repo Repository
+
+	mu       sync.RWMutex
+	bySlug   map[string]*Category
+	byTerm   map[string]*Category
+	children map[uuid.UUID][]*Category
+	roots    []*Category
+}
+
+func NewDomainService(repo Repository) *DomainService {
+	return &DomainService{repo: repo}
+}
+
+// Sync upserts the seeded catalog and reloads the in-memory index. Category
+// IDs are derived from slugs, so they are stable across restarts.
+func (s *DomainService) Sync(seeds []Seed) *customerrors.DomainError {
+	var walk func(seeds []Seed, parent *uuid.UUID) *customerrors.DomainError
+	walk = func(seeds []Seed, parent *uuid.UUID) *customerrors.DomainError {
+		for _, seed := range seeds {
+			slug := Normalize(seed.Slug)
+			if slug == "" {
+				return customerrors.BadRequest("category without slug")
+			}
+			c := &Category{
+				ID:       uuid.NewSHA1(slugNamespace, []byte(slug)),
+				Slug:     slug,
+				ParentID: parent,
+				Synonyms: pq.StringArray(seed.Synonyms),
+			}
+			for locale, name := range seed.Names {
+				c.Names = append(c.Names, Name{CategoryID: c.ID, Locale: locale, Name: name})
+			}
+			if err := s.repo.Upsert(c); err != nil {
+				return err.ToDomain()
+			}
+			if err := walk(seed.Children, &c.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(seeds, nil); err != nil {
+		return err
+	}
+	return s.Reload()
+}
+
+func (s *DomainService) Reload() *customerrors.DomainError {
+	all, err := s.repo.GetAll()
+	if err != nil {
+		return err.ToDomain()
+	}
+	bySlug := make(map[string]*Category, len(all))
+	byTerm := make(map[string]*Category, len(all)*4)
+	children := make(map[uuid.UUID][]*Category)
+	var roots []*Category
+	for _, c := range all {
+		bySlug[c.Slug] = c
+		if c.ParentID == nil {
+			roots = append(roots, c)
+		} else {
+			children[*c.ParentID] = append(children[*c.ParentID], c)
+		}
+	}
+	// Slugs win over names, names win over synonyms.
+	for _, c := range all {
+		for _, term := range c.Synonyms {
+			byTerm[Normalize(term)] = c
+		}
+	}
+	for _, c := range all {
+		for _, n := range c.Names {
+			byTerm[Normalize(n.Name)] = c
+		}
+	}
+	for _, c := range all {
+		byTerm[c.Slug] = c
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bySlug, s.byTerm, s.children, s.roots = bySlug, byTerm, children, roots
+	return nil
+}
+
+func Normalize(term string) string {
+	return strings.Join(strings.Fields(strings.ToLower(term)), " ")
+}
+
+// Resolve finds a category by slug, localized name or synonym.
+func (s *DomainService) Resolve(term string) (*Category, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.byTerm[Normalize(term)]
+	return c, ok
+}
+
+// Canonicalize maps every term to its category slug, dropping duplicates. An
+// unknown term is a bad request.
+func (s *DomainService) Canonicalize(terms []string) ([]string, *customerrors.DomainError) {
+	seen := make(map[string]bool, len(terms))
+	slugs := make([]string, 0, len(terms))
+	for _, term := range terms {
+		c, ok := s.Resolve(term)
+		if !ok {
+			return nil, customerrors.BadRequest("unknown category: " + term)
+		}
+		if !seen[c.Slug] {
+			seen[c.Slug] = true
+			slugs = append(slugs, c.Slug)
+		}
+	}
+	return slugs, nil
+}
+
+// Expand returns the slug of the matching category followed by the slugs of
+// all its descendants.
+func (s *DomainService) Expand(term string) ([]string, bool) {
+	c, ok := s.Resolve(term)
+	if !ok {
+		return nil, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.subtree(c), true
+}
+
+func (s *DomainService) subtree(c *Category) []string {
+	slugs := []string{c.Slug}
+	for _, child := range s.children[c.ID] {
+		slugs = append(slugs, s.subtree(child)...)
+	}
+	return slugs
+}
+
+// Subtrees maps every slug to the slugs of its subtree.
+func (s *DomainService) Subtrees() map[string][]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make(map[string][]string, len(s.bySlug))
+	for slug, c := range s.bySlug {
+		result[slug] = s.subtree(c)
+	}
+	return result
+}
+
+func (s *DomainService) Tree() []*Node {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var build func(cs []*Category) []*Node
+	build = func(cs []*Category) []*Node {
+		nodes := make([]*Node, 0, len(cs))
+		for _, c := range cs {
+			nodes = append(nodes, &Node{Category: c, Children: build(s.children[c.ID])})
+		}
+		return nodes
+	}
+	return build(s.roots)
+}
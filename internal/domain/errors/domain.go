@@ -61,6 +61,20 @@ func Forbidden() *DomainError {
 	}
 }
 
+func TooManyRequests(detail ...string) *DomainError {
+	if len(detail) > 0 {
+		return &DomainError{
+			Code:        429,
+			Message:     "too many requests",
+			DebugDetail: detail[0],
+		}
+	}
+	return &DomainError{
+		Code:    429,
+		Message: "too many requests",
+	}
+}
+
 func (e *DomainError) ToFiber(c *fiber.Ctx) error {
 	return c.Status(e.Code).JSON(
 		fiber.Map{
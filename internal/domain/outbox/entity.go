@@ -0,0 +1,60 @@
+package outbox
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"time"
+)
+
+// Record is an event in the outbox, written in the same transaction as the
+// change it describes, so no event is lost when the process dies in between.
+// It is processed once every subscriber handled it; HandledBy keeps those
+// that did across retries.
+type Record struct {
+	ID            uuid.UUID      `gorm:"type:uuid;primaryKey"`
+	Key           string         `gorm:"type:text;uniqueIndex"`
+	Type          string         `gorm:"type:varchar(32);not null"`
+	AggregateID   uuid.UUID      `gorm:"type:uuid;not null"`
+	CompanyID     uuid.UUID      `gorm:"type:uuid;not null"`
+	Payload       string         `gorm:"type:jsonb;not null"`
+	CreatedAt     time.Time      `gorm:"not null"`
+	Attempts      int            `gorm:"not null;default:0"`
+	NextAttemptAt time.Time      `gorm:"not null;default:now();index"`
+	HandledBy     pq.StringArray `gorm:"type:text[]"`
+	LastError     string         `gorm:"type:text"`
+	ProcessedAt   *time.Time     `gorm:"index"`
+}
+
+func (*Record) TableName() string {
+	return "outbox_events"
+}
+
+func NewRecord(e Event) *Record {
+	payload, _ := json.Marshal(e)
+	id := uuid.New()
+	key := id.String()
+	if k, ok := e.(Keyed); ok {
+		key = k.Key()
+	}
+	now := time.Now()
+	return &Record{
+		ID:            id,
+		Key:           key,
+		Type:          e.EventType(),
+		AggregateID:   e.Aggregate(),
+		CompanyID:     e.Company(),
+		Payload:       string(payload),
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	}
+}
+
+func (r *Record) handledBy(subscriber string) bool {
+	for _, s := range r.HandledBy {
+		if s == subscriber {
+			return true
+		}
+	}
+	return false
+}
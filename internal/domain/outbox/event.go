@@ -0,0 +1,149 @@
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"time"
+)
+
+// Event types as they appear in the outbox and to webhook receivers.
+// CommentPosted keeps the promo.commented name webhooks already use.
+const (
+	TypePromoCreated   = "promo.created"
+	TypePromoUpdated   = "promo.updated"
+	TypePromoActivated = "promo.activated"
+	TypePromoExhausted = "promo.exhausted"
+	TypePromoExpired   = "promo.expired"
+	TypePromoLiked     = "promo.liked"
+	TypeCommentPosted  = "promo.commented"
+)
+
+// Event is a domain event. Events are plain values, serialized as JSON into
+// the outbox.
+type Event interface {
+	EventType() string
+	// Aggregate is the promo code, or other entity, the event is about.
+	Aggregate() uuid.UUID
+	// Company is the company the event is routed to.
+	Company() uuid.UUID
+}
+
+// Keyed events are written at most once per key; other events always are.
+type Keyed interface {
+	Key() string
+}
+
+type PromoCreated struct {
+	PromoID   uuid.UUID `json:"promo_id"`
+	CompanyID uuid.UUID `json:"company_id"`
+	Mode      string    `json:"mode"`
+	MaxCount  int       `json:"max_count"`
+}
+
+type PromoUpdated struct {
+	PromoID   uuid.UUID `json:"promo_id"`
+	CompanyID uuid.UUID `json:"company_id"`
+}
+
+type PromoActivated struct {
+	PromoID     uuid.UUID `json:"promo_id"`
+	CompanyID   uuid.UUID `json:"company_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	Code        string    `json:"code"`
+	Country     string    `json:"country"`
+	ActivatedAt time.Time `json:"activated_at"`
+	Remaining   int       `json:"remaining"`
+}
+
+// PromoExhausted is raised once per max_count, so raising the limit of an
+// exhausted COMMON promo code lets it be raised again.
+type PromoExhausted struct {
+	PromoID   uuid.UUID `json:"promo_id"`
+	CompanyID uuid.UUID `json:"company_id"`
+	UsedCount int       `json:"used_count"`
+	MaxCount  int       `json:"max_count"`
+}
+
+// PromoExpired is raised once per end date.
+type PromoExpired struct {
+	PromoID     uuid.UUID `json:"promo_id"`
+	CompanyID   uuid.UUID `json:"company_id"`
+	ActiveUntil string    `json:"active_until"`
+}
+
+type PromoLiked struct {
+	PromoID   uuid.UUID `json:"promo_id"`
+	CompanyID uuid.UUID `json:"company_id"`
+	UserID    uuid.UUID `json:"user_id"`
+}
+
+type CommentPosted struct {
+	PromoID    uuid.UUID  `json:"promo_id"`
+	CompanyID  uuid.UUID  `json:"company_id"`
+	CommentID  uuid.UUID  `json:"comment_id"`
+	ParentID   *uuid.UUID `json:"parent_id"`
+	UserID     uuid.UUID  `json:"user_id"`
+	AuthorType string     `json:"author_type"`
+	Text       string     `json:"text"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (PromoCreated) EventType() string   { return TypePromoCreated }
+func (PromoUpdated) EventType() string   { return TypePromoUpdated }
+func (PromoActivated) EventType() string { return TypePromoActivated }
+func (PromoExhausted) EventType() string { return TypePromoExhausted }
+func (PromoExpired) EventType() string   { return TypePromoExpired }
+func (PromoLiked) EventType() string     { return TypePromoLiked }
+func (CommentPosted) EventType() string  { return TypeCommentPosted }
+
+func (e PromoCreated) Aggregate() uuid.UUID   { return e.PromoID }
+func (e PromoUpdated) Aggregate() uuid.UUID   { return e.PromoID }
+func (e PromoActivated) Aggregate() uuid.UUID { return e.PromoID }
+func (e PromoExhausted) Aggregate() uuid.UUID { return e.PromoID }
+func (e PromoExpired) Aggregate() uuid.UUID   { return e.PromoID }
+func (e PromoLiked) Aggregate() uuid.UUID     { return e.PromoID }
+func (e CommentPosted) Aggregate() uuid.UUID  { return e.PromoID }
+
+func (e PromoCreated) Company() uuid.UUID   { return e.CompanyID }
+func (e PromoUpdated) Company() uuid.UUID   { return e.CompanyID }
+func (e PromoActivated) Company() uuid.UUID { return e.CompanyID }
+func (e PromoExhausted) Company() uuid.UUID { return e.CompanyID }
+func (e PromoExpired) Company() uuid.UUID   { return e.CompanyID }
+func (e PromoLiked) Company() uuid.UUID     { return e.CompanyID }
+func (e CommentPosted) Company() uuid.UUID  { return e.CompanyID }
+
+func (e PromoExhausted) Key() string {
+	return fmt.Sprintf("%s:%s:%d", TypePromoExhausted, e.PromoID, e.MaxCount)
+}
+
+func (e PromoExpired) Key() string {
+	return TypePromoExpired + ":" + e.PromoID.String() + ":" + e.ActiveUntil
+}
+
+// Decode turns a record back into its typed event.
+func Decode(r *Record) (Event, error) {
+	var e Event
+	switch r.Type {
+	case TypePromoCreated:
+		e = &PromoCreated{}
+	case TypePromoUpdated:
+		e = &PromoUpdated{}
+	case TypePromoActivated:
+		e = &PromoActivated{}
+	case TypePromoExhausted:
+		e = &PromoExhausted{}
+	case TypePromoExpired:
+		e = &PromoExpired{}
+	case TypePromoLiked:
+		e = &PromoLiked{}
+	case TypeCommentPosted:
+		e = &CommentPosted{}
+	default:
+		return nil, fmt.Errorf("unknown event type %q", r.Type)
+	}
+	if err := json.Unmarshal([]byte(r.Payload), e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
@@ -0,0 +1,19 @@
+package outbox
+
+import (
+	customerrors "solution/internal/domain/errors"
+	"time"
+)
+
+// Repository is the dispatcher's side of the outbox. Records are written by
+// the repositories of the aggregates, within their own transactions.
+type Repository interface {
+	// ClaimDue returns up to limit unprocessed records due at now, oldest
+	// first, and pushes their next attempt to now+lease so no one else picks
+	// them meanwhile.
+	ClaimDue(now time.Time, lease time.Duration, limit int) []*Record
+	Save(r *Record) *customerrors.RepositoryError
+	// Prune deletes the records processed before the given time and returns
+	// how many it deleted.
+	Prune(before time.Time) (int64, *customerrors.RepositoryError)
+}
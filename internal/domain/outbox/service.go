@@ -0,0 +1,144 @@
+package outbox
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"time"
+)
+
+// Handler handles one event. Handlers must be idempotent: delivery is at
+// least once, an event comes again when the process dies before its progress
+// is saved.
+type Handler func(ctx context.Context, r *Record, e Event) error
+
+// Broker publishes events to a message broker outside the process.
+type Broker interface {
+	Name() string
+	Publish(ctx context.Context, r *Record) error
+}
+
+const (
+	dispatchBatch = 100
+	// dispatchLease is how long a claimed record is left to its dispatcher.
+	dispatchLease = time.Minute
+	// Retries wait retryBase, doubling up to retryCap. Events are never given
+	// up on.
+	retryBase = time.Second
+	retryCap  = 5 * time.Minute
+)
+
+type subscriber struct {
+	name   string
+	types  map[string]bool
+	handle Handler
+}
+
+// Dispatcher hands outbox events to the subscribers. A subscriber that fails
+// gets the event again later, without the others getting it twice.
+type Dispatcher struct {
+	repo        Repository
+	subscribers []subscriber
+}
+
+func NewDispatcher(repo Repository) *Dispatcher {
+	return &Dispatcher{repo: repo}
+}
+
+// Subscribe registers handler under a name unique to it, for the given event
+// types or all of them. Subscribers are registered before Run.
+func (d *Dispatcher) Subscribe(name string, handler Handler, types ...string) {
+	s := subscriber{name: name, handle: handler}
+	if len(types) > 0 {
+		s.types = make(map[string]bool, len(types))
+		for _, t := range types {
+			s.types[t] = true
+		}
+	}
+	d.subscribers = append(d.subscribers, s)
+}
+
+// Attach subscribes the broker to all events.
+func (d *Dispatcher) Attach(b Broker) {
+	d.Subscribe(
+		"broker:"+b.Name(), func(ctx context.Context, r *Record, _ Event) error {
+			return b.Publish(ctx, r)
+		},
+	)
+}
+
+// Run dispatches until ctx is done, waiting every between rounds that found
+// nothing more to do.
+func (d *Dispatcher) Run(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		if d.Dispatch(ctx) == dispatchBatch {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dispatch runs one batch of due events through the subscribers and returns
+// the size of the batch.
+func (d *Dispatcher) Dispatch(ctx context.Context) int {
+	records := d.repo.ClaimDue(time.Now(), dispatchLease, dispatchBatch)
+	for _, r := range records {
+		d.dispatch(ctx, r)
+		if err := d.repo.Save(r); err != nil {
+			zap.S().Errorw("outbox", "event", r.ID, "error", err.DebugDetail)
+		}
+	}
+	return len(records)
+}
+
+// Prune deletes the events processed longer than retention ago. Events still
+// being retried are kept whatever their age.
+func (d *Dispatcher) Prune(retention time.Duration) {
+	n, err := d.repo.Prune(time.Now().Add(-retention))
+	if err != nil {
+		zap.S().Errorw("outbox prune", "error", err.DebugDetail)
+		return
+	}
+	if n > 0 {
+		zap.S().Infow("outbox prune", "deleted", n)
+	}
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context, r *Record) {
+	now := time.Now()
+	e, err := Decode(r)
+	if err != nil {
+		// Retrying won't help an event nobody can read.
+		zap.S().Errorw("outbox: dropping undecodable event", "event", r.ID, "type", r.Type, "error", err)
+		r.LastError = err.Error()
+		r.ProcessedAt = &now
+		return
+	}
+	r.LastError = ""
+	for _, s := range d.subscribers {
+		if (s.types != nil && !s.types[r.Type]) || r.handledBy(s.name) {
+			continue
+		}
+		if err := s.handle(ctx, r, e); err != nil {
+			zap.S().Warnw("outbox", "event", r.ID, "subscriber", s.name, "error", err)
+			r.LastError = s.name + ": " + err.Error()
+			continue
+		}
+		r.HandledBy = append(r.HandledBy, s.name)
+	}
+	if r.LastError != "" {
+		r.Attempts++
+		delay := retryCap
+		if r.Attempts <= 20 {
+			delay = min(retryBase<<(r.Attempts-1), retryCap)
+		}
+		r.NextAttemptAt = now.Add(delay)
+		return
+	}
+	r.ProcessedAt = &now
+}
@@ -1,8 +1,14 @@
 package promocode
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/google/uuid"
+	"math"
 	"solution/internal/domain/types"
 	"solution/pkg"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -36,11 +42,15 @@ func (p *PromoCode) ToOwnerViewUNIQUE(
 		"used_count":   uses,
 		"promo_unique": p.Promo,
 		"image_url":    p.ImageURL,
+		"rating":       p.RatingSummary(),
 		"active_from":  af,
 		"active_until": au,
 		"max_count":    1,
 	}
 	pkg.RecursiveRemoveNulls(r)
+	if p.AntifraudFailPolicy != "" {
+		r["antifraud_fail_policy"] = p.AntifraudFailPolicy
+	}
 	if r["target"] == nil {
 		r["target"] = map[string]interface{}{}
 	}
@@ -52,6 +62,19 @@ func (p *PromoCode) ToOwnerViewUNIQUE(
 	}
 	return r
 }
+
+// FailsOpen tells whether activations go through when antifraud can't
+// decide, byDefault being the service default.
+func (p *PromoCode) FailsOpen(byDefault bool) bool {
+	switch p.AntifraudFailPolicy {
+	case FailOpen:
+		return true
+	case FailClosed:
+		return false
+	}
+	return byDefault
+}
+
 func (p *PromoCode) ToOwnerViewCOMMON(
 	active bool,
 	likes,
@@ -83,10 +106,14 @@ func (p *PromoCode) ToOwnerViewCOMMON(
 		"used_count":   uses,
 		"promo_common": p.Promo[0],
 		"image_url":    p.ImageURL,
+		"rating":       p.RatingSummary(),
 		"active_from":  af,
 		"active_until": au,
 	}
 	pkg.RecursiveRemoveNulls(r)
+	if p.AntifraudFailPolicy != "" {
+		r["antifraud_fail_policy"] = p.AntifraudFailPolicy
+	}
 	if r["target"] == nil {
 		r["target"] = map[string]interface{}{}
 	}
@@ -100,7 +127,7 @@ func (p *PromoCode) ToOwnerViewCOMMON(
 }
 
 func (p *PromoCode) ToUserView(
-	active bool, activated, liked bool,
+	active bool, activated, liked, saved bool,
 	likes, comments int,
 ) map[string]interface{} {
 	r := map[string]interface{}{
@@ -112,7 +139,9 @@ func (p *PromoCode) ToUserView(
 		"is_activated_by_user": activated,
 		"like_count":           likes,
 		"is_liked_by_user":     liked,
+		"is_saved_by_user":     saved,
 		"comment_count":        comments,
+		"rating":               p.RatingSummary(),
 		"image_url":            p.ImageURL,
 	}
 	if r["active"] == nil {
@@ -121,6 +150,63 @@ func (p *PromoCode) ToUserView(
 	return r
 }
 
+// ToWalletView is the user view of a saved promo code with the time it was
+// saved and the number of whole days left until ActiveUntil, if it is set.
+func (p *PromoCode) ToWalletView(
+	s *SavedPromo,
+	active, activated, liked bool,
+) map[string]interface{} {
+	r := p.ToUserView(active, activated, liked, true, p.LikeCount, p.CommentCount)
+	r["saved_at"] = s.CreatedAt.Format(time.RFC3339)
+	if days := p.DaysLeft(time.Now()); days != nil {
+		r["days_left"] = *days
+	}
+	return r
+}
+
+// DaysLeft returns nil for promo codes without an end date. ActiveUntil is
+// inclusive, so a promo code ending today has 0 days left.
+func (p *PromoCode) DaysLeft(now time.Time) *int {
+	if p.ActiveUntil == nil || p.ActiveUntil.Format(time.DateOnly) == "0001-01-01" {
+		return nil
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	until := time.Date(p.ActiveUntil.Year(), p.ActiveUntil.Month(), p.ActiveUntil.Day(), 0, 0, 0, 0, time.UTC)
+	days := max(int(until.Sub(today).Hours()/24), 0)
+	return &days
+}
+
+// Remaining is the number of activations left before the promo code runs out.
+func (p *PromoCode) Remaining() int {
+	if p.Mode == UNIQUE {
+		return len(p.AvailablePromo)
+	}
+	return max(p.MaxCount-p.UsedCount, 0)
+}
+
+// Depletion forecasts when the promo code runs out if it keeps being activated
+// perDay times a day; days_left is missing when it is not being activated.
+func (p *PromoCode) Depletion(now time.Time, perDay float64) map[string]interface{} {
+	remaining := p.Remaining()
+	r := map[string]interface{}{
+		"promo_id":         p.ID,
+		"description":      p.Description,
+		"remaining":        remaining,
+		"activations_rate": math.Round(perDay*100) / 100,
+	}
+	if perDay <= 0 {
+		return r
+	}
+	days := int(math.Ceil(float64(remaining) / perDay))
+	on := now.UTC().AddDate(0, 0, days)
+	r["days_left"] = days
+	r["depleted_on"] = on.Format(time.DateOnly)
+	if left := p.DaysLeft(now); left != nil {
+		r["runs_out_before_end"] = days < *left
+	}
+	return r
+}
+
 type UpdatePromoCode struct {
 	Description *string
 	ImageURL    *string
@@ -130,9 +216,10 @@ type UpdatePromoCode struct {
 		Country    *string
 		Categories *[]string
 	} `json:"target"`
-	MaxCount    *int
-	ActiveFrom  *types.SolutionDate
-	ActiveUntil *types.SolutionDate
+	MaxCount            *int
+	ActiveFrom          *types.SolutionDate
+	ActiveUntil         *types.SolutionDate
+	AntifraudFailPolicy *string
 }
 
 type PromoSimpleData struct {
@@ -141,3 +228,184 @@ type PromoSimpleData struct {
 	Uses     int
 	Comments int
 }
+
+type CounterDrift struct {
+	PromoCodeID uuid.UUID
+	Counter     string
+	Stored      int
+	Actual      int
+}
+
+// Revision identifies a representation of one or more promo codes for
+// conditional requests.
+type Revision struct {
+	ETag         string
+	LastModified time.Time
+}
+
+// ETag is a strong entity tag built from UpdatedAt and the counters. Anything
+// else the representation depends on (activity, per-user flags) goes to extra.
+func (p *PromoCode) ETag(extra ...string) string {
+	return makeETag(append([]string{p.revisionKey()}, extra...)...)
+}
+
+func (p *PromoCode) LastModified() time.Time {
+	return notBeforeToday(p.UpdatedAt)
+}
+
+// notBeforeToday clamps t to the start of the current day: activity depends
+// on the date, so a promo can turn active or inactive at midnight without any
+// write.
+func notBeforeToday(t time.Time) time.Time {
+	now := time.Now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if t.Before(midnight) {
+		return midnight
+	}
+	return t
+}
+
+func (p *PromoCode) revisionKey() string {
+	return strings.Join(
+		[]string{
+			p.ID.String(),
+			strconv.FormatInt(p.UpdatedAt.UnixNano(), 36),
+			strconv.Itoa(p.LikeCount),
+			strconv.Itoa(p.CommentCount),
+			strconv.Itoa(p.UsedCount),
+			strconv.Itoa(len(p.AvailablePromo)),
+			strconv.Itoa(p.Rating1),
+			strconv.Itoa(p.Rating2),
+			strconv.Itoa(p.Rating3),
+			strconv.Itoa(p.Rating4),
+			strconv.Itoa(p.Rating5),
+		}, ":",
+	)
+}
+
+// Ratings returns the number of ratings per star, one star first.
+func (p *PromoCode) Ratings() [5]int {
+	return [5]int{p.Rating1, p.Rating2, p.Rating3, p.Rating4, p.Rating5}
+}
+
+// RatingSummary is the average rating rounded to two decimals, the number of
+// ratings and their distribution by stars. The average is 0 without ratings.
+func (p *PromoCode) RatingSummary() map[string]interface{} {
+	var count, sum int
+	distribution := make(map[string]interface{}, 5)
+	for i, n := range p.Ratings() {
+		count += n
+		sum += n * (i + 1)
+		distribution[strconv.Itoa(i+1)] = n
+	}
+	average := 0.0
+	if count > 0 {
+		average = math.Round(float64(sum)/float64(count)*100) / 100
+	}
+	return map[string]interface{}{
+		"average":      average,
+		"count":        count,
+		"distribution": distribution,
+	}
+}
+
+func makeETag(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "|")))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// ToView renders the comment. Tombstones and hidden comments keep only their
+// place in the thread: no text and no author.
+func (c *Comment) ToView(author *CommentAuthor) *CommentView {
+	v := c.ToModerationView(author)
+	if c.Hidden() {
+		v.Text = ""
+		v.EditedAt = ""
+		v.Author = nil
+		v.Badge = ""
+	}
+	return v
+}
+
+// ToModerationView renders hidden comments in full, for the promo owner.
+func (c *Comment) ToModerationView(author *CommentAuthor) *CommentView {
+	v := &CommentView{
+		Id:       c.ID,
+		ParentId: c.ParentID,
+		Date:     c.CreatedAt.Format(time.RFC3339),
+		Hidden:   c.Hidden(),
+	}
+	if c.Deleted() {
+		v.Deleted = true
+		return v
+	}
+	v.Text = c.Content
+	v.Author = author
+	if c.EditedAt != nil {
+		v.EditedAt = c.EditedAt.Format(time.RFC3339)
+	}
+	if c.AuthorType == CommentAuthorBusiness {
+		v.Badge = "company"
+	}
+	return v
+}
+
+// commentTree nests replies under their parents. Views must list every parent
+// before its replies; the order of siblings is kept.
+func commentTree(views []*CommentView) []*CommentView {
+	byID := make(map[uuid.UUID]*CommentView, len(views))
+	var roots []*CommentView
+	for _, v := range views {
+		byID[v.Id] = v
+		if v.ParentId == nil {
+			roots = append(roots, v)
+			continue
+		}
+		if parent, ok := byID[*v.ParentId]; ok {
+			parent.Replies = append(parent.Replies, v)
+		}
+	}
+	return roots
+}
+
+func (f *Funnel) Add(other *Funnel) {
+	f.Impressions += other.Impressions
+	f.Views += other.Views
+	f.Likes += other.Likes
+	f.Activations += other.Activations
+	f.Redemptions += other.Redemptions
+}
+
+// View lists the stages with the conversion from the previous stage, and the
+// overall conversion from impression to activation and redemption.
+func (f *Funnel) View() map[string]interface{} {
+	stage := func(name string, count, previous int) map[string]interface{} {
+		s := map[string]interface{}{
+			"stage": name,
+			"count": count,
+		}
+		if previous >= 0 {
+			s["conversion"] = conversion(count, previous)
+		}
+		return s
+	}
+	return map[string]interface{}{
+		"stages": []map[string]interface{}{
+			stage("impressions", f.Impressions, -1),
+			stage("detail_views", f.Views, f.Impressions),
+			stage("likes", f.Likes, f.Views),
+			stage("activations", f.Activations, f.Likes),
+			stage("redemptions", f.Redemptions, f.Activations),
+		},
+		"activation_rate": conversion(f.Activations, f.Impressions),
+		"redemption_rate": conversion(f.Redemptions, f.Impressions),
+	}
+}
+
+// conversion is n/of rounded to four decimals, 0 when of is 0.
+func conversion(n, of int) float64 {
+	if of == 0 {
+		return 0
+	}
+	return math.Round(float64(n)/float64(of)*10000) / 10000
+}
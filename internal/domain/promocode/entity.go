@@ -22,8 +22,17 @@ type PromoCode struct {
 	CompanyID   uuid.UUID `gorm:"type:uuid"`
 	CompanyName string    `gorm:"type:varchar(255)"`
 
-	MaxCount  int `gorm:"column:max_count"`
-	UsedCount int `gorm:"column:used_count"`
+	MaxCount     int `gorm:"column:max_count"`
+	UsedCount    int `gorm:"column:used_count"`
+	LikeCount    int `gorm:"column:like_count;not null;default:0"`
+	CommentCount int `gorm:"column:comment_count;not null;default:0"`
+
+	// RatingN is the number of N-star ratings.
+	Rating1 int `gorm:"column:rating_1;not null;default:0"`
+	Rating2 int `gorm:"column:rating_2;not null;default:0"`
+	Rating3 int `gorm:"column:rating_3;not null;default:0"`
+	Rating4 int `gorm:"column:rating_4;not null;default:0"`
+	Rating5 int `gorm:"column:rating_5;not null;default:0"`
 
 	TargetAgeFrom         *int            `gorm:"column:target_age_from"`
 	TargetAgeUntil        *int            `gorm:"column:target_age_until"`
@@ -40,32 +49,183 @@ type PromoCode struct {
 	ImageURL    *string    `gorm:"type:text"`
 	ActiveFrom  *time.Time `gorm:"column:active_from;type:date"`
 	ActiveUntil *time.Time `gorm:"column:active_until;type:date"`
+
+	// AntifraudFailPolicy is what happens to activations when antifraud
+	// can't decide; empty means the service default.
+	AntifraudFailPolicy string `gorm:"type:varchar(8);not null;default:''"`
 }
 
+const (
+	FailOpen   = "open"
+	FailClosed = "closed"
+)
+
 type Like struct {
 	PromoCodeID uuid.UUID `gorm:"type:uuid;primaryKey"`
 	UserID      uuid.UUID `gorm:"type:uuid;primaryKey"`
 	CreatedAt   time.Time
 }
 
-type Comment struct {
-	ID          uuid.UUID `gorm:"type:uuid;primaryKey"`
-	PromoCodeID uuid.UUID `gorm:"type:uuid"`
-	UserID      uuid.UUID `gorm:"type:uuid"`
-	Content     string    `gorm:"type:text"`
+// Rating is a 1 to 5 star rating of a user who activated the promo code, with
+// an optional comment posted along with it.
+type Rating struct {
+	PromoCodeID uuid.UUID  `gorm:"type:uuid;primaryKey"`
+	UserID      uuid.UUID  `gorm:"type:uuid;primaryKey"`
+	Stars       int        `gorm:"not null"`
+	CommentID   *uuid.UUID `gorm:"type:uuid"`
 	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// SavedPromo is an entry of the user's wallet.
+type SavedPromo struct {
+	PromoCodeID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserID      uuid.UUID `gorm:"type:uuid;primaryKey;index"`
+	CreatedAt   time.Time
+}
+
+// MaxCommentDepth is the deepest level a reply can be nested at, roots being
+// at depth 0. Replies to a comment at this depth become its siblings.
+const MaxCommentDepth = 2
+
+const (
+	CommentAuthorUser     = "user"
+	CommentAuthorBusiness = "business"
+)
+
+type Comment struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primaryKey"`
+	PromoCodeID uuid.UUID  `gorm:"type:uuid"`
+	ParentID    *uuid.UUID `gorm:"type:uuid;index"`
+	// RootID is the top-level comment of the thread, nil for roots.
+	RootID *uuid.UUID `gorm:"type:uuid;index"`
+	Depth  int        `gorm:"not null;default:0"`
+	// UserID is the author: a user, or the company for official replies.
+	UserID     uuid.UUID `gorm:"type:uuid"`
+	AuthorType string    `gorm:"type:varchar(16);not null;default:'user'"`
+	Content    string    `gorm:"type:text"`
+	CreatedAt  time.Time
+	// DeletedAt is set instead of removing a comment that still has replies.
+	DeletedAt *time.Time
+
+	EditedAt      *time.Time
+	ReactionCount int `gorm:"not null;default:0"`
+
+	ReportCount int `gorm:"not null;default:0"`
+	HiddenAt    *time.Time
+	HiddenBy    string `gorm:"type:varchar(16)"`
+}
+
+func (c *Comment) Deleted() bool {
+	return c.DeletedAt != nil
+}
+
+func (c *Comment) Hidden() bool {
+	return c.HiddenAt != nil
+}
+
+const (
+	HiddenByReports  = "reports"
+	HiddenByBusiness = "business"
+)
+
+type ReportReason string
+
+const (
+	ReportSpam       ReportReason = "spam"
+	ReportAbuse      ReportReason = "abuse"
+	ReportOffTopic   ReportReason = "off_topic"
+	ReportMisleading ReportReason = "misleading"
+	ReportOther      ReportReason = "other"
+)
+
+type CommentReport struct {
+	CommentID uuid.UUID    `gorm:"type:uuid;primaryKey"`
+	UserID    uuid.UUID    `gorm:"type:uuid;primaryKey"`
+	Reason    ReportReason `gorm:"type:varchar(16);not null"`
+	Details   string       `gorm:"type:text"`
+	CreatedAt time.Time
+}
+
+// CommentEdit keeps the text a comment had before an edit.
+type CommentEdit struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey"`
+	CommentID uuid.UUID `gorm:"type:uuid;index"`
+	Content   string    `gorm:"type:text"`
+	CreatedAt time.Time
+}
+
+type CommentEditView struct {
+	Text       string `json:"text"`
+	ReplacedAt string `json:"replaced_at"`
+}
+
+// ReactionKeys lists the reactions a comment can get, in display order;
+// Reactions maps them to emoji.
+var ReactionKeys = []string{"like", "love", "laugh", "wow", "sad", "angry"}
+
+var Reactions = map[string]string{
+	"like":  "\U0001F44D",
+	"love":  "\u2764\uFE0F",
+	"laugh": "\U0001F602",
+	"wow":   "\U0001F62E",
+	"sad":   "\U0001F622",
+	"angry": "\U0001F621",
+}
+
+type CommentReaction struct {
+	CommentID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Reaction  string    `gorm:"type:varchar(16);primaryKey"`
+	CreatedAt time.Time
+}
+
+// ReactionCount is the number of reactions of one kind on a comment and
+// whether the viewer is one of them.
+type ReactionCount struct {
+	Key   string
+	Count int
+	Mine  bool
+}
+
+type ReactionView struct {
+	Key             string `json:"key"`
+	Emoji           string `json:"emoji"`
+	Count           int    `json:"count"`
+	IsReactedByUser bool   `json:"is_reacted_by_user"`
+}
+
+type CommentAuthor struct {
+	Id        uuid.UUID `json:"id"`
+	Type      string    `json:"type"`
+	Name      string    `json:"name"`
+	Surname   string    `json:"surname,omitempty"`
+	AvatarUrl *string   `json:"avatar_url"`
 }
 
 type CommentView struct {
-	Id     uuid.UUID `json:"id"`
-	Text   string    `json:"text"`
-	Date   string    `json:"date"`
-	Author struct {
-		Id        uuid.UUID `json:"id"`
-		Name      string    `json:"name"`
-		Surname   string    `json:"surname"`
-		AvatarUrl *string   `json:"avatar_url"`
-	} `json:"author"`
+	Id       uuid.UUID      `json:"id"`
+	ParentId *uuid.UUID     `json:"parent_id,omitempty"`
+	Text     string         `json:"text,omitempty"`
+	Date     string         `json:"date"`
+	EditedAt string         `json:"edited_at,omitempty"`
+	Author   *CommentAuthor `json:"author,omitempty"`
+	// Badge marks official replies of the promo code owner.
+	Badge     string          `json:"badge,omitempty"`
+	Deleted   bool            `json:"deleted,omitempty"`
+	Hidden    bool            `json:"hidden,omitempty"`
+	Reactions []*ReactionView `json:"reactions,omitempty"`
+	Replies   []*CommentView  `json:"replies,omitempty"`
+}
+
+// ModerationItem is a reported or hidden comment as seen by the promo owner.
+type ModerationItem struct {
+	PromoID     uuid.UUID            `json:"promo_id"`
+	Comment     *CommentView         `json:"comment"`
+	ReportCount int                  `json:"report_count"`
+	Reasons     map[ReportReason]int `json:"reasons"`
+	Hidden      bool                 `json:"hidden"`
+	HiddenBy    string               `json:"hidden_by,omitempty"`
 }
 
 type Use struct {
@@ -74,5 +234,55 @@ type Use struct {
 	UserID       uuid.UUID `gorm:"type:uuid"`
 	Country      string    `gorm:"type:varchar(255)"`
 	CountryLower string    `gorm:"type:varchar(255)"`
-	CreatedAt    time.Time
+	// Code is the promo code value handed out on activation.
+	Code      string `gorm:"type:text;index"`
+	CreatedAt time.Time
+	// RedeemedAt is set when the business accepts the code at checkout.
+	RedeemedAt *time.Time
+}
+
+// UseRecord is a use with the age band and email of its user, as exported.
+type UseRecord struct {
+	Use
+	AgeBand string
+	Email   string
+}
+
+const (
+	ImpressionFeed = "feed"
+	ImpressionView = "view"
+)
+
+// Impression records that a user saw a promo code in the feed or opened it,
+// at most once per user, day and kind.
+type Impression struct {
+	PromoCodeID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserID      uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Day         time.Time `gorm:"type:date;primaryKey"`
+	Kind        string    `gorm:"type:varchar(8);primaryKey"`
+	CreatedAt   time.Time
+}
+
+// DailyRollup is the number of activations of a promo code on one UTC day by
+// users of one country and age band. Rollups are rebuilt from uses by
+// RefreshRollups and back the company dashboard.
+type DailyRollup struct {
+	PromoCodeID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Day         time.Time `gorm:"type:date;primaryKey"`
+	Country     string    `gorm:"type:text;primaryKey"`
+	AgeBand     string    `gorm:"type:varchar(8);primaryKey"`
+	CompanyID   uuid.UUID `gorm:"type:uuid;index:idx_daily_rollups_company_day"`
+	Activations int       `gorm:"not null"`
+	RefreshedAt time.Time
+}
+
+// Funnel counts users at each stage for one promo code. Every stage after
+// impressions counts events of the window, not only those of users who passed
+// the previous stage.
+type Funnel struct {
+	Impressions int
+	Views       int
+	Likes       int
+	Activations int
+	Redemptions int
 }
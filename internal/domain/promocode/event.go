@@ -0,0 +1,70 @@
+package promocode
+
+import (
+	"github.com/google/uuid"
+	"sync"
+	"time"
+)
+
+const (
+	EventActivation = "activation"
+	EventLike       = "like"
+	EventComment    = "comment"
+)
+
+// Event is something that happened to a promo code, as pushed live to its
+// company. IDs grow by one per event within a process.
+type Event struct {
+	ID          uint64                 `json:"-"`
+	Type        string                 `json:"-"`
+	PromoCodeID uuid.UUID              `json:"promo_id"`
+	At          time.Time              `json:"at"`
+	Data        map[string]interface{} `json:"data,omitempty"`
+}
+
+// Subscription delivers the events of one promo code. Backlog holds the
+// buffered events after the one the subscriber resumed from. Events is
+// closed when the subscriber falls too far behind; it should then reconnect
+// and resume.
+type Subscription struct {
+	Backlog []*Event
+	Events  <-chan *Event
+	Close   func()
+}
+
+// EventBus fans events out to live subscribers. Publish must not block.
+type EventBus interface {
+	Publish(e *Event)
+	// Subscribe returns false when the company has too many subscriptions.
+	Subscribe(company uuid.UUID, promo uuid.UUID, after uint64) (*Subscription, bool)
+}
+
+// liveDedupSize is the number of outbox events remembered to not push one
+// twice when it is delivered again.
+const liveDedupSize = 4096
+
+// recentEvents is a bounded set of the outbox events last published live.
+type recentEvents struct {
+	mu   sync.Mutex
+	seen map[uuid.UUID]struct{}
+	ring []uuid.UUID
+	head int
+}
+
+func newRecentEvents(size int) *recentEvents {
+	return &recentEvents{seen: make(map[uuid.UUID]struct{}, size), ring: make([]uuid.UUID, size)}
+}
+
+// add tells whether id is new, remembering it in place of the oldest one.
+func (r *recentEvents) add(id uuid.UUID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.seen[id]; ok {
+		return false
+	}
+	delete(r.seen, r.ring[r.head])
+	r.ring[r.head] = id
+	r.head = (r.head + 1) % len(r.ring)
+	r.seen[id] = struct{}{}
+	return true
+}
@@ -0,0 +1,7 @@
+package promocode
+
+// ImpressionRecorder stores impressions off the request path. Record must not
+// block; implementations may drop impressions under load.
+type ImpressionRecorder interface {
+	Record(impressions ...*Impression)
+}
@@ -0,0 +1,18 @@
+package promocode
+
+// ContentFilter rejects comment texts that must not be published. The
+// returned error is shown to the author.
+type ContentFilter interface {
+	Check(text string) error
+}
+
+// DefaultReportThreshold is the number of reports that hides a comment when
+// Moderation does not set one.
+const DefaultReportThreshold = 3
+
+type Moderation struct {
+	Filter ContentFilter
+	// ReportThreshold is the number of reports after which a comment is
+	// hidden until the promo owner reviews it.
+	ReportThreshold int
+}
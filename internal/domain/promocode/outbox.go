@@ -0,0 +1,88 @@
+package promocode
+
+import (
+	"github.com/google/uuid"
+	"solution/internal/domain/outbox"
+	"time"
+)
+
+func (p *PromoCode) CreatedEvent() outbox.PromoCreated {
+	return outbox.PromoCreated{
+		PromoID:   p.ID,
+		CompanyID: p.CompanyID,
+		Mode:      string(p.Mode),
+		MaxCount:  p.MaxCount,
+	}
+}
+
+func (p *PromoCode) UpdatedEvent() outbox.PromoUpdated {
+	return outbox.PromoUpdated{PromoID: p.ID, CompanyID: p.CompanyID}
+}
+
+func (p *PromoCode) ActivatedEvent(u *Use) outbox.PromoActivated {
+	return outbox.PromoActivated{
+		PromoID:     p.ID,
+		CompanyID:   p.CompanyID,
+		UserID:      u.UserID,
+		Code:        u.Code,
+		Country:     u.CountryLower,
+		ActivatedAt: u.CreatedAt,
+		Remaining:   p.Remaining(),
+	}
+}
+
+func (p *PromoCode) ExhaustedEvent() outbox.PromoExhausted {
+	return outbox.PromoExhausted{
+		PromoID:   p.ID,
+		CompanyID: p.CompanyID,
+		UsedCount: p.UsedCount,
+		MaxCount:  p.MaxCount,
+	}
+}
+
+func (p *PromoCode) ExpiredEvent() outbox.PromoExpired {
+	return outbox.PromoExpired{
+		PromoID:     p.ID,
+		CompanyID:   p.CompanyID,
+		ActiveUntil: p.ActiveUntil.Format(time.DateOnly),
+	}
+}
+
+func (p *PromoCode) LikedEvent(user uuid.UUID) outbox.PromoLiked {
+	return outbox.PromoLiked{PromoID: p.ID, CompanyID: p.CompanyID, UserID: user}
+}
+
+func (p *PromoCode) CommentedEvent(c *Comment) outbox.CommentPosted {
+	return outbox.CommentPosted{
+		PromoID:    p.ID,
+		CompanyID:  p.CompanyID,
+		CommentID:  c.ID,
+		ParentID:   c.ParentID,
+		UserID:     c.UserID,
+		AuthorType: c.AuthorType,
+		Text:       c.Content,
+		CreatedAt:  c.CreatedAt,
+	}
+}
+
+// StateEvents are the events the current state of the promo code raises on
+// every save: exhaustion of a COMMON code and expiry. Both are keyed, so
+// saving again does not raise them twice.
+func (p *PromoCode) StateEvents(now time.Time) []outbox.Event {
+	var events []outbox.Event
+	if p.Mode == COMMON && p.Remaining() == 0 {
+		events = append(events, p.ExhaustedEvent())
+	}
+	if p.Expired(now) {
+		events = append(events, p.ExpiredEvent())
+	}
+	return events
+}
+
+// Expired tells whether the promo code has an end date before today.
+func (p *PromoCode) Expired(now time.Time) bool {
+	if p.ActiveUntil == nil || p.ActiveUntil.Format(time.DateOnly) == "0001-01-01" {
+		return false
+	}
+	return p.ActiveUntil.Format(time.DateOnly) < now.UTC().Format(time.DateOnly)
+}
@@ -3,6 +3,7 @@ package promocode
 import (
 	"github.com/google/uuid"
 	"solution/internal/domain/errors"
+	"solution/internal/domain/outbox"
 	"time"
 )
 
@@ -14,14 +15,39 @@ type GetAsCompanyListParams struct {
 }
 
 type GetAsUserFeedParams struct {
-	Limit    *int
-	Offset   int
-	Category string
-	Active   *bool
-	Age      int
-	Country  string
+	Limit      *int
+	Offset     int
+	Categories []string
+	Active     *bool
+	Age        int
+	Country    string
+	CompanyIDs []uuid.UUID
+	// BoostCompanyIDs puts promo codes of these companies first.
+	BoostCompanyIDs []uuid.UUID
+	SortBy          string
 }
 
+// CommentSortMostReacted orders threads by the reactions on their root
+// comment; the default is newest first.
+const CommentSortMostReacted = "most_reacted"
+
+// SortByRating orders the feed by average rating; the default is newest first.
+const SortByRating = "rating"
+
+// StatsParams selects the window of usage statistics and the size of the
+// buckets of its time series.
+type StatsParams struct {
+	From        time.Time
+	To          time.Time
+	Granularity string
+}
+
+const (
+	GranularityHour = "hour"
+	GranularityDay  = "day"
+	GranularityWeek = "week"
+)
+
 type CreatedAt = time.Time
 
 type Repository interface {
@@ -29,25 +55,91 @@ type Repository interface {
 	Get(id uuid.UUID) (*PromoCode, *customerrors.RepositoryError)
 	GetByCompanyIDAsCompanyList(id uuid.UUID, params *GetAsCompanyListParams) ([]*PromoCode, int)
 	GetAsUserFeed(params *GetAsUserFeedParams) ([]*PromoCode, int)
+	GetCompanyLastModified(id uuid.UUID) time.Time
+	GetCompanyLikesCount(id uuid.UUID) int
+	CountByCategories(subtrees map[string][]string) map[string]int
+	GetMany(ids []uuid.UUID) []*PromoCode
 	GetCommentsCount(promoCodeID uuid.UUID) int
 	GetLikesCount(promoCodeID uuid.UUID) int
 	GetUsesCount(promoCodeID uuid.UUID) int
 	Delete(id uuid.UUID) *customerrors.RepositoryError
-	GetUsageStatistics(promoCodeID uuid.UUID) map[string]interface{}
-	Save(p *PromoCode)
+	GetUsageStatistics(promoCodeID uuid.UUID, params *StatsParams) map[string]interface{}
+	Save(p *PromoCode, events ...outbox.Event)
 
 	IsLiked(promoCodeID uuid.UUID, userID uuid.UUID) bool
 	IsActivated(promoCodeID uuid.UUID, userID uuid.UUID) bool
+	IsLikedMany(ids []uuid.UUID, userID uuid.UUID) map[uuid.UUID]bool
+	IsActivatedMany(ids []uuid.UUID, userID uuid.UUID) map[uuid.UUID]bool
+	IsSaved(promoCodeID uuid.UUID, userID uuid.UUID) bool
+	IsSavedMany(ids []uuid.UUID, userID uuid.UUID) map[uuid.UUID]bool
 
 	Like(id uuid.UUID, sub uuid.UUID) *customerrors.RepositoryError
 	Unlike(id uuid.UUID, sub uuid.UUID) *customerrors.RepositoryError
 
-	Comment(id uuid.UUID, sub uuid.UUID, comment string) *Comment
+	// Rate writes the rating and comment, which may be nil, in one
+	// transaction.
+	Rate(r *Rating, comment *Comment) *customerrors.RepositoryError
+	Unrate(id uuid.UUID, sub uuid.UUID) *customerrors.RepositoryError
+	GetRating(id uuid.UUID, sub uuid.UUID) *Rating
+
+	AddToWallet(id uuid.UUID, sub uuid.UUID) *customerrors.RepositoryError
+	RemoveFromWallet(id uuid.UUID, sub uuid.UUID) *customerrors.RepositoryError
+	GetWallet(sub uuid.UUID, limit *int, offset int) ([]*SavedPromo, int)
+	GetExpiringWallet(sub uuid.UUID, until time.Time) []*SavedPromo
+
+	Comment(c *Comment) *customerrors.RepositoryError
+	FindComment(comment uuid.UUID, promo uuid.UUID) (*Comment, *customerrors.RepositoryError)
 	GetComment(comment uuid.UUID, promo uuid.UUID) (*CommentView, *customerrors.RepositoryError)
 	EditComment(comment uuid.UUID, promo uuid.UUID, commentText string) *customerrors.RepositoryError
 	DeleteComment(comment uuid.UUID, promo uuid.UUID) *customerrors.RepositoryError
-	GetComments(promoid uuid.UUID) []*CommentView
+	// GetComments returns a page of root comments followed by all their
+	// replies, and the total number of roots.
+	GetComments(promoid uuid.UUID, limit *int, offset int, sort string) ([]*CommentView, int)
+	GetCommentEdits(comment uuid.UUID) []*CommentEdit
+
+	React(r *CommentReaction) *customerrors.RepositoryError
+	Unreact(r *CommentReaction) *customerrors.RepositoryError
+	GetReactions(comments []uuid.UUID, viewer uuid.UUID) map[uuid.UUID][]ReactionCount
+
+	ReportComment(r *CommentReport, threshold int) *customerrors.RepositoryError
+	// SetCommentHidden hides the comment, or unhides it when hiddenBy is empty.
+	SetCommentHidden(comment uuid.UUID, promo uuid.UUID, hiddenBy string) *customerrors.RepositoryError
+	GetModerationQueue(company uuid.UUID, hiddenOnly bool, limit *int, offset int) ([]*ModerationItem, int)
 
 	AddUse(u *Use) *customerrors.RepositoryError
+	// Redeem marks the oldest unredeemed use of code as redeemed; user narrows
+	// it down to one user's use.
+	Redeem(promo uuid.UUID, code string, user *uuid.UUID) (*Use, *customerrors.RepositoryError)
+	// GetFunnels counts funnel stages per promo code of the company within
+	// [from, to), optionally for a single promo code.
+	GetFunnels(company uuid.UUID, promo *uuid.UUID, from, to time.Time) map[uuid.UUID]*Funnel
 	UseHistory(id uuid.UUID) []*Use
+	// GetUseSince returns the first use of the promo code by the user made at
+	// or after since.
+	GetUseSince(promo uuid.UUID, user uuid.UUID, since time.Time) (*Use, *customerrors.RepositoryError)
+	// StreamUses calls fn for every use of the promo code, oldest first, and
+	// stops at the first error of fn.
+	StreamUses(promo uuid.UUID, fn func(*UseRecord) error) error
+
+	// RefreshRollups rebuilds the daily rollups of from's day and later.
+	RefreshRollups(from time.Time) *customerrors.RepositoryError
+	// GetRollupWatermark returns the last day that has rollups, nil if none.
+	GetRollupWatermark() *time.Time
+	// GetCompanyStatistics aggregates the rollups of the company's promo codes
+	// on the days within [from, to).
+	GetCompanyStatistics(company uuid.UUID, from, to time.Time, top int) map[string]interface{}
+	// GetRollupActivations sums activations per promo code of the company on
+	// from's day and later.
+	GetRollupActivations(company uuid.UUID, from time.Time) map[uuid.UUID]int
+	// StreamRollups calls fn for every rollup of the company on the days
+	// within [from, to), by day, and stops at the first error of fn.
+	StreamRollups(company uuid.UUID, from, to time.Time, fn func(*DailyRollup) error) error
+
+	// AddEvents writes events to the outbox outside of any other change.
+	AddEvents(events ...outbox.Event) *customerrors.RepositoryError
+	// GetExpiredSince returns the promo codes that ended on since's day or
+	// later, before today.
+	GetExpiredSince(since time.Time) []*PromoCode
+
+	ReconcileCounters(apply bool) ([]CounterDrift, *customerrors.RepositoryError)
 }
@@ -1,22 +1,48 @@
 package promocode
 
 import (
+	"context"
 	"github.com/google/uuid"
 	"github.com/lib/pq"
 	"go.uber.org/zap"
 	customerrors "solution/internal/domain/errors"
+	"solution/internal/domain/outbox"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
 type DomainService struct {
-	repository Repository
+	repository  Repository
+	moderation  Moderation
+	impressions ImpressionRecorder
+	events      EventBus
+	published   *recentEvents
 }
 
-func NewDomainService(repository Repository) *DomainService {
-	return &DomainService{
-		repository: repository,
+// NewDomainService creates the service; moderation may be nil, which means no
+// content filter and the default report threshold, impressions may be nil to
+// not track them and events may be nil to not publish live events.
+func NewDomainService(
+	repository Repository,
+	moderation *Moderation,
+	impressions ImpressionRecorder,
+	events EventBus,
+) *DomainService {
+	d := &DomainService{
+		repository:  repository,
+		impressions: impressions,
+		events:      events,
+		published:   newRecentEvents(liveDedupSize),
 	}
+	if moderation != nil {
+		d.moderation = *moderation
+	}
+	if d.moderation.ReportThreshold <= 0 {
+		d.moderation.ReportThreshold = DefaultReportThreshold
+	}
+	return d
 }
 
 func (d *DomainService) Create(p *PromoCode) error {
@@ -38,9 +64,9 @@ func (d *DomainService) Get2(id uuid.UUID) (p *PromoCode, dt *PromoSimpleData, e
 	}
 	return p, &PromoSimpleData{
 		Active:   d.IsActive(p),
-		Likes:    d.repository.GetLikesCount(p.ID),
-		Uses:     d.repository.GetUsesCount(p.ID),
-		Comments: d.repository.GetCommentsCount(p.ID),
+		Likes:    p.LikeCount,
+		Uses:     p.UsedCount,
+		Comments: p.CommentCount,
 	}, nil
 }
 
@@ -101,13 +127,15 @@ func (d *DomainService) Update(id uuid.UUID, u *UpdatePromoCode) (
 			p.MaxCount = *u.MaxCount
 		}
 	}
-	lc := d.repository.GetLikesCount(p.ID)
-	uc := d.repository.GetUsesCount(p.ID)
-	d.repository.Save(p)
+	if u.AntifraudFailPolicy != nil {
+		p.AntifraudFailPolicy = *u.AntifraudFailPolicy
+	}
+	d.repository.Save(p, p.UpdatedEvent())
 	return p, &PromoSimpleData{
-		Active: d.IsActive(p),
-		Likes:  lc,
-		Uses:   uc,
+		Active:   d.IsActive(p),
+		Likes:    p.LikeCount,
+		Uses:     p.UsedCount,
+		Comments: p.CommentCount,
 	}, nil
 }
 
@@ -140,7 +168,7 @@ func (d *DomainService) GetByCompanyID(
 	offset int,
 	sort string,
 	countryCode *[]string,
-) ([]map[string]interface{}, int) {
+) ([]map[string]interface{}, int, string) {
 	promos, count := d.repository.GetByCompanyIDAsCompanyList(
 		id, &GetAsCompanyListParams{
 			Limit:       limit,
@@ -150,70 +178,216 @@ func (d *DomainService) GetByCompanyID(
 		},
 	)
 	var result []map[string]interface{}
+	parts := []string{strconv.Itoa(count)}
 
 	for _, p := range promos {
 		isactive := d.IsActive(p)
+		parts = append(parts, p.revisionKey(), strconv.FormatBool(isactive))
 		if p.Mode == COMMON {
-			result = append(
-				result,
-				p.ToOwnerViewCOMMON(
-					isactive,
-					d.repository.GetLikesCount(p.ID),
-					d.repository.GetUsesCount(p.ID),
-				),
-			)
+			result = append(result, p.ToOwnerViewCOMMON(isactive, p.LikeCount, p.UsedCount))
 		} else {
-			result = append(
-				result,
-				p.ToOwnerViewUNIQUE(
-					isactive,
-					d.repository.GetLikesCount(p.ID),
-					d.repository.GetUsesCount(p.ID),
-				),
-			)
+			result = append(result, p.ToOwnerViewUNIQUE(isactive, p.LikeCount, p.UsedCount))
 		}
-
 	}
 	zap.S().Debugw("Get by company", "result", result)
-	return result, count
+	return result, count, makeETag(parts...)
 }
 
-func (d *DomainService) UsageStatistic(id uuid.UUID) map[string]interface{} {
-	return d.repository.GetUsageStatistics(id)
+func (d *DomainService) CompanyLastModified(id uuid.UUID) time.Time {
+	return notBeforeToday(d.repository.GetCompanyLastModified(id))
 }
 
-func (d *DomainService) GetFeed(
-	sub uuid.UUID,
-	limit *int,
-	offset int,
-	category string,
-	active *bool,
-	age int,
-	country string,
-) ([]map[string]interface{}, int) {
-	result, count := d.repository.GetAsUserFeed(
-		&GetAsUserFeedParams{
-			Limit:    limit,
-			Offset:   offset,
-			Category: category,
-			Active:   active,
-			Age:      age,
-			Country:  country,
+// maxStatsBuckets bounds the length of the usage time series.
+const maxStatsBuckets = 1000
+
+// UsageStatistic fills in a missing window: it ends now and spans 48 hours,
+// 30 days or 12 weeks depending on the granularity, which defaults to day.
+func (d *DomainService) UsageStatistic(id uuid.UUID, params *StatsParams) (
+	map[string]interface{},
+	*customerrors.DomainError,
+) {
+	p := *params
+	if p.Granularity == "" {
+		p.Granularity = GranularityDay
+	}
+	var step time.Duration
+	switch p.Granularity {
+	case GranularityHour:
+		step = time.Hour
+	case GranularityDay:
+		step = 24 * time.Hour
+	case GranularityWeek:
+		step = 7 * 24 * time.Hour
+	default:
+		return nil, customerrors.BadRequest("granularity must be hour, day or week")
+	}
+	if p.To.IsZero() {
+		p.To = time.Now()
+	}
+	if p.From.IsZero() {
+		span := map[string]time.Duration{
+			GranularityHour: 48 * time.Hour,
+			GranularityDay:  30 * 24 * time.Hour,
+			GranularityWeek: 12 * 7 * 24 * time.Hour,
+		}
+		p.From = p.To.Add(-span[p.Granularity])
+	}
+	if !p.From.Before(p.To) {
+		return nil, customerrors.BadRequest("from must be before to")
+	}
+	if p.To.Sub(p.From)/step > maxStatsBuckets {
+		return nil, customerrors.BadRequest("too many buckets, use a coarser granularity")
+	}
+	return d.repository.GetUsageStatistics(id, &p), nil
+}
+
+const (
+	// dashboardTopPromos is the number of promo codes in top_promos.
+	dashboardTopPromos = 5
+	// depletionRateDays is the span the activation rate of a depletion
+	// forecast is measured over.
+	depletionRateDays = 14
+	// expiringSoonDays is how close to its end an active promo code is listed
+	// in expiring_soon.
+	expiringSoonDays = 7
+)
+
+// CompanyStatistic is the company dashboard over the UTC days from's day to
+// to's day inclusive. Activations come from the daily rollups, so they lag
+// behind by up to one refresh; the depletion forecast and expiring_soon are
+// computed from the live promo codes.
+func (d *DomainService) CompanyStatistic(company uuid.UUID, from, to time.Time) map[string]interface{} {
+	from = utcDay(from)
+	to = utcDay(to).AddDate(0, 0, 1)
+	stats := d.repository.GetCompanyStatistics(company, from, to, dashboardTopPromos)
+
+	now := time.Now()
+	today := utcDay(now)
+	promos, _ := d.repository.GetByCompanyIDAsCompanyList(company, &GetAsCompanyListParams{SortBy: "active_until"})
+	recent := d.repository.GetRollupActivations(company, today.AddDate(0, 0, -depletionRateDays))
+
+	depletion := make([]map[string]interface{}, 0)
+	expiring := make([]map[string]interface{}, 0)
+	for _, p := range promos {
+		if !d.IsActive(p) {
+			continue
+		}
+		depletion = append(depletion, p.Depletion(now, float64(recent[p.ID])/depletionRateDays))
+		if left := p.DaysLeft(now); left != nil && *left < expiringSoonDays {
+			expiring = append(
+				expiring, map[string]interface{}{
+					"promo_id":     p.ID,
+					"description":  p.Description,
+					"active_until": p.ActiveUntil.Format(time.DateOnly),
+					"days_left":    *left,
+					"remaining":    p.Remaining(),
+				},
+			)
+		}
+	}
+	// Soonest to run out first, promo codes nobody activates last.
+	sort.SliceStable(
+		depletion, func(i, j int) bool {
+			a, aok := depletion[i]["days_left"].(int)
+			b, bok := depletion[j]["days_left"].(int)
+			if aok != bok {
+				return aok
+			}
+			return a < b
 		},
 	)
+	stats["depletion"] = depletion
+	stats["expiring_soon"] = expiring
+	return stats
+}
+
+// RefreshRollups rebuilds the daily rollups. The last lookbackDays days up to
+// the newest rollup are redone, to pick up activations that were being written
+// during the previous refresh; everything is built on the first run.
+func (d *DomainService) RefreshRollups(lookbackDays int) *customerrors.DomainError {
+	var from time.Time
+	if last := d.repository.GetRollupWatermark(); last != nil {
+		from = utcDay(*last).AddDate(0, 0, -lookbackDays)
+	}
+	if err := d.repository.RefreshRollups(from); err != nil {
+		return err.ToDomain()
+	}
+	return nil
+}
+
+func (d *DomainService) StreamUses(promo uuid.UUID, fn func(*UseRecord) error) error {
+	return d.repository.StreamUses(promo, fn)
+}
+
+// StreamRollups streams the rollups of the UTC days from's day to to's day
+// inclusive.
+func (d *DomainService) StreamRollups(company uuid.UUID, from, to time.Time, fn func(*DailyRollup) error) error {
+	return d.repository.StreamRollups(company, utcDay(from), utcDay(to).AddDate(0, 0, 1), fn)
+}
+
+// ExpiredLookbackDays is how far back EnqueueExpired looks for promo codes
+// that ended, to cover downtime. The outbox must keep events at least that
+// long, or the keys that skip them are gone.
+const ExpiredLookbackDays = 7
+
+// EnqueueExpired raises promo.expired for promo codes that ended recently.
+// Events already raised are skipped by their key.
+func (d *DomainService) EnqueueExpired() *customerrors.DomainError {
+	promos := d.repository.GetExpiredSince(utcDay(time.Now()).AddDate(0, 0, -ExpiredLookbackDays))
+	events := make([]outbox.Event, 0, len(promos))
+	for _, p := range promos {
+		events = append(events, p.ExpiredEvent())
+	}
+	if err := d.repository.AddEvents(events...); err != nil {
+		return err.ToDomain()
+	}
+	return nil
+}
+
+func utcDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func (d *DomainService) GetFeed(sub uuid.UUID, params *GetAsUserFeedParams) ([]map[string]interface{}, int, string) {
+	result, count := d.repository.GetAsUserFeed(params)
+	ids := promoIDs(result)
+	d.recordImpressions(sub, ImpressionFeed, ids...)
+	activated := d.repository.IsActivatedMany(ids, sub)
+	liked := d.repository.IsLikedMany(ids, sub)
+	saved := d.repository.IsSavedMany(ids, sub)
 	var r []map[string]interface{}
+	parts := []string{strconv.Itoa(count)}
 	for _, p := range result {
+		parts = append(
+			parts,
+			p.revisionKey(),
+			strconv.FormatBool(d.IsActive(p)),
+			strconv.FormatBool(activated[p.ID]),
+			strconv.FormatBool(liked[p.ID]),
+			strconv.FormatBool(saved[p.ID]),
+		)
 		r = append(
 			r, p.ToUserView(
 				d.IsActive(p),
-				d.repository.IsActivated(p.ID, sub),
-				d.repository.IsLiked(p.ID, sub),
-				d.repository.GetLikesCount(p.ID),
-				d.repository.GetCommentsCount(p.ID),
+				activated[p.ID],
+				liked[p.ID],
+				saved[p.ID],
+				p.LikeCount,
+				p.CommentCount,
 			),
 		)
 	}
-	return r, count
+	return r, count, makeETag(parts...)
+}
+
+// CategoryCounts counts promo codes tagged with any category of each subtree.
+func (d *DomainService) CategoryCounts(subtrees map[string][]string) map[string]int {
+	return d.repository.CountByCategories(subtrees)
+}
+
+func (d *DomainService) CompanyLikes(id uuid.UUID) int {
+	return d.repository.GetCompanyLikesCount(id)
 }
 
 func (d *DomainService) Activated(id uuid.UUID, sub uuid.UUID) bool {
@@ -224,6 +398,62 @@ func (d *DomainService) Liked(id uuid.UUID, sub uuid.UUID) bool {
 	return d.repository.IsLiked(id, sub)
 }
 
+func (d *DomainService) Saved(id uuid.UUID, sub uuid.UUID) bool {
+	return d.repository.IsSaved(id, sub)
+}
+
+func (d *DomainService) AddToWallet(id uuid.UUID, sub uuid.UUID) *customerrors.DomainError {
+	if err := d.repository.AddToWallet(id, sub); err != nil {
+		return err.ToDomain()
+	}
+	return nil
+}
+
+func (d *DomainService) RemoveFromWallet(id uuid.UUID, sub uuid.UUID) *customerrors.DomainError {
+	if err := d.repository.RemoveFromWallet(id, sub); err != nil {
+		return customerrors.NotFound()
+	}
+	return nil
+}
+
+func (d *DomainService) Wallet(sub uuid.UUID, limit *int, offset int) ([]map[string]interface{}, int) {
+	saved, count := d.repository.GetWallet(sub, limit, offset)
+	return d.walletView(sub, saved, false), count
+}
+
+// ExpiringWallet lists saved promo codes that are still active and end within
+// the given number of days, soonest first.
+func (d *DomainService) ExpiringWallet(sub uuid.UUID, days int) []map[string]interface{} {
+	until := utcDay(time.Now()).AddDate(0, 0, days)
+	return d.walletView(sub, d.repository.GetExpiringWallet(sub, until), true)
+}
+
+func (d *DomainService) walletView(sub uuid.UUID, saved []*SavedPromo, activeOnly bool) []map[string]interface{} {
+	ids := make([]uuid.UUID, 0, len(saved))
+	for _, s := range saved {
+		ids = append(ids, s.PromoCodeID)
+	}
+	promos := make(map[uuid.UUID]*PromoCode, len(ids))
+	for _, p := range d.repository.GetMany(ids) {
+		promos[p.ID] = p
+	}
+	activated := d.repository.IsActivatedMany(ids, sub)
+	liked := d.repository.IsLikedMany(ids, sub)
+	var result []map[string]interface{}
+	for _, s := range saved {
+		p, ok := promos[s.PromoCodeID]
+		if !ok {
+			continue
+		}
+		active := d.IsActive(p)
+		if activeOnly && !active {
+			continue
+		}
+		result = append(result, p.ToWalletView(s, active, activated[p.ID], liked[p.ID]))
+	}
+	return result
+}
+
 func (d *DomainService) Like(id uuid.UUID, sub uuid.UUID) *customerrors.DomainError {
 	err := d.repository.Like(id, sub)
 	if err != nil {
@@ -240,16 +470,167 @@ func (d *DomainService) Unlike(id uuid.UUID, sub uuid.UUID) *customerrors.Domain
 	return nil
 }
 
-func (d *DomainService) Comment(id uuid.UUID, sub uuid.UUID, comment string) (*CommentView, *customerrors.DomainError) {
+func (d *DomainService) Comment(id uuid.UUID, sub uuid.UUID, comment string, parentID *uuid.UUID) (
+	*CommentView,
+	*customerrors.DomainError,
+) {
+	return d.addComment(id, sub, CommentAuthorUser, comment, parentID)
+}
+
+// OfficialReply posts a comment on behalf of the company owning the promo code.
+func (d *DomainService) OfficialReply(id uuid.UUID, company uuid.UUID, comment string, parentID *uuid.UUID) (
+	*CommentView,
+	*customerrors.DomainError,
+) {
+	return d.addComment(id, company, CommentAuthorBusiness, comment, parentID)
+}
+
+func (d *DomainService) addComment(id, author uuid.UUID, authorType, comment string, parentID *uuid.UUID) (
+	*CommentView,
+	*customerrors.DomainError,
+) {
 	if p, er := d.repository.Get(id); p == nil || er != nil {
 		return nil, customerrors.NotFound()
 	}
-	c := d.repository.Comment(id, sub, comment)
+	if err := d.checkContent(comment); err != nil {
+		return nil, err
+	}
+	c := &Comment{
+		ID:          uuid.New(),
+		PromoCodeID: id,
+		UserID:      author,
+		AuthorType:  authorType,
+		Content:     comment,
+	}
+	if parentID != nil {
+		parent, err := d.repository.FindComment(*parentID, id)
+		if err != nil {
+			return nil, customerrors.NotFound()
+		}
+		if parent.Deleted() {
+			return nil, customerrors.BadRequest("parent comment is deleted")
+		}
+		if parent.Depth >= MaxCommentDepth {
+			c.ParentID = parent.ParentID
+			c.Depth = parent.Depth
+		} else {
+			c.ParentID = &parent.ID
+			c.Depth = parent.Depth + 1
+		}
+		c.RootID = parent.RootID
+		if c.RootID == nil {
+			c.RootID = &parent.ID
+		}
+	}
+	if err := d.repository.Comment(c); err != nil {
+		return nil, err.ToDomain()
+	}
 	return d.GetComment(c.ID, c.PromoCodeID)
 }
 
-func (d *DomainService) GetComments(id uuid.UUID) []*CommentView {
-	return d.repository.GetComments(id)
+func (d *DomainService) FindComment(comment uuid.UUID, promo uuid.UUID) (*Comment, *customerrors.DomainError) {
+	c, err := d.repository.FindComment(comment, promo)
+	if err != nil || c.Deleted() {
+		return nil, customerrors.NotFound()
+	}
+	return c, nil
+}
+
+// GetComments returns a page of threads and the number of threads. Reactions
+// are flagged for viewer.
+func (d *DomainService) GetComments(id uuid.UUID, viewer uuid.UUID, limit *int, offset int, sort string) (
+	[]*CommentView,
+	int,
+) {
+	views, count := d.repository.GetComments(id, limit, offset, sort)
+	d.addReactions(views, viewer)
+	return commentTree(views), count
+}
+
+// GetCommentFor is GetComment with reactions flagged for viewer.
+func (d *DomainService) GetCommentFor(comment uuid.UUID, promo uuid.UUID, viewer uuid.UUID) (
+	*CommentView,
+	*customerrors.DomainError,
+) {
+	v, err := d.GetComment(comment, promo)
+	if err != nil {
+		return nil, err
+	}
+	d.addReactions([]*CommentView{v}, viewer)
+	return v, nil
+}
+
+func (d *DomainService) addReactions(views []*CommentView, viewer uuid.UUID) {
+	ids := make([]uuid.UUID, 0, len(views))
+	for _, v := range views {
+		if !v.Deleted && !v.Hidden {
+			ids = append(ids, v.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	reactions := d.repository.GetReactions(ids, viewer)
+	for _, v := range views {
+		counts := make(map[string]ReactionCount, len(reactions[v.Id]))
+		for _, r := range reactions[v.Id] {
+			counts[r.Key] = r
+		}
+		for _, key := range ReactionKeys {
+			if r, ok := counts[key]; ok {
+				v.Reactions = append(
+					v.Reactions, &ReactionView{
+						Key:             key,
+						Emoji:           Reactions[key],
+						Count:           r.Count,
+						IsReactedByUser: r.Mine,
+					},
+				)
+			}
+		}
+	}
+}
+
+func (d *DomainService) React(comment uuid.UUID, promo uuid.UUID, sub uuid.UUID, reaction string) *customerrors.DomainError {
+	if _, ok := Reactions[reaction]; !ok {
+		return customerrors.BadRequest("unknown reaction " + reaction)
+	}
+	c, err := d.FindComment(comment, promo)
+	if err != nil {
+		return err
+	}
+	if c.Hidden() {
+		return customerrors.NotFound()
+	}
+	er := d.repository.React(&CommentReaction{CommentID: c.ID, UserID: sub, Reaction: reaction})
+	if er != nil {
+		return er.ToDomain()
+	}
+	return nil
+}
+
+func (d *DomainService) Unreact(comment uuid.UUID, promo uuid.UUID, sub uuid.UUID, reaction string) *customerrors.DomainError {
+	if _, err := d.FindComment(comment, promo); err != nil {
+		return err
+	}
+	if err := d.repository.Unreact(&CommentReaction{CommentID: comment, UserID: sub, Reaction: reaction}); err != nil {
+		return customerrors.NotFound()
+	}
+	return nil
+}
+
+// CommentHistory lists previous texts of the comment, oldest first.
+func (d *DomainService) CommentHistory(comment uuid.UUID) []*CommentEditView {
+	var history []*CommentEditView
+	for _, e := range d.repository.GetCommentEdits(comment) {
+		history = append(
+			history, &CommentEditView{
+				Text:       e.Content,
+				ReplacedAt: e.CreatedAt.Format(time.RFC3339),
+			},
+		)
+	}
+	return history
 }
 
 func (d *DomainService) GetComment(comment uuid.UUID, promo uuid.UUID) (*CommentView, *customerrors.DomainError) {
@@ -264,6 +645,9 @@ func (d *DomainService) EditComment(comment uuid.UUID, promo uuid.UUID, commentT
 	*CommentView,
 	*customerrors.DomainError,
 ) {
+	if err := d.checkContent(commentText); err != nil {
+		return nil, err
+	}
 	err := d.repository.EditComment(comment, promo, commentText)
 	if err != nil {
 		return nil, customerrors.NotFound()
@@ -280,17 +664,141 @@ func (d *DomainService) DeleteComment(comment uuid.UUID, promo uuid.UUID) *custo
 	return nil
 }
 
+func (d *DomainService) checkContent(text string) *customerrors.DomainError {
+	if d.moderation.Filter == nil {
+		return nil
+	}
+	if err := d.moderation.Filter.Check(text); err != nil {
+		return customerrors.BadRequest(err.Error())
+	}
+	return nil
+}
+
+// ReportComment records a report of sub; the comment is hidden once it has
+// collected ReportThreshold reports.
+func (d *DomainService) ReportComment(
+	comment uuid.UUID,
+	promo uuid.UUID,
+	sub uuid.UUID,
+	reason ReportReason,
+	details string,
+) *customerrors.DomainError {
+	c, err := d.FindComment(comment, promo)
+	if err != nil {
+		return err
+	}
+	if c.AuthorType == CommentAuthorUser && c.UserID == sub {
+		return customerrors.BadRequest("cannot report own comment")
+	}
+	er := d.repository.ReportComment(
+		&CommentReport{
+			CommentID: c.ID,
+			UserID:    sub,
+			Reason:    reason,
+			Details:   details,
+		}, d.moderation.ReportThreshold,
+	)
+	if er != nil {
+		return er.ToDomain()
+	}
+	return nil
+}
+
+// SetCommentHidden hides or unhides a comment on behalf of the promo owner.
+// Unhiding also clears the report count, so old reports do not hide it again.
+func (d *DomainService) SetCommentHidden(comment uuid.UUID, promo uuid.UUID, hidden bool) *customerrors.DomainError {
+	if _, err := d.FindComment(comment, promo); err != nil {
+		return err
+	}
+	hiddenBy := ""
+	if hidden {
+		hiddenBy = HiddenByBusiness
+	}
+	if err := d.repository.SetCommentHidden(comment, promo, hiddenBy); err != nil {
+		return err.ToDomain()
+	}
+	return nil
+}
+
+// ModerationQueue lists reported or hidden comments on the company's promo
+// codes, most reported first.
+func (d *DomainService) ModerationQueue(company uuid.UUID, hiddenOnly bool, limit *int, offset int) (
+	[]*ModerationItem,
+	int,
+) {
+	return d.repository.GetModerationQueue(company, hiddenOnly, limit, offset)
+}
+
+// Rate stores a rating of a user who activated the promo code. A non-empty
+// comment is posted as a regular comment and linked to the rating.
+func (d *DomainService) Rate(id uuid.UUID, sub uuid.UUID, stars int, comment string) (
+	*Rating,
+	*CommentView,
+	*customerrors.DomainError,
+) {
+	if stars < 1 || stars > 5 {
+		return nil, nil, customerrors.BadRequest("stars must be between 1 and 5")
+	}
+	if _, err := d.repository.Get(id); err != nil {
+		return nil, nil, customerrors.NotFound()
+	}
+	if !d.repository.IsActivated(id, sub) {
+		return nil, nil, customerrors.Forbidden()
+	}
+	rating := &Rating{PromoCodeID: id, UserID: sub, Stars: stars}
+	var c *Comment
+	if comment != "" {
+		if err := d.checkContent(comment); err != nil {
+			return nil, nil, err
+		}
+		c = &Comment{
+			ID:          uuid.New(),
+			PromoCodeID: id,
+			UserID:      sub,
+			AuthorType:  CommentAuthorUser,
+			Content:     comment,
+		}
+	}
+	if err := d.repository.Rate(rating, c); err != nil {
+		return nil, nil, err.ToDomain()
+	}
+	if c == nil {
+		return rating, nil, nil
+	}
+	view, err := d.GetComment(c.ID, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rating, view, nil
+}
+
+// UserRating returns the stars sub gave the promo code, 0 if not rated.
+func (d *DomainService) UserRating(id uuid.UUID, sub uuid.UUID) int {
+	if r := d.repository.GetRating(id, sub); r != nil {
+		return r.Stars
+	}
+	return 0
+}
+
+func (d *DomainService) Unrate(id uuid.UUID, sub uuid.UUID) *customerrors.DomainError {
+	if err := d.repository.Unrate(id, sub); err != nil {
+		return customerrors.NotFound()
+	}
+	return nil
+}
+
 func (d *DomainService) SavePromo(p *PromoCode) {
 	d.repository.Save(p)
 }
-func (d *DomainService) UsePromo(p uuid.UUID, u uuid.UUID, c string) *customerrors.DomainError {
+func (d *DomainService) UsePromo(p uuid.UUID, u uuid.UUID, country string, code string) *customerrors.DomainError {
 	err := d.repository.AddUse(
 		&Use{
 			ID:           uuid.New(),
 			PromoCodeID:  p,
 			UserID:       u,
-			Country:      c,
-			CountryLower: strings.ToLower(c),
+			Country:      country,
+			CountryLower: strings.ToLower(country),
+			Code:         code,
 			CreatedAt:    time.Now(),
 		},
 	)
@@ -300,22 +808,191 @@ func (d *DomainService) UsePromo(p uuid.UUID, u uuid.UUID, c string) *customerro
 	return nil
 }
 
+// UseSince returns the first activation of the promo code by the user at or
+// after since.
+func (d *DomainService) UseSince(promo uuid.UUID, user uuid.UUID, since time.Time) (*Use, *customerrors.DomainError) {
+	use, err := d.repository.GetUseSince(promo, user, since)
+	if err != nil {
+		return nil, err.ToDomain()
+	}
+	return use, nil
+}
+
+// PublishLive is the outbox subscriber that pushes activations, likes and
+// comments to live streams. Live events are best effort, so it never fails.
+// An event delivered again, as after its progress failed to save, is not
+// pushed twice.
+func (d *DomainService) PublishLive(_ context.Context, r *outbox.Record, e outbox.Event) error {
+	if d.events == nil || !d.published.add(r.ID) {
+		return nil
+	}
+	live := &Event{PromoCodeID: r.AggregateID, At: r.CreatedAt}
+	switch e := e.(type) {
+	case *outbox.PromoActivated:
+		live.Type = EventActivation
+		live.Data = map[string]interface{}{"country": e.Country}
+	case *outbox.PromoLiked:
+		live.Type = EventLike
+	case *outbox.CommentPosted:
+		live.Type = EventComment
+		live.Data = map[string]interface{}{
+			"comment_id":  e.CommentID,
+			"parent_id":   e.ParentID,
+			"author_type": e.AuthorType,
+		}
+	default:
+		return nil
+	}
+	d.events.Publish(live)
+	return nil
+}
+
+// Subscribe streams the live events of the promo code to its company,
+// starting after the event with id after.
+func (d *DomainService) Subscribe(company uuid.UUID, promo uuid.UUID, after uint64) (
+	*Subscription,
+	*customerrors.DomainError,
+) {
+	if d.events == nil {
+		return nil, customerrors.NotFound("live events are disabled")
+	}
+	s, ok := d.events.Subscribe(company, promo, after)
+	if !ok {
+		return nil, customerrors.TooManyRequests("too many open streams")
+	}
+	return s, nil
+}
+
+// RecordView records that sub opened the promo code.
+func (d *DomainService) RecordView(id uuid.UUID, sub uuid.UUID) {
+	d.recordImpressions(sub, ImpressionView, id)
+}
+
+func (d *DomainService) recordImpressions(sub uuid.UUID, kind string, ids ...uuid.UUID) {
+	if d.impressions == nil || len(ids) == 0 {
+		return
+	}
+	now := time.Now().UTC()
+	day := utcDay(now)
+	impressions := make([]*Impression, 0, len(ids))
+	for _, id := range ids {
+		impressions = append(
+			impressions, &Impression{
+				PromoCodeID: id,
+				UserID:      sub,
+				Day:         day,
+				Kind:        kind,
+				CreatedAt:   now,
+			},
+		)
+	}
+	d.impressions.Record(impressions...)
+}
+
+func (d *DomainService) Redeem(promo uuid.UUID, code string, user *uuid.UUID) (*Use, *customerrors.DomainError) {
+	p, err := d.repository.Get(promo)
+	if err != nil {
+		return nil, customerrors.NotFound()
+	}
+	if p.Mode == COMMON && user == nil {
+		return nil, customerrors.BadRequest("user_id is required to redeem a COMMON promo code")
+	}
+	u, er := d.repository.Redeem(promo, code, user)
+	if er != nil {
+		return nil, er.ToDomain()
+	}
+	return u, nil
+}
+
+// Funnel is the funnel of one promo code of the company within [from, to).
+func (d *DomainService) Funnel(company uuid.UUID, promo uuid.UUID, from, to time.Time) map[string]interface{} {
+	f, ok := d.repository.GetFunnels(company, &promo, from, to)[promo]
+	if !ok {
+		f = &Funnel{}
+	}
+	return f.View()
+}
+
+// CompanyFunnel sums the funnels of all promo codes of the company. Stage
+// counts are summed per promo code, so a user seeing two promo codes counts
+// twice. Promo codes are listed by activations, most first.
+func (d *DomainService) CompanyFunnel(company uuid.UUID, from, to time.Time) map[string]interface{} {
+	funnels := d.repository.GetFunnels(company, nil, from, to)
+	ids := make([]uuid.UUID, 0, len(funnels))
+	for id := range funnels {
+		ids = append(ids, id)
+	}
+	sort.Slice(
+		ids, func(i, j int) bool {
+			a, b := funnels[ids[i]], funnels[ids[j]]
+			if a.Activations != b.Activations {
+				return a.Activations > b.Activations
+			}
+			return a.Impressions > b.Impressions
+		},
+	)
+	total := &Funnel{}
+	promos := make([]map[string]interface{}, 0, len(ids))
+	for _, id := range ids {
+		total.Add(funnels[id])
+		v := funnels[id].View()
+		v["promo_id"] = id
+		promos = append(promos, v)
+	}
+	r := total.View()
+	r["promos"] = promos
+	return r
+}
+
 func (d *DomainService) UseHistory(id uuid.UUID) []map[string]interface{} {
 	uses := d.repository.UseHistory(id)
+	var ids []uuid.UUID
+	seen := make(map[uuid.UUID]bool)
+	for _, u := range uses {
+		if !seen[u.PromoCodeID] {
+			seen[u.PromoCodeID] = true
+			ids = append(ids, u.PromoCodeID)
+		}
+	}
+	promos := make(map[uuid.UUID]*PromoCode, len(ids))
+	for _, p := range d.repository.GetMany(ids) {
+		promos[p.ID] = p
+	}
+	liked := d.repository.IsLikedMany(ids, id)
+	saved := d.repository.IsSavedMany(ids, id)
 	var result []map[string]interface{}
 	for _, u := range uses {
-		p, pd, _ := d.Get2(u.PromoCodeID)
-
+		p, ok := promos[u.PromoCodeID]
+		if !ok {
+			continue
+		}
 		result = append(
 			result,
 			p.ToUserView(
-				pd.Active,
-				d.repository.IsActivated(p.ID, u.UserID),
-				d.repository.IsLiked(p.ID, u.UserID),
-				pd.Likes,
-				pd.Comments,
+				d.IsActive(p),
+				true,
+				liked[p.ID],
+				saved[p.ID],
+				p.LikeCount,
+				p.CommentCount,
 			),
 		)
 	}
 	return result
 }
+
+func (d *DomainService) ReconcileCounters(apply bool) ([]CounterDrift, *customerrors.DomainError) {
+	drift, err := d.repository.ReconcileCounters(apply)
+	if err != nil {
+		return nil, err.ToDomain()
+	}
+	return drift, nil
+}
+
+func promoIDs(promos []*PromoCode) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(promos))
+	for _, p := range promos {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
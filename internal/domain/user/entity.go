@@ -3,6 +3,7 @@ package user
 import (
 	"github.com/google/uuid"
 	"github.com/intezya/pkglib"
+	"time"
 )
 
 type User struct {
@@ -19,6 +20,9 @@ type User struct {
 	PasswordHash string `gorm:"type:TEXT;not null"`
 
 	AvatarURL *string `gorm:"type:TEXT"`
+
+	// CreatedAt is unknown for accounts registered before it was recorded.
+	CreatedAt *time.Time
 }
 
 func (*User) TableName() string {
@@ -0,0 +1,45 @@
+package webhook
+
+// ToView shows the secret only when withSecret is set, which is right after
+// it was created or rotated.
+func (w *Webhook) ToView(withSecret bool) map[string]interface{} {
+	events := []string(w.Events)
+	if events == nil {
+		events = []string{}
+	}
+	r := map[string]interface{}{
+		"id":         w.ID,
+		"url":        w.URL,
+		"events":     events,
+		"active":     w.Active,
+		"created_at": w.CreatedAt,
+	}
+	if withSecret {
+		r["secret"] = w.Secret
+	}
+	return r
+}
+
+func (d *Delivery) ToView() map[string]interface{} {
+	r := map[string]interface{}{
+		"id":         d.ID,
+		"event_id":   d.EventID,
+		"event_type": d.EventType,
+		"status":     d.Status,
+		"attempts":   d.Attempts,
+		"created_at": d.CreatedAt,
+	}
+	if d.LastStatusCode != 0 {
+		r["last_status_code"] = d.LastStatusCode
+	}
+	if d.LastError != "" {
+		r["last_error"] = d.LastError
+	}
+	if d.Status == StatusPending {
+		r["next_attempt_at"] = d.NextAttemptAt
+	}
+	if d.DeliveredAt != nil {
+		r["delivered_at"] = d.DeliveredAt
+	}
+	return r
+}
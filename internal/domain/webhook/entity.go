@@ -0,0 +1,75 @@
+package webhook
+
+import (
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"solution/internal/domain/outbox"
+	"time"
+)
+
+// Webhook is an endpoint of a company that gets the promo code events it
+// subscribed to; no events means all of them.
+type Webhook struct {
+	ID        uuid.UUID      `gorm:"type:uuid;primaryKey"`
+	CompanyID uuid.UUID      `gorm:"type:uuid;not null;index"`
+	URL       string         `gorm:"type:text;not null"`
+	Secret    string         `gorm:"type:text;not null"`
+	Events    pq.StringArray `gorm:"type:text[]"`
+	Active    bool           `gorm:"not null;default:true"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Wants tells whether the webhook subscribed to the event type.
+func (w *Webhook) Wants(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	// StatusDead marks deliveries that ran out of attempts: the dead-letter
+	// list.
+	StatusDead = "dead"
+)
+
+// EventTest is the type of the event sent by the test endpoint.
+const EventTest = "webhook.test"
+
+// Events are the outbox event types webhooks can subscribe to.
+var Events = []string{
+	outbox.TypePromoActivated,
+	outbox.TypePromoExhausted,
+	outbox.TypePromoExpired,
+	outbox.TypeCommentPosted,
+}
+
+// Delivery is one event to be sent to one webhook, and the log of its
+// attempts so far.
+type Delivery struct {
+	ID             uuid.UUID `gorm:"type:uuid;primaryKey"`
+	WebhookID      uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_deliveries_event,priority:1"`
+	EventID        uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_deliveries_event,priority:2"`
+	EventType      string    `gorm:"type:varchar(32);not null"`
+	Payload        string    `gorm:"type:jsonb;not null"`
+	Status         string    `gorm:"type:varchar(16);not null;index:idx_deliveries_due,priority:1"`
+	Attempts       int       `gorm:"not null;default:0"`
+	NextAttemptAt  time.Time `gorm:"not null;index:idx_deliveries_due,priority:2"`
+	LastStatusCode int       `gorm:"not null;default:0"`
+	LastError      string    `gorm:"type:text"`
+	DeliveredAt    *time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+func (*Delivery) TableName() string {
+	return "webhook_deliveries"
+}
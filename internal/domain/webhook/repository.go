@@ -0,0 +1,26 @@
+package webhook
+
+import (
+	"github.com/google/uuid"
+	customerrors "solution/internal/domain/errors"
+	"time"
+)
+
+type Repository interface {
+	Create(w *Webhook) *customerrors.RepositoryError
+	Get(id uuid.UUID) (*Webhook, *customerrors.RepositoryError)
+	GetByCompany(company uuid.UUID) []*Webhook
+	GetActiveByCompany(company uuid.UUID) []*Webhook
+	Save(w *Webhook) *customerrors.RepositoryError
+	Delete(id uuid.UUID) *customerrors.RepositoryError
+
+	// ClaimDue returns up to limit pending deliveries due at now and pushes
+	// their next attempt to now+lease, so no one else picks them meanwhile.
+	ClaimDue(now time.Time, lease time.Duration, limit int) []*Delivery
+	AddDelivery(d *Delivery) *customerrors.RepositoryError
+	// AddDeliveries skips deliveries of an event the webhook already has.
+	AddDeliveries(deliveries []*Delivery) *customerrors.RepositoryError
+	SaveDelivery(d *Delivery) *customerrors.RepositoryError
+	GetDelivery(id uuid.UUID, webhook uuid.UUID) (*Delivery, *customerrors.RepositoryError)
+	GetDeliveries(webhook uuid.UUID, status string, limit *int, offset int) ([]*Delivery, int)
+}
@@ -0,0 +1,329 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+	"math/big"
+	customerrors "solution/internal/domain/errors"
+	"solution/internal/domain/outbox"
+	"solution/internal/domain/promocode"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Sender posts a signed event to a webhook URL.
+type Sender interface {
+	Send(ctx context.Context, url string, headers map[string]string, body []byte) (status int, err error)
+}
+
+const (
+	// MaxAttempts is the number of attempts before a delivery is dead.
+	MaxAttempts = 8
+	// Retries wait retryBase, doubling up to retryCap, give or take a tenth.
+	retryBase = 30 * time.Second
+	retryCap  = 6 * time.Hour
+	// deliveryLease is how long a claimed delivery is left to its sender.
+	deliveryLease = 2 * time.Minute
+	dispatchBatch = 50
+	// maxErrorLength bounds the error kept in the delivery log.
+	maxErrorLength = 500
+)
+
+type DomainService struct {
+	repo   Repository
+	sender Sender
+}
+
+func NewDomainService(repo Repository, sender Sender) *DomainService {
+	return &DomainService{repo: repo, sender: sender}
+}
+
+// Register adds a webhook; a secret is generated when none is given.
+func (d *DomainService) Register(company uuid.UUID, url string, events []string, secret string) (
+	*Webhook,
+	*customerrors.DomainError,
+) {
+	if secret == "" {
+		secret = NewSecret()
+	}
+	w := &Webhook{
+		ID:        uuid.New(),
+		CompanyID: company,
+		URL:       url,
+		Secret:    secret,
+		Events:    pq.StringArray(events),
+		Active:    true,
+	}
+	if err := d.repo.Create(w); err != nil {
+		return nil, err.ToDomain()
+	}
+	return w, nil
+}
+
+// Get returns the company's webhook.
+func (d *DomainService) Get(company uuid.UUID, id uuid.UUID) (*Webhook, *customerrors.DomainError) {
+	w, err := d.repo.Get(id)
+	if err != nil {
+		return nil, customerrors.NotFound()
+	}
+	if w.CompanyID != company {
+		return nil, customerrors.Forbidden()
+	}
+	return w, nil
+}
+
+func (d *DomainService) List(company uuid.UUID) []*Webhook {
+	return d.repo.GetByCompany(company)
+}
+
+func (d *DomainService) Save(w *Webhook) *customerrors.DomainError {
+	if err := d.repo.Save(w); err != nil {
+		return err.ToDomain()
+	}
+	return nil
+}
+
+func (d *DomainService) Delete(company uuid.UUID, id uuid.UUID) *customerrors.DomainError {
+	if _, err := d.Get(company, id); err != nil {
+		return err
+	}
+	if err := d.repo.Delete(id); err != nil {
+		return err.ToDomain()
+	}
+	return nil
+}
+
+// Test sends a webhook.test event right away, once, and logs it as a
+// delivery.
+func (d *DomainService) Test(ctx context.Context, company uuid.UUID, id uuid.UUID) (
+	*Delivery,
+	*customerrors.DomainError,
+) {
+	w, err := d.Get(company, id)
+	if err != nil {
+		return nil, err
+	}
+	eventID := uuid.New()
+	data, _ := json.Marshal(map[string]interface{}{"webhook_id": w.ID})
+	delivery := &Delivery{
+		ID:            uuid.New(),
+		WebhookID:     w.ID,
+		EventID:       eventID,
+		EventType:     EventTest,
+		Payload:       Envelope(eventID, EventTest, time.Now(), data),
+		Status:        StatusPending,
+		NextAttemptAt: time.Now(),
+	}
+	d.attempt(ctx, w, delivery)
+	if delivery.Status == StatusPending {
+		// Tests are not retried.
+		delivery.Status = StatusDead
+	}
+	if err := d.repo.AddDelivery(delivery); err != nil {
+		return nil, err.ToDomain()
+	}
+	return delivery, nil
+}
+
+// Deliveries is the delivery log of the webhook, newest first, optionally of
+// one status only.
+func (d *DomainService) Deliveries(company uuid.UUID, id uuid.UUID, status string, limit *int, offset int) (
+	[]*Delivery,
+	int,
+	*customerrors.DomainError,
+) {
+	if _, err := d.Get(company, id); err != nil {
+		return nil, 0, err
+	}
+	deliveries, count := d.repo.GetDeliveries(id, status, limit, offset)
+	return deliveries, count, nil
+}
+
+// Retry takes a dead delivery off the dead-letter list and starts its
+// attempts over.
+func (d *DomainService) Retry(company uuid.UUID, id uuid.UUID, deliveryID uuid.UUID) (
+	*Delivery,
+	*customerrors.DomainError,
+) {
+	if _, err := d.Get(company, id); err != nil {
+		return nil, err
+	}
+	delivery, err := d.repo.GetDelivery(deliveryID, id)
+	if err != nil {
+		return nil, customerrors.NotFound()
+	}
+	if delivery.Status != StatusDead {
+		return nil, customerrors.BadRequest("only dead deliveries can be retried")
+	}
+	delivery.Status = StatusPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = time.Now()
+	if err := d.repo.SaveDelivery(delivery); err != nil {
+		return nil, err.ToDomain()
+	}
+	return delivery, nil
+}
+
+// HandleEvent is the outbox subscriber, for Events, that turns events into
+// deliveries to the active webhooks of the company that want them. Comments
+// by the company itself are not sent back to it. Deliveries are unique per webhook and
+// event, so handling an event again adds none.
+func (d *DomainService) HandleEvent(_ context.Context, r *outbox.Record, e outbox.Event) error {
+	if c, ok := e.(*outbox.CommentPosted); ok && c.AuthorType != promocode.CommentAuthorUser {
+		return nil
+	}
+	now := time.Now()
+	var deliveries []*Delivery
+	for _, w := range d.repo.GetActiveByCompany(r.CompanyID) {
+		if !w.Wants(r.Type) {
+			continue
+		}
+		deliveries = append(
+			deliveries, &Delivery{
+				ID:            uuid.New(),
+				WebhookID:     w.ID,
+				EventID:       r.ID,
+				EventType:     r.Type,
+				Payload:       Envelope(r.ID, r.Type, r.CreatedAt, webhookData(r)),
+				Status:        StatusPending,
+				NextAttemptAt: now,
+			},
+		)
+	}
+	if err := d.repo.AddDeliveries(deliveries); err != nil {
+		return errors.New(err.DebugDetail)
+	}
+	return nil
+}
+
+// Dispatch sends the deliveries that are due, concurrently.
+func (d *DomainService) Dispatch(ctx context.Context) {
+	due := d.repo.ClaimDue(time.Now(), deliveryLease, dispatchBatch)
+	webhooks := make(map[uuid.UUID]*Webhook)
+	var wg sync.WaitGroup
+	for _, delivery := range due {
+		w, ok := webhooks[delivery.WebhookID]
+		if !ok {
+			w, _ = d.repo.Get(delivery.WebhookID)
+			webhooks[delivery.WebhookID] = w
+		}
+		wg.Add(1)
+		go func(w *Webhook, delivery *Delivery) {
+			defer wg.Done()
+			if w == nil || !w.Active {
+				delivery.Status = StatusDead
+				delivery.LastError = "webhook is deleted or disabled"
+			} else {
+				d.attempt(ctx, w, delivery)
+			}
+			if err := d.repo.SaveDelivery(delivery); err != nil {
+				zap.S().Errorw("webhook delivery", "delivery", delivery.ID, "error", err.DebugDetail)
+			}
+		}(w, delivery)
+	}
+	wg.Wait()
+}
+
+// attempt sends the delivery once and updates its state; it is saved by the
+// caller.
+func (d *DomainService) attempt(ctx context.Context, w *Webhook, delivery *Delivery) {
+	now := time.Now()
+	body := []byte(delivery.Payload)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	headers := map[string]string{
+		"Content-Type":        "application/json",
+		"X-Webhook-Id":        delivery.EventID.String(),
+		"X-Webhook-Event":     delivery.EventType,
+		"X-Webhook-Timestamp": timestamp,
+		"X-Webhook-Signature": "sha256=" + Sign(w.Secret, timestamp, body),
+	}
+	status, err := d.sender.Send(ctx, w.URL, headers, body)
+
+	delivery.Attempts++
+	delivery.LastStatusCode = status
+	delivery.LastError = ""
+	switch {
+	case err == nil && status >= 200 && status < 300:
+		delivery.Status = StatusDelivered
+		delivery.DeliveredAt = &now
+		return
+	case err != nil:
+		delivery.LastError = err.Error()
+	default:
+		delivery.LastError = "unexpected status " + strconv.Itoa(status)
+	}
+	if len(delivery.LastError) > maxErrorLength {
+		delivery.LastError = delivery.LastError[:maxErrorLength]
+	}
+	// 410 Gone means the endpoint is not coming back.
+	if delivery.Attempts >= MaxAttempts || status == 410 {
+		delivery.Status = StatusDead
+		return
+	}
+	delivery.NextAttemptAt = now.Add(retryDelay(delivery.Attempts))
+}
+
+// retryDelay is the wait after the given number of failed attempts.
+func retryDelay(attempts int) time.Duration {
+	delay := retryCap
+	if shift := attempts - 1; shift < 20 {
+		delay = min(retryBase<<shift, retryCap)
+	}
+	jitter, _ := rand.Int(rand.Reader, big.NewInt(int64(delay/5)+1))
+	return delay - delay/10 + time.Duration(jitter.Int64())
+}
+
+// webhookData is the payload of the record as sent to third parties. The
+// author of a comment is left out: comments are public, who wrote them
+// isn't the business's to know.
+func webhookData(r *outbox.Record) json.RawMessage {
+	if r.Type != outbox.TypeCommentPosted {
+		return json.RawMessage(r.Payload)
+	}
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(r.Payload), &data); err != nil {
+		return json.RawMessage(r.Payload)
+	}
+	delete(data, "user_id")
+	body, _ := json.Marshal(data)
+	return body
+}
+
+// Envelope is the body sent for an event; id is the same for every delivery
+// of the event, so receivers can drop duplicates.
+func Envelope(id uuid.UUID, event string, at time.Time, data json.RawMessage) string {
+	body, _ := json.Marshal(
+		map[string]interface{}{
+			"id":         id,
+			"type":       event,
+			"created_at": at.UTC(),
+			"data":       data,
+		},
+	)
+	return string(body)
+}
+
+// Sign is the hex HMAC-SHA256 of "timestamp.body" keyed by the secret, sent
+// as X-Webhook-Signature. Receivers should also reject old timestamps.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func NewSecret() string {
+	b := make([]byte, 24)
+	_, _ = rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
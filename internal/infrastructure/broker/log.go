@@ -0,0 +1,31 @@
+package broker
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"solution/internal/domain/outbox"
+)
+
+// LogBroker is an outbox.Broker that writes events to the log, a stand-in
+// until a message broker is attached.
+type LogBroker struct{}
+
+func NewLogBroker() *LogBroker {
+	return &LogBroker{}
+}
+
+func (*LogBroker) Name() string {
+	return "log"
+}
+
+func (*LogBroker) Publish(_ context.Context, r *outbox.Record) error {
+	zap.S().Infow(
+		"outbox event",
+		"id", r.ID,
+		"type", r.Type,
+		"aggregate", r.AggregateID,
+		"company", r.CompanyID,
+		"payload", r.Payload,
+	)
+	return nil
+}
@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"solution/internal/domain/business"
+	customerrors "solution/internal/domain/errors"
+	"solution/internal/domain/promocode"
+)
+
+// BusinessRepository drops the cached promo codes of a company that was
+// renamed, since they carry its name.
+type BusinessRepository struct {
+	business.Repository
+	promos promocode.Repository
+	cache  *Cache
+}
+
+// NewBusinessRepository takes the uncached promo repository and the cache of
+// promo codes.
+func NewBusinessRepository(
+	repository business.Repository,
+	promos promocode.Repository,
+	cache *Cache,
+) *BusinessRepository {
+	return &BusinessRepository{Repository: repository, promos: promos, cache: cache}
+}
+
+func (r *BusinessRepository) Save(b *business.Business) *customerrors.RepositoryError {
+	old, err := r.Repository.Get(b.ID)
+	if err != nil {
+		return err
+	}
+	if err := r.Repository.Save(b); err != nil {
+		return err
+	}
+	if old.CompanyName != b.CompanyName {
+		promos, _ := r.promos.GetByCompanyIDAsCompanyList(b.ID, &promocode.GetAsCompanyListParams{})
+		keys := make([]string, 0, len(promos))
+		for _, p := range promos {
+			keys = append(keys, promoKey(p.ID))
+		}
+		if len(keys) > 0 {
+			r.cache.invalidate(keys...)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+	customerrors "solution/internal/domain/errors"
+	"time"
+)
+
+var metrics = expvar.NewMap("cache")
+
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, bool)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration)
+	Delete(ctx context.Context, keys ...string)
+}
+
+// Cache stores JSON encoded values, so every hit returns a private copy that
+// callers are free to mutate.
+type Cache struct {
+	store Store
+	ttl   time.Duration
+	name  string
+	group singleflight.Group
+}
+
+func New(store Store, ttl time.Duration, name string) *Cache {
+	return &Cache{store: store, ttl: ttl, name: name}
+}
+
+func (c *Cache) invalidate(keys ...string) {
+	c.store.Delete(context.Background(), keys...)
+}
+
+// readThrough returns the cached value for key or loads it. Concurrent misses
+// on the same key share a single load.
+func readThrough[T any](
+	c *Cache,
+	key string,
+	load func() (*T, *customerrors.RepositoryError),
+) (*T, *customerrors.RepositoryError) {
+	ctx := context.Background()
+	if data, ok := c.store.Get(ctx, key); ok {
+		var v T
+		if err := json.Unmarshal(data, &v); err == nil {
+			metrics.Add(c.name+"_hits", 1)
+			return &v, nil
+		}
+		c.store.Delete(ctx, key)
+	}
+	metrics.Add(c.name+"_misses", 1)
+
+	data, err, _ := c.group.Do(
+		key, func() (interface{}, error) {
+			v, er := load()
+			if er != nil {
+				return nil, er
+			}
+			data, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			c.store.Set(ctx, key, data, c.ttl)
+			return data, nil
+		},
+	)
+	if err != nil {
+		var re *customerrors.RepositoryError
+		if errors.As(err, &re) {
+			return nil, re
+		}
+		return nil, customerrors.UnknownErrorInRepository(err.Error())
+	}
+	var v T
+	if err := json.Unmarshal(data.([]byte), &v); err != nil {
+		zap.S().Errorw("cache decode failed", "key", key, "error", err)
+		return nil, customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return &v, nil
+}
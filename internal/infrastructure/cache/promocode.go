@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"github.com/google/uuid"
+	customerrors "solution/internal/domain/errors"
+	"solution/internal/domain/outbox"
+	"solution/internal/domain/promocode"
+)
+
+type PromoCodeRepository struct {
+	promocode.Repository
+	cache *Cache
+}
+
+func NewPromoCodeRepository(repository promocode.Repository, cache *Cache) *PromoCodeRepository {
+	return &PromoCodeRepository{Repository: repository, cache: cache}
+}
+
+func promoKey(id uuid.UUID) string {
+	return "promo:" + id.String()
+}
+
+func (r *PromoCodeRepository) Get(id uuid.UUID) (*promocode.PromoCode, *customerrors.RepositoryError) {
+	return readThrough(
+		r.cache, promoKey(id), func() (*promocode.PromoCode, *customerrors.RepositoryError) {
+			return r.Repository.Get(id)
+		},
+	)
+}
+
+func (r *PromoCodeRepository) Save(p *promocode.PromoCode, events ...outbox.Event) {
+	r.Repository.Save(p, events...)
+	r.cache.invalidate(promoKey(p.ID))
+}
+
+func (r *PromoCodeRepository) Delete(id uuid.UUID) *customerrors.RepositoryError {
+	defer r.cache.invalidate(promoKey(id))
+	return r.Repository.Delete(id)
+}
+
+func (r *PromoCodeRepository) Like(id uuid.UUID, sub uuid.UUID) *customerrors.RepositoryError {
+	defer r.cache.invalidate(promoKey(id))
+	return r.Repository.Like(id, sub)
+}
+
+func (r *PromoCodeRepository) Unlike(id uuid.UUID, sub uuid.UUID) *customerrors.RepositoryError {
+	defer r.cache.invalidate(promoKey(id))
+	return r.Repository.Unlike(id, sub)
+}
+
+func (r *PromoCodeRepository) Comment(c *promocode.Comment) *customerrors.RepositoryError {
+	defer r.cache.invalidate(promoKey(c.PromoCodeID))
+	return r.Repository.Comment(c)
+}
+
+func (r *PromoCodeRepository) Rate(rating *promocode.Rating, comment *promocode.Comment) *customerrors.RepositoryError {
+	defer r.cache.invalidate(promoKey(rating.PromoCodeID))
+	return r.Repository.Rate(rating, comment)
+}
+
+func (r *PromoCodeRepository) Unrate(id uuid.UUID, sub uuid.UUID) *customerrors.RepositoryError {
+	defer r.cache.invalidate(promoKey(id))
+	return r.Repository.Unrate(id, sub)
+}
+
+func (r *PromoCodeRepository) DeleteComment(comment uuid.UUID, promo uuid.UUID) *customerrors.RepositoryError {
+	defer r.cache.invalidate(promoKey(promo))
+	return r.Repository.DeleteComment(comment, promo)
+}
+
+func (r *PromoCodeRepository) AddUse(u *promocode.Use) *customerrors.RepositoryError {
+	defer r.cache.invalidate(promoKey(u.PromoCodeID))
+	return r.Repository.AddUse(u)
+}
+
+func (r *PromoCodeRepository) ReconcileCounters(apply bool) (
+	[]promocode.CounterDrift,
+	*customerrors.RepositoryError,
+) {
+	drift, err := r.Repository.ReconcileCounters(apply)
+	if apply {
+		for _, d := range drift {
+			r.cache.invalidate(promoKey(d.PromoCodeID))
+		}
+	}
+	return drift, err
+}
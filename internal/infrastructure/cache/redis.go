@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"time"
+)
+
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client, prefix: "cache:"}
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) ([]byte, bool) {
+	data, err := s.client.Get(ctx, s.prefix+key).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			zap.S().Warnw("redis cache get failed", "key", key, "error", err)
+		}
+		return nil, false
+	}
+	return data, true
+}
+
+func (s *RedisStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) {
+	if err := s.client.Set(ctx, s.prefix+key, value, ttl).Err(); err != nil {
+		zap.S().Warnw("redis cache set failed", "key", key, "error", err)
+	}
+}
+
+func (s *RedisStore) Delete(ctx context.Context, keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = s.prefix + key
+	}
+	if err := s.client.Del(ctx, prefixed...).Err(); err != nil {
+		zap.S().Warnw("redis cache delete failed", "keys", keys, "error", err)
+	}
+}
@@ -0,0 +1,33 @@
+package cache
+
+import (
+	"github.com/google/uuid"
+	customerrors "solution/internal/domain/errors"
+	"solution/internal/domain/user"
+)
+
+type UserRepository struct {
+	user.Repository
+	cache *Cache
+}
+
+func NewUserRepository(repository user.Repository, cache *Cache) *UserRepository {
+	return &UserRepository{Repository: repository, cache: cache}
+}
+
+func userKey(id uuid.UUID) string {
+	return "user:" + id.String()
+}
+
+func (r *UserRepository) Get(id uuid.UUID) (*user.User, *customerrors.RepositoryError) {
+	return readThrough(
+		r.cache, userKey(id), func() (*user.User, *customerrors.RepositoryError) {
+			return r.Repository.Get(id)
+		},
+	)
+}
+
+func (r *UserRepository) Save(u *user.User) {
+	r.Repository.Save(u)
+	r.cache.invalidate(userKey(u.ID))
+}
@@ -0,0 +1,123 @@
+package events
+
+import (
+	"expvar"
+	"github.com/google/uuid"
+	"solution/internal/domain/promocode"
+	"sync"
+)
+
+var busStats = expvar.NewMap("events")
+
+// subscriberBuffer is the number of events a subscriber may lag behind before
+// it is dropped.
+const subscriberBuffer = 64
+
+// Bus is an in-process promocode.EventBus. It keeps the last events of all
+// promo codes in a ring buffer to let subscribers resume, and limits the
+// number of subscriptions per company.
+type Bus struct {
+	mu     sync.Mutex
+	nextID uint64
+	ring   []*promocode.Event
+	head   int
+	subs   map[uuid.UUID]map[*subscriber]struct{}
+	conns  map[uuid.UUID]int
+	limit  int
+}
+
+type subscriber struct {
+	events chan *promocode.Event
+	closed bool
+}
+
+// NewBus keeps up to bufferSize recent events and allows up to
+// maxPerCompany concurrent subscriptions per company.
+func NewBus(bufferSize int, maxPerCompany int) *Bus {
+	return &Bus{
+		ring:  make([]*promocode.Event, bufferSize),
+		subs:  make(map[uuid.UUID]map[*subscriber]struct{}),
+		conns: make(map[uuid.UUID]int),
+		limit: maxPerCompany,
+	}
+}
+
+func (b *Bus) Publish(e *promocode.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	e.ID = b.nextID
+	if len(b.ring) > 0 {
+		b.ring[b.head] = e
+		b.head = (b.head + 1) % len(b.ring)
+	}
+	busStats.Add("published", 1)
+	for s := range b.subs[e.PromoCodeID] {
+		select {
+		case s.events <- e:
+		default:
+			// Too slow; it resumes from the ring after reconnecting.
+			b.drop(e.PromoCodeID, s)
+			busStats.Add("dropped_subscribers", 1)
+		}
+	}
+}
+
+// Subscribe ignores after when it is ahead of the last event, as after a
+// restart of the process.
+func (b *Bus) Subscribe(company uuid.UUID, promo uuid.UUID, after uint64) (*promocode.Subscription, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conns[company] >= b.limit {
+		busStats.Add("rejected_subscribers", 1)
+		return nil, false
+	}
+	b.conns[company]++
+
+	var backlog []*promocode.Event
+	if after > 0 && after < b.nextID {
+		for i := range b.ring {
+			e := b.ring[(b.head+i)%len(b.ring)]
+			if e != nil && e.ID > after && e.PromoCodeID == promo {
+				backlog = append(backlog, e)
+			}
+		}
+	}
+
+	s := &subscriber{events: make(chan *promocode.Event, subscriberBuffer)}
+	if b.subs[promo] == nil {
+		b.subs[promo] = make(map[*subscriber]struct{})
+	}
+	b.subs[promo][s] = struct{}{}
+
+	var once sync.Once
+	return &promocode.Subscription{
+		Backlog: backlog,
+		Events:  s.events,
+		Close: func() {
+			once.Do(
+				func() {
+					b.mu.Lock()
+					defer b.mu.Unlock()
+					b.drop(promo, s)
+					if b.conns[company]--; b.conns[company] <= 0 {
+						delete(b.conns, company)
+					}
+				},
+			)
+		},
+	}, true
+}
+
+// drop unsubscribes s and closes its channel; b.mu must be held.
+func (b *Bus) drop(promo uuid.UUID, s *subscriber) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.events)
+	delete(b.subs[promo], s)
+	if len(b.subs[promo]) == 0 {
+		delete(b.subs, promo)
+	}
+}
@@ -0,0 +1,40 @@
+package moderation
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+var ErrBlockedWord = errors.New("comment contains a blocked word")
+
+// WordListFilter rejects texts containing any of the configured words. Words
+// are matched case-insensitively and as whole words only.
+type WordListFilter struct {
+	words map[string]struct{}
+}
+
+func NewWordListFilter(words []string) *WordListFilter {
+	f := &WordListFilter{words: make(map[string]struct{}, len(words))}
+	for _, w := range words {
+		w = strings.ToLower(strings.TrimSpace(w))
+		if w != "" {
+			f.words[w] = struct{}{}
+		}
+	}
+	return f
+}
+
+func (f *WordListFilter) Check(text string) error {
+	tokens := strings.FieldsFunc(
+		strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		},
+	)
+	for _, t := range tokens {
+		if _, ok := f.words[t]; ok {
+			return ErrBlockedWord
+		}
+	}
+	return nil
+}
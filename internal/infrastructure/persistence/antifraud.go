@@ -0,0 +1,170 @@
+package persistence
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"solution/internal/domain/antifraud"
+	customerrors "solution/internal/domain/errors"
+	"time"
+)
+
+type AntifraudRepository struct {
+	db *gorm.DB
+}
+
+func NewAntifraudRepository(db *gorm.DB) *AntifraudRepository {
+	return &AntifraudRepository{db: db}
+}
+
+func (r *AntifraudRepository) AddDecision(d *antifraud.Decision) *customerrors.RepositoryError {
+	if err := r.db.Create(d).Error; err != nil {
+		return customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return nil
+}
+
+func (r *AntifraudRepository) GetDecisions(promo uuid.UUID, outcome string, limit *int, offset int) (
+	[]*antifraud.Decision,
+	int,
+) {
+	return r.SearchDecisions(&antifraud.DecisionFilter{PromoID: &promo, Outcome: outcome}, limit, offset)
+}
+
+func (r *AntifraudRepository) SearchDecisions(filter *antifraud.DecisionFilter, limit *int, offset int) (
+	[]*antifraud.Decision,
+	int,
+) {
+	query := r.db.Model(&antifraud.Decision{})
+	if filter.UserID != nil {
+		query = query.Where("user_id = ?", *filter.UserID)
+	}
+	if filter.PromoID != nil {
+		query = query.Where("promo_code_id = ?", *filter.PromoID)
+	}
+	if filter.Outcome != "" {
+		query = query.Where("outcome = ?", filter.Outcome)
+	}
+	if filter.Source != "" {
+		query = query.Where("source = ?", filter.Source)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+	var count int64
+	query.Count(&count)
+	if limit != nil {
+		query = query.Limit(*limit)
+	}
+	var decisions []*antifraud.Decision
+	query.Preload("Hits").Order("created_at DESC").Offset(offset).Find(&decisions)
+	return decisions, int(count)
+}
+
+const shadowReportSQL = `
+SELECT h.rule, h.shadow,
+	COUNT(*) AS hits,
+	COUNT(*) FILTER (WHERE h.outcome = 'deny') AS deny,
+	COUNT(*) FILTER (WHERE h.outcome = 'review') AS review,
+	COUNT(*) FILTER (WHERE d.outcome = 'allow') AS allowed
+FROM antifraud_rule_hits h
+JOIN antifraud_decisions d ON d.id = h.decision_id
+WHERE d.source IN @sources AND d.created_at >= @from AND d.created_at < @to
+GROUP BY h.rule, h.shadow
+ORDER BY h.rule, h.shadow
+`
+
+func (r *AntifraudRepository) GetShadowReport(from time.Time, to time.Time) (
+	*antifraud.ShadowReport,
+	*customerrors.RepositoryError,
+) {
+	report := &antifraud.ShadowReport{From: from, To: to, Rules: []*antifraud.RuleReport{}}
+	args := map[string]interface{}{
+		"sources": []string{antifraud.SourceChecker, antifraud.SourceCache},
+		"cache":   antifraud.SourceCache,
+		"from":    from,
+		"to":      to,
+	}
+	var totals struct {
+		Decisions int
+		Blocked   int
+		Cached    int
+	}
+	err := r.db.Raw(
+		`SELECT COUNT(*) AS decisions, COUNT(*) FILTER (WHERE outcome <> 'allow') AS blocked,
+			COUNT(*) FILTER (WHERE source = @cache) AS cached
+		FROM antifraud_decisions WHERE source IN @sources AND created_at >= @from AND created_at < @to`, args,
+	).Scan(&totals).Error
+	if err != nil {
+		return nil, customerrors.UnknownErrorInRepository(err.Error())
+	}
+	report.Decisions, report.Blocked, report.Cached = totals.Decisions, totals.Blocked, totals.Cached
+	if err := r.db.Raw(shadowReportSQL, args).Scan(&report.Rules).Error; err != nil {
+		return nil, customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return report, nil
+}
+
+func (r *AntifraudRepository) AddHold(h *antifraud.Hold) (bool, *customerrors.RepositoryError) {
+	if err := r.db.Create(h).Error; err != nil {
+		if isUniqueViolation(err, "idx_antifraud_holds_open") {
+			return false, nil
+		}
+		return false, customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return true, nil
+}
+
+func (r *AntifraudRepository) GetHold(id uuid.UUID, promo uuid.UUID) (*antifraud.Hold, *customerrors.RepositoryError) {
+	return r.findHold(r.db.Where("id = ? AND promo_code_id = ?", id, promo))
+}
+
+func (r *AntifraudRepository) GetLatestHold(user uuid.UUID, promo uuid.UUID) (
+	*antifraud.Hold,
+	*customerrors.RepositoryError,
+) {
+	return r.findHold(r.db.Where("user_id = ? AND promo_code_id = ?", user, promo).Order("created_at DESC"))
+}
+
+func (r *AntifraudRepository) findHold(query *gorm.DB) (*antifraud.Hold, *customerrors.RepositoryError) {
+	var h antifraud.Hold
+	if err := query.First(&h).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, customerrors.NotFoundInRepository()
+		}
+		return nil, customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return &h, nil
+}
+
+func (r *AntifraudRepository) GetHolds(promo uuid.UUID, status string, limit *int, offset int) (
+	[]*antifraud.Hold,
+	int,
+) {
+	query := r.db.Model(&antifraud.Hold{}).Where("promo_code_id = ?", promo)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var count int64
+	query.Count(&count)
+	if limit != nil {
+		query = query.Limit(*limit)
+	}
+	var holds []*antifraud.Hold
+	query.Order("created_at DESC").Offset(offset).Find(&holds)
+	return holds, int(count)
+}
+
+func (r *AntifraudRepository) SaveHold(h *antifraud.Hold, from string) (bool, *customerrors.RepositoryError) {
+	result := r.db.Model(&antifraud.Hold{}).
+		Where("id = ? AND status = ?", h.ID, from).
+		Select("status", "code", "note", "resolved_at").
+		Updates(h)
+	if result.Error != nil {
+		return false, customerrors.UnknownErrorInRepository(result.Error.Error())
+	}
+	return result.RowsAffected == 1, nil
+}
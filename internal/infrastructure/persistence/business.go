@@ -4,8 +4,11 @@ import (
 	"errors"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"solution/internal/domain/business"
 	"solution/internal/domain/errors"
+	"solution/internal/domain/promocode"
+	"time"
 )
 
 type BusinessRepository struct {
@@ -59,3 +62,96 @@ func (r *BusinessRepository) Get(id uuid.UUID) (*business.Business, *customerror
 	}
 	return &b, nil
 }
+
+// Save also renames the company on its promo codes, in the same transaction,
+// so the copy of the name they carry never disagrees with the profile.
+func (r *BusinessRepository) Save(b *business.Business) *customerrors.RepositoryError {
+	err := r.db.Transaction(
+		func(tx *gorm.DB) error {
+			if err := tx.Save(b).Error; err != nil {
+				return err
+			}
+			return tx.Model(&promocode.PromoCode{}).
+				Where("company_id = ? AND company_name <> ?", b.ID, b.CompanyName).
+				Update("company_name", b.CompanyName).Error
+		},
+	)
+	if err != nil {
+		return customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return nil
+}
+
+func (r *BusinessRepository) SetExportEmailConsent(change *business.ConsentChange) *customerrors.RepositoryError {
+	err := r.db.Transaction(
+		func(tx *gorm.DB) error {
+			result := tx.Model(&business.Business{}).
+				Where("id = ?", change.BusinessID).
+				Update("export_email_consent", change.Consent)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return gorm.ErrRecordNotFound
+			}
+			return tx.Create(change).Error
+		},
+	)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &customerrors.RepositoryError{
+			Code:    404,
+			Message: "business not found",
+		}
+	}
+	if err != nil {
+		return customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return nil
+}
+
+func (r *BusinessRepository) CountFollowers(id uuid.UUID) int {
+	var count int64
+	r.db.Model(&business.Follower{}).Where("business_id = ?", id).Count(&count)
+	return int(count)
+}
+
+// Follow does nothing for a user who already follows the company.
+func (r *BusinessRepository) Follow(id uuid.UUID, userID uuid.UUID) *customerrors.RepositoryError {
+	err := r.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&business.Follower{BusinessID: id, UserID: userID}).Error
+	if err != nil {
+		return customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return nil
+}
+
+func (r *BusinessRepository) Unfollow(id uuid.UUID, userID uuid.UUID) *customerrors.RepositoryError {
+	err := r.db.Where("business_id = ? AND user_id = ?", id, userID).Delete(&business.Follower{}).Error
+	if err != nil {
+		return customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return nil
+}
+
+func (r *BusinessRepository) IsFollowing(id uuid.UUID, userID uuid.UUID) bool {
+	var count int64
+	r.db.Model(&business.Follower{}).Where("business_id = ? AND user_id = ?", id, userID).Count(&count)
+	return count > 0
+}
+
+func (r *BusinessRepository) GetFollowedIDs(userID uuid.UUID) []uuid.UUID {
+	ids := make([]uuid.UUID, 0)
+	r.db.Model(&business.Follower{}).Where("user_id = ?", userID).Pluck("business_id", &ids)
+	return ids
+}
+
+func (r *BusinessRepository) GetFollowerGrowth(id uuid.UUID, since time.Time) []*business.DailyCount {
+	var growth []*business.DailyCount
+	r.db.Model(&business.Follower{}).
+		Select("date_trunc('day', created_at) AS day, COUNT(*) AS count").
+		Where("business_id = ? AND created_at >= ?", id, since).
+		Group("day").
+		Order("day").
+		Scan(&growth)
+	return growth
+}
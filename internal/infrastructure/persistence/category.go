@@ -0,0 +1,51 @@
+package persistence
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"solution/internal/domain/category"
+	customerrors "solution/internal/domain/errors"
+)
+
+type CategoryRepository struct {
+	db *gorm.DB
+}
+
+func NewCategoryRepository(db *gorm.DB) *CategoryRepository {
+	return &CategoryRepository{db: db}
+}
+
+func (r *CategoryRepository) GetAll() ([]*category.Category, *customerrors.RepositoryError) {
+	var categories []*category.Category
+	if err := r.db.Preload("Names").Order("slug").Find(&categories).Error; err != nil {
+		return nil, customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return categories, nil
+}
+
+func (r *CategoryRepository) Upsert(c *category.Category) *customerrors.RepositoryError {
+	err := r.db.Transaction(
+		func(tx *gorm.DB) error {
+			err := tx.Clauses(
+				clause.OnConflict{
+					Columns:   []clause.Column{{Name: "id"}},
+					DoUpdates: clause.AssignmentColumns([]string{"slug", "parent_id", "synonyms"}),
+				},
+			).Omit("Names").Create(c).Error
+			if err != nil {
+				return err
+			}
+			if err := tx.Where("category_id = ?", c.ID).Delete(&category.Name{}).Error; err != nil {
+				return err
+			}
+			if len(c.Names) == 0 {
+				return nil
+			}
+			return tx.Create(&c.Names).Error
+		},
+	)
+	if err != nil {
+		return customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return nil
+}
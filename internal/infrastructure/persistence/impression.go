@@ -0,0 +1,101 @@
+package persistence
+
+import (
+	"expvar"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"solution/internal/domain/promocode"
+	"sync"
+	"time"
+)
+
+var impressionStats = expvar.NewMap("impressions")
+
+// ImpressionWriter buffers impressions and inserts them in batches from a
+// background goroutine, so recording never waits for the database. When the
+// buffer is full new impressions are dropped and counted as such.
+type ImpressionWriter struct {
+	db        *gorm.DB
+	queue     chan *promocode.Impression
+	batchSize int
+	interval  time.Duration
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewImpressionWriter(db *gorm.DB, bufferSize, batchSize int, interval time.Duration) *ImpressionWriter {
+	w := &ImpressionWriter{
+		db:        db,
+		queue:     make(chan *promocode.Impression, bufferSize),
+		batchSize: batchSize,
+		interval:  interval,
+		done:      make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *ImpressionWriter) Record(impressions ...*promocode.Impression) {
+	for _, i := range impressions {
+		select {
+		case w.queue <- i:
+		default:
+			impressionStats.Add("dropped", 1)
+		}
+	}
+}
+
+// Close flushes buffered impressions and stops the writer. Record must not be
+// called after Close.
+func (w *ImpressionWriter) Close() {
+	w.closeOnce.Do(
+		func() {
+			close(w.queue)
+			<-w.done
+		},
+	)
+}
+
+func (w *ImpressionWriter) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	batch := make(map[promocode.Impression]*promocode.Impression, w.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		rows := make([]*promocode.Impression, 0, len(batch))
+		for _, i := range batch {
+			rows = append(rows, i)
+		}
+		err := w.db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(rows, w.batchSize).Error
+		if err != nil {
+			impressionStats.Add("failed", int64(len(rows)))
+			zap.S().Errorw("failed to write impressions", "count", len(rows), "error", err)
+		} else {
+			impressionStats.Add("written", int64(len(rows)))
+		}
+		clear(batch)
+	}
+
+	for {
+		select {
+		case i, ok := <-w.queue:
+			if !ok {
+				flush()
+				return
+			}
+			key := *i
+			key.CreatedAt = time.Time{}
+			batch[key] = i
+			if len(batch) >= w.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package persistence
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+)
+
+type migration struct {
+	name string
+	sql  string
+	// once migrations are not idempotent, or too costly to run on every
+	// start. They run a single time, recorded in schema_migrations.
+	once bool
+}
+
+// schemaMigrations run before AutoMigrate, for tables it couldn't migrate
+// itself. Each one is idempotent and runs on every start.
+var schemaMigrations = []migration{
+	{
+		// The first outbox had a promo_code_id NOT NULL column, which
+		// AutoMigrate never drops and outbox.Record doesn't write, and no
+		// aggregate_id, which AutoMigrate can't add as NOT NULL to a table
+		// with rows. Its comment events predate author_type and were all by
+		// users.
+		name: "generic outbox",
+		sql: `DO $$
+BEGIN
+	IF EXISTS (
+		SELECT 1 FROM information_schema.columns
+		WHERE table_name = 'outbox_events' AND column_name = 'promo_code_id'
+	) THEN
+		ALTER TABLE outbox_events ADD COLUMN IF NOT EXISTS aggregate_id uuid;
+		UPDATE outbox_events SET aggregate_id = promo_code_id WHERE aggregate_id IS NULL;
+		UPDATE outbox_events SET payload = payload || '{"author_type": "user"}'
+		WHERE type = 'promo.commented' AND payload->'author_type' IS NULL;
+		ALTER TABLE outbox_events DROP COLUMN promo_code_id;
+	END IF;
+END $$`,
+	},
+}
+
+// migrations fix up data that AutoMigrate can't, such as columns that
+// changed meaning. Each one is idempotent and runs on every start after
+// AutoMigrate.
+var migrations = []migration{
+	{
+		// Activations used to store the issued code in uses.country.
+		name: "uses code column",
+		sql: `UPDATE uses SET code = uses.country, country = u.country, country_lower = LOWER(u.country)
+			FROM users u
+			WHERE u.id = uses.user_id AND (uses.code IS NULL OR uses.code = '')`,
+	},
+	{
+		// A user has one open hold per promo code. Holds put on pending
+		// twice by concurrent activations before the index existed keep the
+		// newest one open.
+		name: "open antifraud holds",
+		sql: `DO $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM pg_indexes WHERE indexname = 'idx_antifraud_holds_open') THEN
+		UPDATE antifraud_holds h SET status = 'rejected', note = 'duplicate hold', resolved_at = now()
+		WHERE h.status = 'pending' AND EXISTS (
+			SELECT 1 FROM antifraud_holds n
+			WHERE n.user_id = h.user_id AND n.promo_code_id = h.promo_code_id AND n.status = 'pending'
+				AND (n.created_at, n.id) > (h.created_at, h.id)
+		);
+		CREATE UNIQUE INDEX idx_antifraud_holds_open ON antifraud_holds (user_id, promo_code_id)
+		WHERE status IN ('pending', 'activating');
+	END IF;
+END $$`,
+	},
+	{
+		// The counters were added with default 0 and kept up to date from
+		// then on; the likes, comments, uses and ratings before that are
+		// counted once.
+		name: "backfill promo counters",
+		sql:  `UPDATE promo_codes p SET ` + recountCounters,
+		once: true,
+	},
+}
+
+// MigrateSchema runs the migrations due before AutoMigrate.
+func MigrateSchema(db *gorm.DB) error {
+	return run(db, schemaMigrations)
+}
+
+// Migrate runs the migrations due after AutoMigrate.
+func Migrate(db *gorm.DB) error {
+	return run(db, migrations)
+}
+
+func run(db *gorm.DB, migrations []migration) error {
+	err := db.Exec(
+		`CREATE TABLE IF NOT EXISTS schema_migrations (
+			name text PRIMARY KEY,
+			applied_at timestamptz NOT NULL DEFAULT now()
+		)`,
+	).Error
+	if err != nil {
+		return fmt.Errorf("schema_migrations: %w", err)
+	}
+	for _, m := range migrations {
+		if m.once {
+			err = runOnce(db, m)
+		} else {
+			err = db.Exec(m.sql).Error
+		}
+		if err != nil {
+			return fmt.Errorf("migration %q: %w", m.name, err)
+		}
+	}
+	return nil
+}
+
+// runOnce applies m unless it is recorded as applied. The marker is written
+// in the same transaction, so an instance starting concurrently waits for it
+// and then skips m.
+func runOnce(db *gorm.DB, m migration) error {
+	return db.Transaction(
+		func(tx *gorm.DB) error {
+			res := tx.Exec(`INSERT INTO schema_migrations (name) VALUES (?) ON CONFLICT DO NOTHING`, m.name)
+			if res.Error != nil || res.RowsAffected == 0 {
+				return res.Error
+			}
+			return tx.Exec(m.sql).Error
+		},
+	)
+}
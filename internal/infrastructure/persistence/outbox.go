@@ -0,0 +1,57 @@
+package persistence
+
+import (
+	"gorm.io/gorm"
+	customerrors "solution/internal/domain/errors"
+	"solution/internal/domain/outbox"
+	"time"
+)
+
+type OutboxRepository struct {
+	db *gorm.DB
+}
+
+func NewOutboxRepository(db *gorm.DB) *OutboxRepository {
+	return &OutboxRepository{db: db}
+}
+
+const claimOutboxSQL = `
+UPDATE outbox_events SET next_attempt_at = @lease
+WHERE id IN (
+	SELECT id FROM outbox_events
+	WHERE processed_at IS NULL AND next_attempt_at <= @now
+	ORDER BY created_at
+	LIMIT @limit
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *
+`
+
+// ClaimDue locks the records it takes with SKIP LOCKED, so several instances
+// can dispatch at once without handing an event out twice.
+func (r *OutboxRepository) ClaimDue(now time.Time, lease time.Duration, limit int) []*outbox.Record {
+	var records []*outbox.Record
+	r.db.Raw(
+		claimOutboxSQL, map[string]interface{}{
+			"now":   now,
+			"lease": now.Add(lease),
+			"limit": limit,
+		},
+	).Scan(&records)
+	return records
+}
+
+func (r *OutboxRepository) Save(rec *outbox.Record) *customerrors.RepositoryError {
+	if err := r.db.Save(rec).Error; err != nil {
+		return customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return nil
+}
+
+func (r *OutboxRepository) Prune(before time.Time) (int64, *customerrors.RepositoryError) {
+	res := r.db.Where("processed_at < ?", before).Delete(&outbox.Record{})
+	if res.Error != nil {
+		return 0, customerrors.UnknownErrorInRepository(res.Error.Error())
+	}
+	return res.RowsAffected, nil
+}
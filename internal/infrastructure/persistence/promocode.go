@@ -3,11 +3,16 @@ package persistence
 import (
 	"errors"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"solution/internal/domain/business"
 	"solution/internal/domain/errors"
+	"solution/internal/domain/outbox"
 	"solution/internal/domain/promocode"
 	"solution/internal/domain/user"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -17,8 +22,14 @@ type PromoCodeRepository struct {
 }
 
 func (r *PromoCodeRepository) Create(p *promocode.PromoCode) error {
-	r.db.Create(p)
-	return nil
+	return r.db.Transaction(
+		func(tx *gorm.DB) error {
+			if err := tx.Create(p).Error; err != nil {
+				return err
+			}
+			return addEvents(tx, p.CreatedEvent())
+		},
+	)
 }
 
 func (r *PromoCodeRepository) Get(id uuid.UUID) (*promocode.PromoCode, *customerrors.RepositoryError) {
@@ -34,6 +45,15 @@ func (r *PromoCodeRepository) Get(id uuid.UUID) (*promocode.PromoCode, *customer
 	return found, nil
 }
 
+func (r *PromoCodeRepository) GetMany(ids []uuid.UUID) []*promocode.PromoCode {
+	var promos []*promocode.PromoCode
+	if len(ids) == 0 {
+		return promos
+	}
+	r.db.Model(&promocode.PromoCode{}).Where("id IN ?", ids).Find(&promos)
+	return promos
+}
+
 func (r *PromoCodeRepository) GetByCompanyIDAsCompanyList(
 	id uuid.UUID,
 	params *promocode.GetAsCompanyListParams,
@@ -64,11 +84,28 @@ func (r *PromoCodeRepository) GetByCompanyIDAsCompanyList(
 	return promos, int(count)
 }
 
+// GetCompanyLastModified counts deleted promo codes too, so a client still
+// holding one doesn't get 304.
+func (r *PromoCodeRepository) GetCompanyLastModified(id uuid.UUID) time.Time {
+	var lastModified *time.Time
+	r.db.Unscoped().Model(&promocode.PromoCode{}).
+		Where("company_id = ?", id).
+		Select("MAX(GREATEST(updated_at, deleted_at))").
+		Scan(&lastModified)
+	if lastModified == nil {
+		return time.Time{}
+	}
+	return *lastModified
+}
+
 func (r *PromoCodeRepository) GetAsUserFeed(params *promocode.GetAsUserFeedParams) ([]*promocode.PromoCode, int) {
 	query := r.db.Model(&promocode.PromoCode{})
 	var count int64
-	if params.Category != "" {
-		query = query.Where("? = ANY(target_categories_lower)", strings.ToLower(params.Category))
+	if params.CompanyIDs != nil {
+		query = query.Where("company_id IN ?", params.CompanyIDs)
+	}
+	if len(params.Categories) > 0 {
+		query = query.Where("target_categories_lower && ?", pq.StringArray(params.Categories))
 	}
 	query.Count(&count)
 	zap.S().Debugw("after categories", "count", count)
@@ -114,7 +151,22 @@ active_from > ? OR
 	query.Count(&count)
 	zap.S().Debugw("after active", "count", count)
 	query.Count(&count)
-	query.Order("created_at DESC")
+	order := "created_at DESC"
+	if len(params.BoostCompanyIDs) > 0 {
+		order = "company_id IN ? DESC, " + order
+	}
+	if params.SortBy == promocode.SortByRating {
+		order = averageRatingOrder + ", " + order
+	}
+	var vars []interface{}
+	if len(params.BoostCompanyIDs) > 0 {
+		vars = append(vars, params.BoostCompanyIDs)
+	}
+	query = query.Order(
+		clause.OrderBy{
+			Expression: clause.Expr{SQL: order, Vars: vars, WithoutParentheses: true},
+		},
+	)
 	if params.Limit != nil {
 		query = query.Limit(*params.Limit)
 	}
@@ -125,6 +177,50 @@ active_from > ? OR
 	return promoCodes, int(count)
 }
 
+// averageRatingOrder puts unrated promo codes last.
+const averageRatingOrder = `(rating_1 + 2 * rating_2 + 3 * rating_3 + 4 * rating_4 + 5 * rating_5)::float
+	/ NULLIF(rating_1 + rating_2 + rating_3 + rating_4 + rating_5, 0) DESC NULLS LAST`
+
+func (r *PromoCodeRepository) GetCompanyLikesCount(id uuid.UUID) int {
+	var count int64
+	r.db.Model(&promocode.PromoCode{}).
+		Where("company_id = ?", id).
+		Select("COALESCE(SUM(like_count), 0)").
+		Scan(&count)
+	return int(count)
+}
+
+func (r *PromoCodeRepository) CountByCategories(subtrees map[string][]string) map[string]int {
+	counts := make(map[string]int, len(subtrees))
+	if len(subtrees) == 0 {
+		return counts
+	}
+	var roots, members pq.StringArray
+	for root, slugs := range subtrees {
+		for _, slug := range slugs {
+			roots = append(roots, root)
+			members = append(members, slug)
+		}
+	}
+	var rows []struct {
+		Root  string
+		Count int
+	}
+	r.db.Raw(
+		`
+SELECT m.root, COUNT(DISTINCT p.id) AS count
+FROM promo_codes p
+JOIN unnest(?::text[], ?::text[]) AS m(root, slug) ON m.slug = ANY(p.target_categories_lower)
+WHERE p.deleted_at IS NULL
+GROUP BY m.root
+`, roots, members,
+	).Scan(&rows)
+	for _, row := range rows {
+		counts[row.Root] = row.Count
+	}
+	return counts
+}
+
 func (r *PromoCodeRepository) Delete(id uuid.UUID) *customerrors.RepositoryError {
 	err := r.db.Model(&promocode.PromoCode{}).Where("id = ?", id).Delete(&promocode.PromoCode{}).Error
 	if err != nil {
@@ -156,33 +252,238 @@ func (r *PromoCodeRepository) GetCommentsCount(promoCodeID uuid.UUID) int {
 	return int(count)
 }
 
-func (r *PromoCodeRepository) Save(p *promocode.PromoCode) {
-	r.db.Save(p)
+// Save never writes the counter columns: they are only changed by the
+// statements that insert or delete the counted rows.
+// Save writes events along with the promo code, and raises promo.exhausted
+// when an edit lowers the limit of a COMMON promo code to its use count and
+// promo.expired when it moves the end date into the past. Unique codes only
+// run out on activation.
+func (r *PromoCodeRepository) Save(p *promocode.PromoCode, events ...outbox.Event) {
+	err := r.db.Transaction(
+		func(tx *gorm.DB) error {
+			if err := tx.Omit(counterColumns...).Save(p).Error; err != nil {
+				return err
+			}
+			return addEvents(tx, append(events, p.StateEvents(time.Now())...)...)
+		},
+	)
+	if err != nil {
+		zap.S().Errorw("failed to save promo code", "promo", p.ID, "error", err)
+	}
 }
 
-func (r *PromoCodeRepository) GetUsageStatistics(promoCodeID uuid.UUID) map[string]interface{} {
-	var uses []promocode.Use
-	var stats = make(map[string]interface{})
-	var countryCounts = make(map[string]int)
+// addEvents writes events to the outbox within tx, skipping those whose key
+// was seen before.
+func addEvents(tx *gorm.DB, events ...outbox.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	records := make([]*outbox.Record, 0, len(events))
+	for _, e := range events {
+		records = append(records, outbox.NewRecord(e))
+	}
+	return tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "key"}}, DoNothing: true}).
+		Create(&records).Error
+}
 
-	result := r.db.Where("promo_code_id = ?", promoCodeID).Find(&uses)
-	totalActivations := int(result.RowsAffected)
-	stats["activations_count"] = totalActivations
+func (r *PromoCodeRepository) AddEvents(events ...outbox.Event) *customerrors.RepositoryError {
+	if err := addEvents(r.db, events...); err != nil {
+		return customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return nil
+}
+
+func (r *PromoCodeRepository) GetExpiredSince(since time.Time) []*promocode.PromoCode {
+	var promos []*promocode.PromoCode
+	today := time.Now().UTC().Format(time.DateOnly)
+	r.db.Where(
+		"active_until <> '0001-01-01' AND active_until >= ? AND active_until < ?",
+		since.UTC().Format(time.DateOnly), today,
+	).Find(&promos)
+	return promos
+}
 
-	for _, use := range uses {
-		countryCounts[use.CountryLower]++
+var counterColumns = []string{
+	"like_count", "comment_count", "used_count",
+	"rating_1", "rating_2", "rating_3", "rating_4", "rating_5",
+}
+
+func ratingColumn(stars int) string {
+	return "rating_" + strconv.Itoa(stars)
+}
+
+func (r *PromoCodeRepository) addToCounter(tx *gorm.DB, id uuid.UUID, column string, delta int) error {
+	return tx.Model(&promocode.PromoCode{}).
+		Where("id = ?", id).
+		UpdateColumns(
+			map[string]interface{}{
+				column:       gorm.Expr(column+" + ?", delta),
+				"updated_at": time.Now(),
+			},
+		).Error
+}
+
+// usageSeriesSQL counts activations, likes and comments per bucket of the
+// window; buckets are in UTC and empty ones are included. Likes are counted by
+// the time they were given, withdrawn likes are gone.
+const usageSeriesSQL = `
+WITH buckets AS (
+	SELECT generate_series(
+		date_trunc(@granularity, @from::timestamptz AT TIME ZONE 'UTC'),
+		date_trunc(@granularity, @to::timestamptz AT TIME ZONE 'UTC'),
+		('1 ' || @granularity)::interval
+	) AS bucket
+), activations AS (
+	SELECT date_trunc(@granularity, created_at AT TIME ZONE 'UTC') AS bucket, COUNT(*) AS n
+	FROM uses
+	WHERE promo_code_id = @id AND created_at >= @from AND created_at < @to
+	GROUP BY 1
+), likes AS (
+	SELECT date_trunc(@granularity, created_at AT TIME ZONE 'UTC') AS bucket, COUNT(*) AS n
+	FROM likes
+	WHERE promo_code_id = @id AND created_at >= @from AND created_at < @to
+	GROUP BY 1
+), comments AS (
+	SELECT date_trunc(@granularity, created_at AT TIME ZONE 'UTC') AS bucket, COUNT(*) AS n
+	FROM comments
+	WHERE promo_code_id = @id AND deleted_at IS NULL AND created_at >= @from AND created_at < @to
+	GROUP BY 1
+)
+SELECT b.bucket,
+	COALESCE(a.n, 0) AS activations,
+	COALESCE(l.n, 0) AS likes,
+	COALESCE(c.n, 0) AS comments
+FROM buckets b
+LEFT JOIN activations a USING (bucket)
+LEFT JOIN likes l USING (bucket)
+LEFT JOIN comments c USING (bucket)
+ORDER BY b.bucket
+`
+
+// ageBandExpr is the age band of the user u, "unknown" if there is no such
+// user any more.
+const ageBandExpr = `CASE
+		WHEN u.age IS NULL THEN 'unknown'
+		WHEN u.age < 18 THEN '0-17'
+		WHEN u.age < 25 THEN '18-24'
+		WHEN u.age < 35 THEN '25-34'
+		WHEN u.age < 45 THEN '35-44'
+		WHEN u.age < 55 THEN '45-54'
+		ELSE '55+'
+	END`
+
+// ageBandSQL groups activations of the window by the current age of the user;
+// activations whose user is gone count as unknown.
+const ageBandSQL = `
+SELECT ` + ageBandExpr + ` AS age_band,
+	COUNT(*) AS activations
+FROM uses s
+LEFT JOIN users u ON u.id = s.user_id
+WHERE s.promo_code_id = @id AND s.created_at >= @from AND s.created_at < @to
+GROUP BY 1
+ORDER BY 1
+`
+
+// GetUsageStatistics keeps activations_count and countries as all-time
+// totals; everything under window, series and the by_* breakdowns is limited
+// to the window.
+func (r *PromoCodeRepository) GetUsageStatistics(
+	promoCodeID uuid.UUID,
+	params *promocode.StatsParams,
+) map[string]interface{} {
+	args := map[string]interface{}{
+		"id":          promoCodeID,
+		"from":        params.From.UTC(),
+		"to":          params.To.UTC(),
+		"granularity": params.Granularity,
+	}
+	stats := make(map[string]interface{})
+
+	var total int64
+	r.db.Model(&promocode.Use{}).Where("promo_code_id = ?", promoCodeID).Count(&total)
+	stats["activations_count"] = int(total)
+	stats["countries"] = r.activationsByCountry(r.db.Where("promo_code_id = ?", promoCodeID))
+
+	var series []struct {
+		Bucket      time.Time
+		Activations int
+		Likes       int
+		Comments    int
+	}
+	if err := r.db.Raw(usageSeriesSQL, args).Scan(&series).Error; err != nil {
+		zap.S().Errorw("usage series", "promo", promoCodeID, "error", err)
+	}
+	var activations, likes, comments int
+	buckets := make([]map[string]interface{}, 0, len(series))
+	for _, b := range series {
+		activations += b.Activations
+		likes += b.Likes
+		comments += b.Comments
+		buckets = append(
+			buckets, map[string]interface{}{
+				"bucket":            b.Bucket.UTC().Format(time.RFC3339),
+				"activations_count": b.Activations,
+				"likes_count":       b.Likes,
+				"comments_count":    b.Comments,
+			},
+		)
+	}
+	stats["window"] = map[string]interface{}{
+		"from":              params.From.UTC().Format(time.RFC3339),
+		"to":                params.To.UTC().Format(time.RFC3339),
+		"granularity":       params.Granularity,
+		"activations_count": activations,
+		"likes_count":       likes,
+		"comments_count":    comments,
+	}
+	stats["series"] = buckets
+	stats["by_country"] = r.activationsByCountry(
+		r.db.Where(
+			"promo_code_id = ? AND created_at >= ? AND created_at < ?",
+			promoCodeID, params.From, params.To,
+		),
+	)
+
+	var ageRows []struct {
+		AgeBand     string
+		Activations int
+	}
+	if err := r.db.Raw(ageBandSQL, args).Scan(&ageRows).Error; err != nil {
+		zap.S().Errorw("usage age bands", "promo", promoCodeID, "error", err)
+	}
+	var ageBands []map[string]interface{}
+	for _, row := range ageRows {
+		ageBands = append(
+			ageBands, map[string]interface{}{
+				"age_band":          row.AgeBand,
+				"activations_count": row.Activations,
+			},
+		)
+	}
+	stats["by_age_band"] = ageBands
+	return stats
+}
+
+func (r *PromoCodeRepository) activationsByCountry(scope *gorm.DB) []map[string]interface{} {
+	var rows []struct {
+		Country     string
+		Activations int
 	}
+	scope.Model(&promocode.Use{}).
+		Select("country_lower AS country, COUNT(*) AS activations").
+		Group("country_lower").
+		Order("activations DESC, country_lower").
+		Scan(&rows)
 	var countries []map[string]interface{}
-	for country, count := range countryCounts {
+	for _, row := range rows {
 		countries = append(
 			countries, map[string]interface{}{
-				"country":           country,
-				"activations_count": count,
+				"country":           row.Country,
+				"activations_count": row.Activations,
 			},
 		)
 	}
-	stats["countries"] = countries
-	return stats
+	return countries
 }
 
 func (r *PromoCodeRepository) IsLiked(promoCodeID uuid.UUID, userID uuid.UUID) bool {
@@ -197,34 +498,167 @@ func (r *PromoCodeRepository) IsActivated(promoCodeID uuid.UUID, userID uuid.UUI
 	return count > 0
 }
 
+func (r *PromoCodeRepository) IsLikedMany(ids []uuid.UUID, userID uuid.UUID) map[uuid.UUID]bool {
+	return r.promosOfUser(&promocode.Like{}, ids, userID)
+}
+
+func (r *PromoCodeRepository) IsActivatedMany(ids []uuid.UUID, userID uuid.UUID) map[uuid.UUID]bool {
+	return r.promosOfUser(&promocode.Use{}, ids, userID)
+}
+
+func (r *PromoCodeRepository) IsSaved(promoCodeID uuid.UUID, userID uuid.UUID) bool {
+	var count int64
+	r.db.Model(&promocode.SavedPromo{}).Where("promo_code_id = ?", promoCodeID).Where("user_id = ?", userID).Count(&count)
+	return count > 0
+}
+
+func (r *PromoCodeRepository) IsSavedMany(ids []uuid.UUID, userID uuid.UUID) map[uuid.UUID]bool {
+	return r.promosOfUser(&promocode.SavedPromo{}, ids, userID)
+}
+
+func (r *PromoCodeRepository) promosOfUser(model interface{}, ids []uuid.UUID, userID uuid.UUID) map[uuid.UUID]bool {
+	result := make(map[uuid.UUID]bool, len(ids))
+	if len(ids) == 0 {
+		return result
+	}
+	var found []uuid.UUID
+	r.db.Model(model).
+		Distinct("promo_code_id").
+		Where("promo_code_id IN ?", ids).
+		Where("user_id = ?", userID).
+		Pluck("promo_code_id", &found)
+	for _, id := range found {
+		result[id] = true
+	}
+	return result
+}
+
 func (r *PromoCodeRepository) Like(id uuid.UUID, sub uuid.UUID) *customerrors.RepositoryError {
-	result := r.db.Create(&promocode.Like{PromoCodeID: id, UserID: sub})
-	if result.Error != nil {
+	err := r.db.Transaction(
+		func(tx *gorm.DB) error {
+			if err := tx.Create(&promocode.Like{PromoCodeID: id, UserID: sub}).Error; err != nil {
+				return err
+			}
+			if err := r.addToCounter(tx, id, "like_count", 1); err != nil {
+				return err
+			}
+			var p promocode.PromoCode
+			if err := tx.Select("id", "company_id").First(&p, "id = ?", id).Error; err != nil {
+				return err
+			}
+			return addEvents(tx, p.LikedEvent(sub))
+		},
+	)
+	if isUniqueViolation(err, "likes_pkey") {
 		return &customerrors.RepositoryError{
 			Code:        409,
 			Message:     "already exists",
-			DebugDetail: "",
+			DebugDetail: err.Error(),
 		}
 	}
+	if err != nil {
+		return customerrors.UnknownErrorInRepository(err.Error())
+	}
 	return nil
 }
 func (r *PromoCodeRepository) Unlike(id uuid.UUID, sub uuid.UUID) *customerrors.RepositoryError {
-	result := r.db.Where("promo_code_id = ?", id).Where("user_id = ?", sub).Delete(&promocode.Like{})
-	if result.Error != nil {
+	err := r.db.Transaction(
+		func(tx *gorm.DB) error {
+			result := tx.Where("promo_code_id = ?", id).Where("user_id = ?", sub).Delete(&promocode.Like{})
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			return r.addToCounter(tx, id, "like_count", -1)
+		},
+	)
+	if err != nil {
 		return customerrors.NotFoundInRepository()
 	}
 	return nil
 }
 
-func (r *PromoCodeRepository) Comment(id uuid.UUID, sub uuid.UUID, comment string) *promocode.Comment {
-	c := &promocode.Comment{
-		ID:          uuid.New(),
-		PromoCodeID: id,
-		UserID:      sub,
-		Content:     comment,
+// AddToWallet does nothing for a promo code already in the wallet.
+func (r *PromoCodeRepository) AddToWallet(id uuid.UUID, sub uuid.UUID) *customerrors.RepositoryError {
+	err := r.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&promocode.SavedPromo{PromoCodeID: id, UserID: sub}).Error
+	if err != nil {
+		return customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return nil
+}
+
+func (r *PromoCodeRepository) RemoveFromWallet(id uuid.UUID, sub uuid.UUID) *customerrors.RepositoryError {
+	err := r.db.Where("promo_code_id = ?", id).Where("user_id = ?", sub).Delete(&promocode.SavedPromo{}).Error
+	if err != nil {
+		return customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return nil
+}
+
+func (r *PromoCodeRepository) GetWallet(sub uuid.UUID, limit *int, offset int) ([]*promocode.SavedPromo, int) {
+	var saved []*promocode.SavedPromo
+	var count int64
+	query := r.db.Model(&promocode.SavedPromo{}).
+		Joins("JOIN promo_codes p ON p.id = saved_promos.promo_code_id AND p.deleted_at IS NULL").
+		Where("saved_promos.user_id = ?", sub)
+	query.Count(&count)
+	query = query.Select("saved_promos.*").Order("saved_promos.created_at DESC")
+	if limit != nil {
+		query = query.Limit(*limit)
+	}
+	query.Offset(offset).Find(&saved)
+	return saved, int(count)
+}
+
+func (r *PromoCodeRepository) GetExpiringWallet(sub uuid.UUID, until time.Time) []*promocode.SavedPromo {
+	var saved []*promocode.SavedPromo
+	r.db.Model(&promocode.SavedPromo{}).
+		Select("saved_promos.*").
+		Joins("JOIN promo_codes p ON p.id = saved_promos.promo_code_id AND p.deleted_at IS NULL").
+		Where("saved_promos.user_id = ?", sub).
+		Where("p.active_until >= CURRENT_DATE AND p.active_until <= ?", until).
+		Order("p.active_until ASC").
+		Find(&saved)
+	return saved
+}
+
+func (r *PromoCodeRepository) Comment(c *promocode.Comment) *customerrors.RepositoryError {
+	err := r.db.Transaction(func(tx *gorm.DB) error { return r.addComment(tx, c) })
+	if err != nil {
+		zap.S().Errorw("failed to create comment", "promo", c.PromoCodeID, "error", err)
+		return customerrors.UnknownErrorInRepository(err.Error())
 	}
-	r.db.Model(&promocode.Comment{}).Create(c)
-	return c
+	return nil
+}
+
+// addComment writes the comment with its counter and event within tx.
+func (r *PromoCodeRepository) addComment(tx *gorm.DB, c *promocode.Comment) error {
+	if err := tx.Model(&promocode.Comment{}).Create(c).Error; err != nil {
+		return err
+	}
+	if err := r.addToCounter(tx, c.PromoCodeID, "comment_count", 1); err != nil {
+		return err
+	}
+	var p promocode.PromoCode
+	if err := tx.First(&p, "id = ?", c.PromoCodeID).Error; err != nil {
+		return err
+	}
+	return addEvents(tx, p.CommentedEvent(c))
+}
+
+func (r *PromoCodeRepository) FindComment(comment uuid.UUID, promo uuid.UUID) (
+	*promocode.Comment,
+	*customerrors.RepositoryError,
+) {
+	var c promocode.Comment
+	result := r.db.First(&c, "id = ? AND promo_code_id = ?", comment, promo)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, customerrors.NotFoundInRepository()
+		}
+		return nil, customerrors.UnknownErrorInRepository(result.Error.Error())
+	}
+	return &c, nil
 }
 
 func (r *PromoCodeRepository) EditComment(
@@ -232,22 +666,168 @@ func (r *PromoCodeRepository) EditComment(
 	promo uuid.UUID,
 	commentText string,
 ) *customerrors.RepositoryError {
-	err := r.db.Model(&promocode.Comment{}).Where("id = ? AND promo_code_id = ?", comment, promo).Update(
-		"content",
-		commentText,
+	err := r.db.Transaction(func(tx *gorm.DB) error { return editComment(tx, comment, promo, commentText) })
+	if err != nil {
+		return customerrors.NotFoundInRepository()
+	}
+	return nil
+}
+
+// editComment replaces the text of a live comment within tx, keeping the old
+// one as an edit.
+func editComment(tx *gorm.DB, comment uuid.UUID, promo uuid.UUID, commentText string) error {
+	var c promocode.Comment
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&c, "id = ? AND promo_code_id = ? AND deleted_at IS NULL", comment, promo).Error
+	if err != nil {
+		return err
+	}
+	if c.Content == commentText {
+		return nil
+	}
+	now := time.Now()
+	edit := &promocode.CommentEdit{ID: uuid.New(), CommentID: c.ID, Content: c.Content, CreatedAt: now}
+	if err := tx.Create(edit).Error; err != nil {
+		return err
+	}
+	return tx.Model(&c).UpdateColumns(
+		map[string]interface{}{
+			"content":   commentText,
+			"edited_at": now,
+		},
 	).Error
+}
+
+func (r *PromoCodeRepository) GetCommentEdits(comment uuid.UUID) []*promocode.CommentEdit {
+	var edits []*promocode.CommentEdit
+	r.db.Where("comment_id = ?", comment).Order("created_at ASC").Find(&edits)
+	return edits
+}
+
+func (r *PromoCodeRepository) React(reaction *promocode.CommentReaction) *customerrors.RepositoryError {
+	err := r.db.Transaction(
+		func(tx *gorm.DB) error {
+			if err := tx.Create(reaction).Error; err != nil {
+				return err
+			}
+			return tx.Model(&promocode.Comment{}).
+				Where("id = ?", reaction.CommentID).
+				UpdateColumn("reaction_count", gorm.Expr("reaction_count + 1")).Error
+		},
+	)
+	if isUniqueViolation(err, "comment_reactions_pkey") {
+		return &customerrors.RepositoryError{
+			Code:        409,
+			Message:     "already exists",
+			DebugDetail: err.Error(),
+		}
+	}
+	if err != nil {
+		return customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return nil
+}
+
+func (r *PromoCodeRepository) Unreact(reaction *promocode.CommentReaction) *customerrors.RepositoryError {
+	err := r.db.Transaction(
+		func(tx *gorm.DB) error {
+			result := tx.Where(
+				"comment_id = ? AND user_id = ? AND reaction = ?",
+				reaction.CommentID, reaction.UserID, reaction.Reaction,
+			).Delete(&promocode.CommentReaction{})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return gorm.ErrRecordNotFound
+			}
+			return tx.Model(&promocode.Comment{}).
+				Where("id = ?", reaction.CommentID).
+				UpdateColumn("reaction_count", gorm.Expr("reaction_count - 1")).Error
+		},
+	)
 	if err != nil {
 		return customerrors.NotFoundInRepository()
 	}
 	return nil
 }
 
+func (r *PromoCodeRepository) GetReactions(
+	comments []uuid.UUID,
+	viewer uuid.UUID,
+) map[uuid.UUID][]promocode.ReactionCount {
+	result := make(map[uuid.UUID][]promocode.ReactionCount, len(comments))
+	if len(comments) == 0 {
+		return result
+	}
+	var rows []struct {
+		CommentID uuid.UUID
+		Reaction  string
+		Count     int
+		Mine      bool
+	}
+	r.db.Model(&promocode.CommentReaction{}).
+		Select("comment_id, reaction, COUNT(*) AS count, BOOL_OR(user_id = ?) AS mine", viewer).
+		Where("comment_id IN ?", comments).
+		Group("comment_id, reaction").
+		Scan(&rows)
+	for _, row := range rows {
+		result[row.CommentID] = append(
+			result[row.CommentID], promocode.ReactionCount{
+				Key:   row.Reaction,
+				Count: row.Count,
+				Mine:  row.Mine,
+			},
+		)
+	}
+	return result
+}
+
+// DeleteComment removes a comment without replies. A comment with replies is
+// turned into a tombstone so the thread stays intact; tombstones left without
+// replies are removed up the thread.
 func (r *PromoCodeRepository) DeleteComment(comment uuid.UUID, promo uuid.UUID) *customerrors.RepositoryError {
-	err := r.db.Model(&promocode.Comment{}).Where(
-		"id = ? AND promo_code_id = ?",
-		comment,
-		promo,
-	).Delete(&promocode.Comment{}).Error
+	err := r.db.Transaction(
+		func(tx *gorm.DB) error {
+			var c promocode.Comment
+			err := tx.First(&c, "id = ? AND promo_code_id = ? AND deleted_at IS NULL", comment, promo).Error
+			if err != nil {
+				return err
+			}
+			var replies int64
+			tx.Model(&promocode.Comment{}).Where("parent_id = ?", c.ID).Count(&replies)
+			if replies > 0 {
+				err = tx.Model(&c).UpdateColumns(
+					map[string]interface{}{
+						"content":    "",
+						"deleted_at": time.Now(),
+					},
+				).Error
+				if err != nil {
+					return err
+				}
+				return r.addToCounter(tx, promo, "comment_count", -1)
+			}
+			if err := tx.Delete(&c).Error; err != nil {
+				return err
+			}
+			for parentID := c.ParentID; parentID != nil; {
+				var parent promocode.Comment
+				if err := tx.First(&parent, "id = ?", *parentID).Error; err != nil || !parent.Deleted() {
+					break
+				}
+				tx.Model(&promocode.Comment{}).Where("parent_id = ?", parent.ID).Count(&replies)
+				if replies > 0 {
+					break
+				}
+				if err := tx.Delete(&parent).Error; err != nil {
+					return err
+				}
+				parentID = parent.ParentID
+			}
+			return r.addToCounter(tx, promo, "comment_count", -1)
+		},
+	)
 	if err != nil {
 		return customerrors.NotFoundInRepository()
 	}
@@ -259,9 +839,8 @@ func (r *PromoCodeRepository) GetComment(commentID uuid.UUID, promo uuid.UUID) (
 	*customerrors.RepositoryError,
 ) {
 	var comment promocode.Comment
-	var u user.User
 
-	result := r.db.First(&comment, "id = ? AND promo_code_id = ?", commentID, promo)
+	result := r.db.First(&comment, "id = ? AND promo_code_id = ? AND deleted_at IS NULL", commentID, promo)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			return nil, customerrors.NotFoundInRepository()
@@ -269,68 +848,734 @@ func (r *PromoCodeRepository) GetComment(commentID uuid.UUID, promo uuid.UUID) (
 		return nil, customerrors.UnknownErrorInRepository(result.Error.Error())
 	}
 
-	userResult := r.db.First(&u, comment.UserID)
-	if userResult.Error != nil {
-		return nil, customerrors.UnknownErrorInRepository(userResult.Error.Error())
-	}
-
-	return &promocode.CommentView{
-		Id:   comment.ID,
-		Text: comment.Content,
-		Date: comment.CreatedAt.Format(time.RFC3339),
-		Author: struct {
-			Id        uuid.UUID `json:"id"`
-			Name      string    `json:"name"`
-			Surname   string    `json:"surname"`
-			AvatarUrl *string   `json:"avatar_url"`
-		}{
-			Id:        u.ID,
-			Name:      u.Name,
-			Surname:   u.Surname,
-			AvatarUrl: u.AvatarURL,
+	authors := r.commentAuthors([]promocode.Comment{comment})
+	author, ok := authors[comment.UserID]
+	if !ok {
+		return nil, customerrors.UnknownErrorInRepository("comment author not found")
+	}
+	return comment.ToView(author), nil
+}
+
+func (r *PromoCodeRepository) GetComments(promoID uuid.UUID, limit *int, offset int, sort string) (
+	[]*promocode.CommentView,
+	int,
+) {
+	var roots []promocode.Comment
+	var count int64
+
+	query := r.db.Model(&promocode.Comment{}).Where("promo_code_id = ? AND parent_id IS NULL", promoID)
+	query.Count(&count)
+	if sort == promocode.CommentSortMostReacted {
+		query = query.Order("reaction_count DESC")
+	}
+	query = query.Order("created_at DESC")
+	if limit != nil {
+		query = query.Limit(*limit)
+	}
+	query.Offset(offset).Find(&roots)
+
+	comments := roots
+	if len(roots) > 0 {
+		rootIDs := make([]uuid.UUID, 0, len(roots))
+		for _, c := range roots {
+			rootIDs = append(rootIDs, c.ID)
+		}
+		var replies []promocode.Comment
+		r.db.Where("root_id IN ?", rootIDs).Order("depth ASC, created_at ASC").Find(&replies)
+		comments = append(comments, replies...)
+	}
+
+	authors := r.commentAuthors(comments)
+	var commentViews []*promocode.CommentView
+	for _, comment := range comments {
+		author, ok := authors[comment.UserID]
+		if !ok && !comment.Deleted() {
+			continue
+		}
+		commentViews = append(commentViews, comment.ToView(author))
+	}
+
+	return commentViews, int(count)
+}
+
+// Rate creates or replaces the user's rating and moves the rating counters in
+// the same transaction. The comment, if any, is written in it too: a rating
+// that already has a live comment gets it edited, otherwise comment is added
+// and linked. The insert is an upsert, so concurrent first ratings of the same
+// user end up as one.
+func (r *PromoCodeRepository) Rate(rating *promocode.Rating, comment *promocode.Comment) *customerrors.RepositoryError {
+	err := r.db.Transaction(
+		func(tx *gorm.DB) error {
+			stars, commentID := rating.Stars, rating.CommentID
+			inserted := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(rating)
+			if inserted.Error != nil {
+				return inserted.Error
+			}
+			old := &promocode.Rating{}
+			if inserted.RowsAffected == 1 {
+				if err := r.addToCounter(tx, rating.PromoCodeID, ratingColumn(stars), 1); err != nil {
+					return err
+				}
+				old = nil
+			} else {
+				err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+					First(old, "promo_code_id = ? AND user_id = ?", rating.PromoCodeID, rating.UserID).Error
+				if err != nil {
+					return err
+				}
+				if commentID == nil {
+					commentID = old.CommentID
+				}
+			}
+
+			if comment != nil {
+				edited := false
+				if old != nil && old.CommentID != nil {
+					err := editComment(tx, *old.CommentID, rating.PromoCodeID, comment.Content)
+					if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+						return err
+					}
+					edited = err == nil
+				}
+				if edited {
+					comment.ID = *old.CommentID
+				} else if err := r.addComment(tx, comment); err != nil {
+					return err
+				}
+				commentID = &comment.ID
+			}
+
+			rating.Stars, rating.CommentID = stars, commentID
+			err := tx.Model(&promocode.Rating{}).
+				Where("promo_code_id = ? AND user_id = ?", rating.PromoCodeID, rating.UserID).
+				Updates(map[string]interface{}{"stars": stars, "comment_id": commentID}).Error
+			if err != nil || old == nil || old.Stars == stars {
+				return err
+			}
+			if err := r.addToCounter(tx, rating.PromoCodeID, ratingColumn(old.Stars), -1); err != nil {
+				return err
+			}
+			return r.addToCounter(tx, rating.PromoCodeID, ratingColumn(stars), 1)
+		},
+	)
+	if err != nil {
+		return customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return nil
+}
+
+func (r *PromoCodeRepository) Unrate(id uuid.UUID, sub uuid.UUID) *customerrors.RepositoryError {
+	err := r.db.Transaction(
+		func(tx *gorm.DB) error {
+			var old promocode.Rating
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				First(&old, "promo_code_id = ? AND user_id = ?", id, sub).Error
+			if err != nil {
+				return err
+			}
+			if err := tx.Delete(&old).Error; err != nil {
+				return err
+			}
+			return r.addToCounter(tx, id, ratingColumn(old.Stars), -1)
 		},
-	}, nil
+	)
+	if err != nil {
+		return customerrors.NotFoundInRepository()
+	}
+	return nil
+}
+
+func (r *PromoCodeRepository) GetRating(id uuid.UUID, sub uuid.UUID) *promocode.Rating {
+	var rating promocode.Rating
+	if err := r.db.First(&rating, "promo_code_id = ? AND user_id = ?", id, sub).Error; err != nil {
+		return nil
+	}
+	return &rating
 }
 
-func (r *PromoCodeRepository) GetComments(promoID uuid.UUID) []*promocode.CommentView {
+// ReportComment stores the report and bumps report_count; the comment is hidden
+// in the same transaction once the count reaches threshold.
+func (r *PromoCodeRepository) ReportComment(
+	report *promocode.CommentReport,
+	threshold int,
+) *customerrors.RepositoryError {
+	err := r.db.Transaction(
+		func(tx *gorm.DB) error {
+			if err := tx.Create(report).Error; err != nil {
+				return err
+			}
+			return tx.Model(&promocode.Comment{}).
+				Where("id = ?", report.CommentID).
+				UpdateColumns(
+					map[string]interface{}{
+						"report_count": gorm.Expr("report_count + 1"),
+						"hidden_at": gorm.Expr(
+							"CASE WHEN hidden_at IS NULL AND report_count + 1 >= ? THEN ? ELSE hidden_at END",
+							threshold, time.Now(),
+						),
+						"hidden_by": gorm.Expr(
+							"CASE WHEN hidden_at IS NULL AND report_count + 1 >= ? THEN ? ELSE hidden_by END",
+							threshold, promocode.HiddenByReports,
+						),
+					},
+				).Error
+		},
+	)
+	if isUniqueViolation(err, "comment_reports_pkey") {
+		return &customerrors.RepositoryError{
+			Code:        409,
+			Message:     "already reported",
+			DebugDetail: err.Error(),
+		}
+	}
+	if err != nil {
+		return customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return nil
+}
+
+func (r *PromoCodeRepository) SetCommentHidden(
+	comment uuid.UUID,
+	promo uuid.UUID,
+	hiddenBy string,
+) *customerrors.RepositoryError {
+	columns := map[string]interface{}{
+		"hidden_at": nil,
+		"hidden_by": "",
+	}
+	if hiddenBy != "" {
+		columns["hidden_at"] = time.Now()
+		columns["hidden_by"] = hiddenBy
+	} else {
+		columns["report_count"] = 0
+	}
+	result := r.db.Model(&promocode.Comment{}).
+		Where("id = ? AND promo_code_id = ? AND deleted_at IS NULL", comment, promo).
+		UpdateColumns(columns)
+	if result.Error != nil {
+		return customerrors.UnknownErrorInRepository(result.Error.Error())
+	}
+	if result.RowsAffected == 0 {
+		return customerrors.NotFoundInRepository()
+	}
+	return nil
+}
+
+func (r *PromoCodeRepository) GetModerationQueue(
+	company uuid.UUID,
+	hiddenOnly bool,
+	limit *int,
+	offset int,
+) ([]*promocode.ModerationItem, int) {
 	var comments []promocode.Comment
-	var commentViews []*promocode.CommentView
+	var count int64
 
-	r.db.Where("promo_code_id = ?", promoID).Order("created_at DESC").Find(&comments)
+	query := r.db.Model(&promocode.Comment{}).
+		Joins("JOIN promo_codes p ON p.id = comments.promo_code_id AND p.deleted_at IS NULL").
+		Where("p.company_id = ? AND comments.deleted_at IS NULL", company)
+	if hiddenOnly {
+		query = query.Where("comments.hidden_at IS NOT NULL")
+	} else {
+		query = query.Where("(comments.report_count > 0 OR comments.hidden_at IS NOT NULL)")
+	}
+	query.Count(&count)
+	query = query.Select("comments.*").Order("comments.report_count DESC, comments.created_at DESC")
+	if limit != nil {
+		query = query.Limit(*limit)
+	}
+	query.Offset(offset).Find(&comments)
 
-	for _, comment := range comments {
-		var u user.User
-		userResult := r.db.First(&u, comment.UserID)
-		if userResult.Error != nil {
+	ids := make([]uuid.UUID, 0, len(comments))
+	for _, c := range comments {
+		ids = append(ids, c.ID)
+	}
+	var reasons []struct {
+		CommentID uuid.UUID
+		Reason    promocode.ReportReason
+		Count     int
+	}
+	if len(ids) > 0 {
+		r.db.Model(&promocode.CommentReport{}).
+			Select("comment_id, reason, COUNT(*) AS count").
+			Where("comment_id IN ?", ids).
+			Group("comment_id, reason").
+			Scan(&reasons)
+	}
+	reasonsByComment := make(map[uuid.UUID]map[promocode.ReportReason]int, len(ids))
+	for _, row := range reasons {
+		if reasonsByComment[row.CommentID] == nil {
+			reasonsByComment[row.CommentID] = make(map[promocode.ReportReason]int)
+		}
+		reasonsByComment[row.CommentID][row.Reason] = row.Count
+	}
+
+	authors := r.commentAuthors(comments)
+	items := make([]*promocode.ModerationItem, 0, len(comments))
+	for _, c := range comments {
+		item := &promocode.ModerationItem{
+			PromoID:     c.PromoCodeID,
+			Comment:     c.ToModerationView(authors[c.UserID]),
+			ReportCount: c.ReportCount,
+			Reasons:     reasonsByComment[c.ID],
+			Hidden:      c.Hidden(),
+			HiddenBy:    c.HiddenBy,
+		}
+		if item.Reasons == nil {
+			item.Reasons = map[promocode.ReportReason]int{}
+		}
+		items = append(items, item)
+	}
+	return items, int(count)
+}
+
+// commentAuthors loads users and companies that wrote the given comments.
+func (r *PromoCodeRepository) commentAuthors(comments []promocode.Comment) map[uuid.UUID]*promocode.CommentAuthor {
+	var userIDs, companyIDs []uuid.UUID
+	for _, c := range comments {
+		if c.Deleted() {
 			continue
 		}
+		if c.AuthorType == promocode.CommentAuthorBusiness {
+			companyIDs = append(companyIDs, c.UserID)
+		} else {
+			userIDs = append(userIDs, c.UserID)
+		}
+	}
+	authors := make(map[uuid.UUID]*promocode.CommentAuthor, len(comments))
+	if len(userIDs) > 0 {
+		var users []user.User
+		r.db.Where("id IN ?", userIDs).Find(&users)
+		for _, u := range users {
+			authors[u.ID] = &promocode.CommentAuthor{
+				Id:        u.ID,
+				Type:      promocode.CommentAuthorUser,
+				Name:      u.Name,
+				Surname:   u.Surname,
+				AvatarUrl: u.AvatarURL,
+			}
+		}
+	}
+	if len(companyIDs) > 0 {
+		var companies []business.Business
+		r.db.Where("id IN ?", companyIDs).Find(&companies)
+		for _, b := range companies {
+			authors[b.ID] = &promocode.CommentAuthor{
+				Id:        b.ID,
+				Type:      promocode.CommentAuthorBusiness,
+				Name:      b.CompanyName,
+				AvatarUrl: b.LogoURL,
+			}
+		}
+	}
+	return authors
+}
+
+// AddUse records the activation and bumps used_count in one transaction. For
+// COMMON promo codes the increment is conditional, so concurrent activations
+// can never push used_count past max_count.
+func (r *PromoCodeRepository) AddUse(u *promocode.Use) *customerrors.RepositoryError {
+	exhausted := errors.New("promo code exhausted")
+	err := r.db.Transaction(
+		func(tx *gorm.DB) error {
+			result := tx.Model(&promocode.PromoCode{}).
+				Where("id = ?", u.PromoCodeID).
+				Where("mode <> ? OR used_count < max_count", promocode.COMMON).
+				UpdateColumns(
+					map[string]interface{}{
+						"used_count": gorm.Expr("used_count + 1"),
+						"updated_at": time.Now(),
+					},
+				)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return exhausted
+			}
+			if err := tx.Create(u).Error; err != nil {
+				return err
+			}
+			var p promocode.PromoCode
+			if err := tx.First(&p, "id = ?", u.PromoCodeID).Error; err != nil {
+				return err
+			}
+			events := []outbox.Event{p.ActivatedEvent(u)}
+			if p.Remaining() == 0 {
+				events = append(events, p.ExhaustedEvent())
+			}
+			return addEvents(tx, events...)
+		},
+	)
+	if errors.Is(err, exhausted) {
+		return &customerrors.RepositoryError{
+			Code:        403,
+			Message:     "forbidden",
+			DebugDetail: err.Error(),
+		}
+	}
+	if err != nil {
+		return customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return nil
+}
 
-		commentViews = append(
-			commentViews, &promocode.CommentView{
-				Id:   comment.ID,
-				Text: comment.Content,
-				Date: comment.CreatedAt.Format(time.RFC3339),
-				Author: struct {
-					Id        uuid.UUID `json:"id"`
-					Name      string    `json:"name"`
-					Surname   string    `json:"surname"`
-					AvatarUrl *string   `json:"avatar_url"`
-				}{
-					Id:        u.ID,
-					Name:      u.Name,
-					Surname:   u.Surname,
-					AvatarUrl: u.AvatarURL,
+func (r *PromoCodeRepository) Redeem(
+	promo uuid.UUID,
+	code string,
+	user *uuid.UUID,
+) (*promocode.Use, *customerrors.RepositoryError) {
+	var use promocode.Use
+	err := r.db.Transaction(
+		func(tx *gorm.DB) error {
+			query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("promo_code_id = ? AND code = ?", promo, code)
+			if user != nil {
+				query = query.Where("user_id = ?", *user)
+			}
+			err := query.Order("redeemed_at IS NOT NULL, created_at").First(&use).Error
+			if err != nil {
+				return err
+			}
+			if use.RedeemedAt != nil {
+				return errAlreadyRedeemed
+			}
+			now := time.Now()
+			use.RedeemedAt = &now
+			return tx.Model(&use).UpdateColumn("redeemed_at", now).Error
+		},
+	)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, customerrors.NotFoundInRepository()
+	}
+	if errors.Is(err, errAlreadyRedeemed) {
+		return nil, &customerrors.RepositoryError{
+			Code:        409,
+			Message:     "already redeemed",
+			DebugDetail: "",
+		}
+	}
+	if err != nil {
+		return nil, customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return &use, nil
+}
+
+var errAlreadyRedeemed = errors.New("already redeemed")
+
+// funnelSQL counts each stage per promo code with one scan of each table.
+// Impressions and views are distinct users, the other stages are events.
+// Impressions are kept per day, so they cover the days the window overlaps,
+// [@from_day, @to_day).
+const funnelSQL = `
+WITH promos AS (
+	SELECT id FROM promo_codes
+	WHERE company_id = @company AND deleted_at IS NULL AND (@all OR id = @promo)
+), impressions AS (
+	SELECT promo_code_id AS id,
+		COUNT(DISTINCT user_id) FILTER (WHERE kind = 'feed') AS impressions,
+		COUNT(DISTINCT user_id) FILTER (WHERE kind = 'view') AS views
+	FROM impressions
+	WHERE promo_code_id IN (SELECT id FROM promos) AND day >= @from_day::date AND day < @to_day::date
+	GROUP BY 1
+), likes AS (
+	SELECT promo_code_id AS id, COUNT(*) AS likes
+	FROM likes
+	WHERE promo_code_id IN (SELECT id FROM promos) AND created_at >= @from AND created_at < @to
+	GROUP BY 1
+), uses AS (
+	SELECT promo_code_id AS id,
+		COUNT(*) FILTER (WHERE created_at >= @from AND created_at < @to) AS activations,
+		COUNT(*) FILTER (WHERE redeemed_at >= @from AND redeemed_at < @to) AS redemptions
+	FROM uses
+	WHERE promo_code_id IN (SELECT id FROM promos)
+	GROUP BY 1
+)
+SELECT p.id,
+	COALESCE(i.impressions, 0) AS impressions,
+	COALESCE(i.views, 0) AS views,
+	COALESCE(l.likes, 0) AS likes,
+	COALESCE(u.activations, 0) AS activations,
+	COALESCE(u.redemptions, 0) AS redemptions
+FROM promos p
+LEFT JOIN impressions i USING (id)
+LEFT JOIN likes l USING (id)
+LEFT JOIN uses u USING (id)
+`
+
+func (r *PromoCodeRepository) GetFunnels(
+	company uuid.UUID,
+	promo *uuid.UUID,
+	from, to time.Time,
+) map[uuid.UUID]*promocode.Funnel {
+	args := map[string]interface{}{
+		"company": company,
+		"all":     promo == nil,
+		"promo":   uuid.Nil,
+		"from":    from.UTC(),
+		"to":      to.UTC(),
+		// The UTC day of from, and the first UTC day starting at or
+		// after to, which ends the window like to does the other stages.
+		"from_day": from.UTC().Format(time.DateOnly),
+		"to_day":   to.UTC().Add(24*time.Hour - time.Nanosecond).Format(time.DateOnly),
+	}
+	if promo != nil {
+		args["promo"] = *promo
+	}
+	var rows []struct {
+		ID uuid.UUID
+		promocode.Funnel
+	}
+	if err := r.db.Raw(funnelSQL, args).Scan(&rows).Error; err != nil {
+		zap.S().Errorw("funnel", "company", company, "error", err)
+	}
+	funnels := make(map[uuid.UUID]*promocode.Funnel, len(rows))
+	for i := range rows {
+		funnels[rows[i].ID] = &rows[i].Funnel
+	}
+	return funnels
+}
+
+// rollupLockKey serializes rollup refreshes of all server instances.
+const rollupLockKey = 0x726f6c6c7570
+
+// refreshRollupsSQL rebuilds the rollups of @day and later from uses. Age
+// bands are by the age of the user at refresh time.
+const refreshRollupsSQL = `
+INSERT INTO daily_rollups (promo_code_id, day, country, age_band, company_id, activations, refreshed_at)
+SELECT s.promo_code_id,
+	(s.created_at AT TIME ZONE 'UTC')::date,
+	s.country_lower,
+	` + ageBandExpr + `,
+	p.company_id,
+	COUNT(*),
+	@now
+FROM uses s
+JOIN promo_codes p ON p.id = s.promo_code_id
+LEFT JOIN users u ON u.id = s.user_id
+WHERE s.created_at >= @from
+GROUP BY 1, 2, 3, 4, 5
+`
+
+func (r *PromoCodeRepository) RefreshRollups(from time.Time) *customerrors.RepositoryError {
+	from = from.UTC()
+	err := r.db.Transaction(
+		func(tx *gorm.DB) error {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", rollupLockKey).Error; err != nil {
+				return err
+			}
+			err := tx.Where("day >= ?", from.Format(time.DateOnly)).Delete(&promocode.DailyRollup{}).Error
+			if err != nil {
+				return err
+			}
+			return tx.Exec(
+				refreshRollupsSQL, map[string]interface{}{
+					"from": from,
+					"now":  time.Now(),
 				},
+			).Error
+		},
+	)
+	if err != nil {
+		return customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return nil
+}
+
+func (r *PromoCodeRepository) GetRollupWatermark() *time.Time {
+	var last *time.Time
+	r.db.Model(&promocode.DailyRollup{}).Select("MAX(day)").Scan(&last)
+	return last
+}
+
+func (r *PromoCodeRepository) companyRollups(company uuid.UUID, from, to time.Time) *gorm.DB {
+	return r.db.Model(&promocode.DailyRollup{}).Where(
+		"daily_rollups.company_id = ? AND daily_rollups.day >= ? AND daily_rollups.day < ?",
+		company, from.UTC().Format(time.DateOnly), to.UTC().Format(time.DateOnly),
+	)
+}
+
+// GetCompanyStatistics counts activations of deleted promo codes too, except
+// in top_promos. The series has one entry per day, zeros included.
+func (r *PromoCodeRepository) GetCompanyStatistics(
+	company uuid.UUID,
+	from, to time.Time,
+	top int,
+) map[string]interface{} {
+	stats := make(map[string]interface{})
+
+	var days []struct {
+		Day         time.Time
+		Activations int
+	}
+	r.companyRollups(company, from, to).
+		Select("day, SUM(activations) AS activations").
+		Group("day").
+		Scan(&days)
+	perDay := make(map[string]int, len(days))
+	for _, d := range days {
+		perDay[d.Day.Format(time.DateOnly)] = d.Activations
+	}
+	var total int
+	series := make([]map[string]interface{}, 0)
+	for day := from.UTC(); day.Before(to); day = day.AddDate(0, 0, 1) {
+		n := perDay[day.Format(time.DateOnly)]
+		total += n
+		series = append(
+			series, map[string]interface{}{
+				"date":              day.Format(time.DateOnly),
+				"activations_count": n,
+			},
+		)
+	}
+	var refreshedAt *time.Time
+	r.db.Model(&promocode.DailyRollup{}).
+		Where("company_id = ?", company).
+		Select("MAX(refreshed_at)").
+		Scan(&refreshedAt)
+	window := map[string]interface{}{
+		"from":              from.UTC().Format(time.DateOnly),
+		"to":                to.UTC().AddDate(0, 0, -1).Format(time.DateOnly),
+		"activations_count": total,
+	}
+	if refreshedAt != nil {
+		window["refreshed_at"] = refreshedAt.UTC().Format(time.RFC3339)
+	}
+	stats["window"] = window
+	stats["series"] = series
+
+	var topRows []struct {
+		ID          uuid.UUID
+		Description string
+		Activations int
+	}
+	r.companyRollups(company, from, to).
+		Joins("JOIN promo_codes p ON p.id = daily_rollups.promo_code_id AND p.deleted_at IS NULL").
+		Select("p.id, p.description, SUM(daily_rollups.activations) AS activations").
+		Group("p.id, p.description").
+		Order("activations DESC, p.id").
+		Limit(top).
+		Scan(&topRows)
+	topPromos := make([]map[string]interface{}, 0, len(topRows))
+	for _, row := range topRows {
+		topPromos = append(
+			topPromos, map[string]interface{}{
+				"promo_id":          row.ID,
+				"description":       row.Description,
+				"activations_count": row.Activations,
 			},
 		)
 	}
+	stats["top_promos"] = topPromos
 
-	return commentViews
+	var countries []struct {
+		Country     string
+		Activations int
+	}
+	r.companyRollups(company, from, to).
+		Select("country, SUM(activations) AS activations").
+		Group("country").
+		Order("activations DESC, country").
+		Scan(&countries)
+	byCountry := make([]map[string]interface{}, 0, len(countries))
+	for _, row := range countries {
+		byCountry = append(
+			byCountry, map[string]interface{}{
+				"country":           row.Country,
+				"activations_count": row.Activations,
+			},
+		)
+	}
+	stats["by_country"] = byCountry
+
+	var bands []struct {
+		AgeBand     string
+		Activations int
+	}
+	r.companyRollups(company, from, to).
+		Select("age_band, SUM(activations) AS activations").
+		Group("age_band").
+		Order("age_band").
+		Scan(&bands)
+	byAgeBand := make([]map[string]interface{}, 0, len(bands))
+	for _, row := range bands {
+		byAgeBand = append(
+			byAgeBand, map[string]interface{}{
+				"age_band":          row.AgeBand,
+				"activations_count": row.Activations,
+			},
+		)
+	}
+	stats["by_age_band"] = byAgeBand
+	return stats
 }
 
-func (r *PromoCodeRepository) AddUse(u *promocode.Use) *customerrors.RepositoryError {
-	r.db.Create(u)
-	return nil
+func (r *PromoCodeRepository) GetRollupActivations(company uuid.UUID, from time.Time) map[uuid.UUID]int {
+	var rows []struct {
+		PromoCodeID uuid.UUID
+		Activations int
+	}
+	r.db.Model(&promocode.DailyRollup{}).
+		Where("company_id = ? AND day >= ?", company, from.UTC().Format(time.DateOnly)).
+		Select("promo_code_id, SUM(activations) AS activations").
+		Group("promo_code_id").
+		Scan(&rows)
+	activations := make(map[uuid.UUID]int, len(rows))
+	for _, row := range rows {
+		activations[row.PromoCodeID] = row.Activations
+	}
+	return activations
+}
+
+// streamUsesSQL selects uses with their user's age band and email; uses of
+// deleted users keep an empty email.
+const streamUsesSQL = `
+SELECT s.*, ` + ageBandExpr + ` AS age_band, COALESCE(u.email, '') AS email
+FROM uses s
+LEFT JOIN users u ON u.id = s.user_id
+WHERE s.promo_code_id = ?
+ORDER BY s.created_at, s.id
+`
+
+func (r *PromoCodeRepository) StreamUses(promo uuid.UUID, fn func(*promocode.UseRecord) error) error {
+	rows, err := r.db.Raw(streamUsesSQL, promo).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var record promocode.UseRecord
+		if err := r.db.ScanRows(rows, &record); err != nil {
+			return err
+		}
+		if err := fn(&record); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (r *PromoCodeRepository) StreamRollups(
+	company uuid.UUID,
+	from, to time.Time,
+	fn func(*promocode.DailyRollup) error,
+) error {
+	rows, err := r.companyRollups(company, from, to).
+		Order("day, promo_code_id, country, age_band").
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rollup promocode.DailyRollup
+		if err := r.db.ScanRows(rows, &rollup); err != nil {
+			return err
+		}
+		if err := fn(&rollup); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
 }
 
 func (r *PromoCodeRepository) UseHistory(id uuid.UUID) []*promocode.Use {
@@ -338,3 +1583,112 @@ func (r *PromoCodeRepository) UseHistory(id uuid.UUID) []*promocode.Use {
 	r.db.Where("user_id = ?", id).Order("created_at DESC").Find(&uses)
 	return uses
 }
+
+func (r *PromoCodeRepository) GetUseSince(promo uuid.UUID, user uuid.UUID, since time.Time) (
+	*promocode.Use,
+	*customerrors.RepositoryError,
+) {
+	var use promocode.Use
+	err := r.db.Where("promo_code_id = ? AND user_id = ? AND created_at >= ?", promo, user, since).
+		Order("created_at").
+		First(&use).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, customerrors.NotFoundInRepository()
+		}
+		return nil, customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return &use, nil
+}
+
+// recountCounters sets the counters of promo_codes p from the rows they
+// count.
+const recountCounters = `
+	like_count = (SELECT COUNT(*) FROM likes l WHERE l.promo_code_id = p.id),
+	comment_count = (SELECT COUNT(*) FROM comments c WHERE c.promo_code_id = p.id AND c.deleted_at IS NULL),
+	used_count = (SELECT COUNT(*) FROM uses u WHERE u.promo_code_id = p.id),
+	rating_1 = (SELECT COUNT(*) FROM ratings r WHERE r.promo_code_id = p.id AND r.stars = 1),
+	rating_2 = (SELECT COUNT(*) FROM ratings r WHERE r.promo_code_id = p.id AND r.stars = 2),
+	rating_3 = (SELECT COUNT(*) FROM ratings r WHERE r.promo_code_id = p.id AND r.stars = 3),
+	rating_4 = (SELECT COUNT(*) FROM ratings r WHERE r.promo_code_id = p.id AND r.stars = 4),
+	rating_5 = (SELECT COUNT(*) FROM ratings r WHERE r.promo_code_id = p.id AND r.stars = 5)`
+
+func (r *PromoCodeRepository) ReconcileCounters(apply bool) (
+	[]promocode.CounterDrift,
+	*customerrors.RepositoryError,
+) {
+	var rows []struct {
+		ID           uuid.UUID
+		LikeCount    int
+		CommentCount int
+		UsedCount    int
+		Likes        int
+		Comments     int
+		Uses         int
+		Rating1      int `gorm:"column:rating_1"`
+		Rating2      int `gorm:"column:rating_2"`
+		Rating3      int `gorm:"column:rating_3"`
+		Rating4      int `gorm:"column:rating_4"`
+		Rating5      int `gorm:"column:rating_5"`
+		Stars1       int
+		Stars2       int
+		Stars3       int
+		Stars4       int
+		Stars5       int
+	}
+	err := r.db.Raw(
+		`
+SELECT p.id, p.like_count, p.comment_count, p.used_count,
+	p.rating_1, p.rating_2, p.rating_3, p.rating_4, p.rating_5,
+	(SELECT COUNT(*) FROM likes l WHERE l.promo_code_id = p.id) AS likes,
+	(SELECT COUNT(*) FROM comments c WHERE c.promo_code_id = p.id AND c.deleted_at IS NULL) AS comments,
+	(SELECT COUNT(*) FROM uses u WHERE u.promo_code_id = p.id) AS uses,
+	(SELECT COUNT(*) FROM ratings r WHERE r.promo_code_id = p.id AND r.stars = 1) AS stars1,
+	(SELECT COUNT(*) FROM ratings r WHERE r.promo_code_id = p.id AND r.stars = 2) AS stars2,
+	(SELECT COUNT(*) FROM ratings r WHERE r.promo_code_id = p.id AND r.stars = 3) AS stars3,
+	(SELECT COUNT(*) FROM ratings r WHERE r.promo_code_id = p.id AND r.stars = 4) AS stars4,
+	(SELECT COUNT(*) FROM ratings r WHERE r.promo_code_id = p.id AND r.stars = 5) AS stars5
+FROM promo_codes p
+WHERE p.deleted_at IS NULL
+`,
+	).Scan(&rows).Error
+	if err != nil {
+		return nil, customerrors.UnknownErrorInRepository(err.Error())
+	}
+
+	var drift []promocode.CounterDrift
+	var ids []uuid.UUID
+	for _, row := range rows {
+		before := len(drift)
+		if row.LikeCount != row.Likes {
+			drift = append(drift, promocode.CounterDrift{PromoCodeID: row.ID, Counter: "like_count", Stored: row.LikeCount, Actual: row.Likes})
+		}
+		if row.CommentCount != row.Comments {
+			drift = append(drift, promocode.CounterDrift{PromoCodeID: row.ID, Counter: "comment_count", Stored: row.CommentCount, Actual: row.Comments})
+		}
+		if row.UsedCount != row.Uses {
+			drift = append(drift, promocode.CounterDrift{PromoCodeID: row.ID, Counter: "used_count", Stored: row.UsedCount, Actual: row.Uses})
+		}
+		stored := [5]int{row.Rating1, row.Rating2, row.Rating3, row.Rating4, row.Rating5}
+		actual := [5]int{row.Stars1, row.Stars2, row.Stars3, row.Stars4, row.Stars5}
+		for i := range stored {
+			if stored[i] != actual[i] {
+				drift = append(drift, promocode.CounterDrift{PromoCodeID: row.ID, Counter: ratingColumn(i + 1), Stored: stored[i], Actual: actual[i]})
+			}
+		}
+		if len(drift) > before {
+			ids = append(ids, row.ID)
+		}
+	}
+	if !apply || len(ids) == 0 {
+		return drift, nil
+	}
+
+	// Counts are recomputed inside the UPDATE so writes that landed after the
+	// scan above are not lost.
+	err = r.db.Exec(`UPDATE promo_codes p SET `+recountCounters+`, updated_at = ? WHERE p.id IN ?`, time.Now(), ids).Error
+	if err != nil {
+		return drift, customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return drift, nil
+}
@@ -0,0 +1,223 @@
+package persistence
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"os"
+	"solution/internal/domain/business"
+	"solution/internal/domain/promocode"
+	"solution/internal/domain/user"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testDB opens POSTGRES_CONN and returns a transaction rolled back when the
+// test ends. Tests are skipped without a database.
+func testDB(tb testing.TB) *gorm.DB {
+	tb.Helper()
+	dsn := os.Getenv("POSTGRES_CONN")
+	if dsn == "" {
+		tb.Skip("POSTGRES_CONN is not set")
+	}
+	db, err := gorm.Open(
+		postgres.New(postgres.Config{DSN: dsn}),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)},
+	)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	// Every table the views under test read, so that none of their queries
+	// fails and aborts the transaction.
+	err = db.AutoMigrate(
+		&business.Business{},
+		&promocode.PromoCode{},
+		&promocode.Like{},
+		&promocode.SavedPromo{},
+		&promocode.Comment{},
+		&promocode.CommentReaction{},
+		&promocode.Use{},
+		&user.User{},
+	)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tx := db.Begin()
+	tb.Cleanup(func() { tx.Rollback() })
+	return tx
+}
+
+// countQueries counts the statements issued on db from now on.
+func countQueries(tb testing.TB, db *gorm.DB) *atomic.Int64 {
+	tb.Helper()
+	var queries atomic.Int64
+	count := func(*gorm.DB) { queries.Add(1) }
+	name := "test:count:" + tb.Name()
+	_ = db.Callback().Query().After("gorm:query").Register(name, count)
+	_ = db.Callback().Row().After("gorm:row").Register(name, count)
+	_ = db.Callback().Raw().After("gorm:raw").Register(name, count)
+	tb.Cleanup(
+		func() {
+			_ = db.Callback().Query().Remove(name)
+			_ = db.Callback().Row().Remove(name)
+			_ = db.Callback().Raw().Remove(name)
+		},
+	)
+	return &queries
+}
+
+func seedUser(tb testing.TB, tx *gorm.DB) *user.User {
+	tb.Helper()
+	u := &user.User{
+		ID:           uuid.New(),
+		Name:         "Bench",
+		Surname:      "User",
+		Email:        uuid.NewString() + "@feed.test",
+		Age:          30,
+		Country:      "ru",
+		PasswordHash: "-",
+	}
+	require.NoError(tb, tx.Create(u).Error)
+	return u
+}
+
+// seedPromoCodes creates n promo codes of the company, each liked, saved,
+// commented and activated by the returned user.
+func seedPromoCodes(tb testing.TB, tx *gorm.DB, companyID uuid.UUID, n int) *user.User {
+	tb.Helper()
+	u := seedUser(tb, tx)
+	now := time.Now()
+	for i := 0; i < n; i++ {
+		p := &promocode.PromoCode{
+			ID:          uuid.New(),
+			Description: fmt.Sprintf("feed test promo %d", i),
+			CompanyID:   companyID,
+			CompanyName: "feed test",
+			MaxCount:    n,
+			Mode:        promocode.COMMON,
+			Promo:       pq.StringArray{"BENCH"},
+			ActiveFrom:  &now,
+		}
+		require.NoError(tb, tx.Create(p).Error)
+		require.NoError(tb, tx.Create(&promocode.Like{PromoCodeID: p.ID, UserID: u.ID}).Error)
+		require.NoError(tb, tx.Create(&promocode.SavedPromo{PromoCodeID: p.ID, UserID: u.ID}).Error)
+		require.NoError(
+			tb,
+			tx.Create(
+				&promocode.Comment{ID: uuid.New(), PromoCodeID: p.ID, UserID: u.ID, Content: "feed test comment"},
+			).Error,
+		)
+		require.NoError(
+			tb,
+			tx.Create(
+				&promocode.Use{
+					ID: uuid.New(), PromoCodeID: p.ID, UserID: u.ID, Country: u.Country, CountryLower: u.Country,
+				},
+			).Error,
+		)
+	}
+	return u
+}
+
+// seedComments adds n threads to the promo code, each by another user.
+func seedComments(tb testing.TB, tx *gorm.DB, promoID uuid.UUID, n int) {
+	tb.Helper()
+	for i := 0; i < n; i++ {
+		u := seedUser(tb, tx)
+		require.NoError(
+			tb,
+			tx.Create(
+				&promocode.Comment{
+					ID:          uuid.New(),
+					PromoCodeID: promoID,
+					UserID:      u.ID,
+					Content:     fmt.Sprintf("thread %d", i),
+				},
+			).Error,
+		)
+	}
+}
+
+// The list views must issue the same number of queries whatever the page
+// size.
+func TestListQueriesDoNotGrowWithPageSize(t *testing.T) {
+	tx := testDB(t)
+	companyID := uuid.New()
+	u := seedPromoCodes(t, tx, companyID, 50)
+	commented := uuid.New()
+	seedComments(t, tx, commented, 50)
+	ds := promocode.NewDomainService(NewPromoCodeRepository(tx), nil, nil, nil)
+	queries := countQueries(t, tx)
+
+	// Each view returns the size of the page it got, which must be full: an
+	// empty page would issue fewer queries and prove nothing.
+	views := map[string]func(limit int) int{
+		"feed": func(limit int) int {
+			page, _, _ := ds.GetFeed(u.ID, &promocode.GetAsUserFeedParams{Limit: &limit, Age: u.Age, Country: u.Country})
+			return len(page)
+		},
+		"company list": func(limit int) int {
+			page, _, _ := ds.GetByCompanyID(companyID, &limit, 0, "", nil)
+			return len(page)
+		},
+		"comments": func(limit int) int {
+			page, _ := ds.GetComments(commented, u.ID, &limit, 0, "")
+			return len(page)
+		},
+	}
+	for name, view := range views {
+		var first int64
+		for i, size := range []int{1, 10, 50} {
+			queries.Store(0)
+			require.Equal(t, size, view(size), "%s: page size", name)
+			got := queries.Load()
+			if i == 0 {
+				first = got
+			} else if got != first {
+				t.Errorf("%s: %d queries for page size %d, %d for page size 1", name, got, size, first)
+			}
+		}
+	}
+
+	// The history has no page, it grows with the activations of the user.
+	one := seedPromoCodes(t, tx, uuid.New(), 1)
+	queries.Store(0)
+	require.Len(t, ds.UseHistory(one.ID), 1)
+	want := queries.Load()
+	queries.Store(0)
+	require.Len(t, ds.UseHistory(u.ID), 50)
+	if got := queries.Load(); got != want {
+		t.Errorf("history: %d queries for 50 activations, %d for 1", got, want)
+	}
+}
+
+func BenchmarkGetFeed(b *testing.B) {
+	tx := testDB(b)
+	u := seedPromoCodes(b, tx, uuid.New(), 100)
+	ds := promocode.NewDomainService(NewPromoCodeRepository(tx), nil, nil, nil)
+	limit := 50
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ds.GetFeed(u.ID, &promocode.GetAsUserFeedParams{Limit: &limit, Age: u.Age, Country: u.Country})
+	}
+}
+
+// BenchmarkGetComments loads a page of threads by different authors, all of
+// whom are looked up at once.
+func BenchmarkGetComments(b *testing.B) {
+	tx := testDB(b)
+	viewer := seedUser(b, tx)
+	promoID := uuid.New()
+	seedComments(b, tx, promoID, 100)
+	ds := promocode.NewDomainService(NewPromoCodeRepository(tx), nil, nil, nil)
+	limit := 50
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ds.GetComments(promoID, viewer.ID, &limit, 0, "")
+	}
+}
@@ -0,0 +1,166 @@
+package persistence
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	customerrors "solution/internal/domain/errors"
+	"solution/internal/domain/webhook"
+	"time"
+)
+
+type WebhookRepository struct {
+	db *gorm.DB
+}
+
+func NewWebhookRepository(db *gorm.DB) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+func (r *WebhookRepository) Create(w *webhook.Webhook) *customerrors.RepositoryError {
+	if err := r.db.Create(w).Error; err != nil {
+		return customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return nil
+}
+
+func (r *WebhookRepository) Get(id uuid.UUID) (*webhook.Webhook, *customerrors.RepositoryError) {
+	var w webhook.Webhook
+	if err := r.db.First(&w, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, customerrors.NotFoundInRepository()
+		}
+		return nil, customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return &w, nil
+}
+
+func (r *WebhookRepository) GetByCompany(company uuid.UUID) []*webhook.Webhook {
+	var webhooks []*webhook.Webhook
+	r.db.Where("company_id = ?", company).Order("created_at").Find(&webhooks)
+	return webhooks
+}
+
+func (r *WebhookRepository) GetActiveByCompany(company uuid.UUID) []*webhook.Webhook {
+	var webhooks []*webhook.Webhook
+	r.db.Where("company_id = ? AND active", company).Find(&webhooks)
+	return webhooks
+}
+
+func (r *WebhookRepository) Save(w *webhook.Webhook) *customerrors.RepositoryError {
+	if err := r.db.Save(w).Error; err != nil {
+		return customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return nil
+}
+
+// Delete removes the webhook with its delivery log.
+func (r *WebhookRepository) Delete(id uuid.UUID) *customerrors.RepositoryError {
+	err := r.db.Transaction(
+		func(tx *gorm.DB) error {
+			if err := tx.Where("webhook_id = ?", id).Delete(&webhook.Delivery{}).Error; err != nil {
+				return err
+			}
+			result := tx.Delete(&webhook.Webhook{}, "id = ?", id)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return gorm.ErrRecordNotFound
+			}
+			return nil
+		},
+	)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return customerrors.NotFoundInRepository()
+	}
+	if err != nil {
+		return customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return nil
+}
+
+const claimDueSQL = `
+UPDATE webhook_deliveries SET next_attempt_at = @lease, updated_at = @now
+WHERE id IN (
+	SELECT id FROM webhook_deliveries
+	WHERE status = @pending AND next_attempt_at <= @now
+	ORDER BY next_attempt_at
+	LIMIT @limit
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *
+`
+
+func (r *WebhookRepository) ClaimDue(now time.Time, lease time.Duration, limit int) []*webhook.Delivery {
+	var deliveries []*webhook.Delivery
+	r.db.Raw(
+		claimDueSQL, map[string]interface{}{
+			"now":     now,
+			"lease":   now.Add(lease),
+			"pending": webhook.StatusPending,
+			"limit":   limit,
+		},
+	).Scan(&deliveries)
+	return deliveries
+}
+
+func (r *WebhookRepository) AddDelivery(d *webhook.Delivery) *customerrors.RepositoryError {
+	if err := r.db.Create(d).Error; err != nil {
+		return customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return nil
+}
+
+func (r *WebhookRepository) AddDeliveries(deliveries []*webhook.Delivery) *customerrors.RepositoryError {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	err := r.db.Clauses(
+		clause.OnConflict{Columns: []clause.Column{{Name: "webhook_id"}, {Name: "event_id"}}, DoNothing: true},
+	).Create(&deliveries).Error
+	if err != nil {
+		return customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return nil
+}
+
+func (r *WebhookRepository) SaveDelivery(d *webhook.Delivery) *customerrors.RepositoryError {
+	if err := r.db.Save(d).Error; err != nil {
+		return customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return nil
+}
+
+func (r *WebhookRepository) GetDelivery(id uuid.UUID, webhookID uuid.UUID) (
+	*webhook.Delivery,
+	*customerrors.RepositoryError,
+) {
+	var d webhook.Delivery
+	if err := r.db.First(&d, "id = ? AND webhook_id = ?", id, webhookID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, customerrors.NotFoundInRepository()
+		}
+		return nil, customerrors.UnknownErrorInRepository(err.Error())
+	}
+	return &d, nil
+}
+
+func (r *WebhookRepository) GetDeliveries(webhookID uuid.UUID, status string, limit *int, offset int) (
+	[]*webhook.Delivery,
+	int,
+) {
+	query := r.db.Model(&webhook.Delivery{}).Where("webhook_id = ?", webhookID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var count int64
+	query.Count(&count)
+	if limit != nil {
+		query = query.Limit(*limit)
+	}
+	var deliveries []*webhook.Delivery
+	query.Order("created_at DESC").Offset(offset).Find(&deliveries)
+	return deliveries, int(count)
+}
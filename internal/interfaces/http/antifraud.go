@@ -0,0 +1,135 @@
+package http
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"solution/internal/application/antifraud"
+	customerrors "solution/internal/domain/errors"
+	"strconv"
+)
+
+type AntifraudAPI struct {
+	as *antifraud.ApplicationService
+}
+
+func NewAntifraudAPI(as *antifraud.ApplicationService) *AntifraudAPI {
+	return &AntifraudAPI{as: as}
+}
+
+func (a *AntifraudAPI) GetDecisions(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("id " + err.Error()).ToFiber(c)
+	}
+	companyID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	params := &antifraud.GetDecisionsQueryParams{}
+	if err := params.Bind(c, v); err != nil {
+		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
+	}
+	response, count, er := a.as.GetDecisions(companyID, id, params)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	c.Set("X-Total-Count", strconv.Itoa(count))
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (a *AntifraudAPI) GetHolds(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("id " + err.Error()).ToFiber(c)
+	}
+	companyID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	params := &antifraud.GetHoldsQueryParams{}
+	if err := params.Bind(c, v); err != nil {
+		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
+	}
+	response, count, er := a.as.GetHolds(companyID, id, params)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	c.Set("X-Total-Count", strconv.Itoa(count))
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (a *AntifraudAPI) ApproveHold(c *fiber.Ctx) error {
+	return a.resolveHold(c, a.as.ApproveHold)
+}
+
+func (a *AntifraudAPI) RejectHold(c *fiber.Ctx) error {
+	return a.resolveHold(c, a.as.RejectHold)
+}
+
+func (a *AntifraudAPI) resolveHold(
+	c *fiber.Ctx,
+	resolve func(uuid.UUID, uuid.UUID, uuid.UUID, *antifraud.ResolveHoldRequest) (
+		map[string]interface{},
+		*customerrors.DomainError,
+	),
+) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("id " + err.Error()).ToFiber(c)
+	}
+	holdID, err := uuid.Parse(c.Params("hold_id"))
+	if err != nil {
+		return customerrors.BadRequest("hold_id " + err.Error()).ToFiber(c)
+	}
+	companyID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	request := &antifraud.ResolveHoldRequest{}
+	if err := request.Bind(c, v); err != nil {
+		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
+	}
+	response, er := resolve(companyID, id, holdID, request)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (a *AntifraudAPI) GetActivationHold(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("id " + err.Error()).ToFiber(c)
+	}
+	userID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	response, er := a.as.GetActivationHold(userID, id)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (a *AntifraudAPI) SearchDecisions(c *fiber.Ctx) error {
+	params := &antifraud.SearchDecisionsQueryParams{}
+	if err := params.Bind(c, v); err != nil {
+		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
+	}
+	response, count := a.as.SearchDecisions(params)
+	c.Set("X-Total-Count", strconv.Itoa(count))
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (a *AntifraudAPI) GetShadowReport(c *fiber.Ctx) error {
+	params := &antifraud.ShadowReportQueryParams{}
+	if err := params.Bind(c, v); err != nil {
+		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
+	}
+	report, err := a.as.GetShadowReport(params)
+	if err != nil {
+		return err.ToFiber(c)
+	}
+	return c.Status(fiber.StatusOK).JSON(report)
+}
@@ -1,6 +1,9 @@
 package http
 
 import (
+	"bufio"
+	"encoding/json"
+	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
@@ -9,6 +12,7 @@ import (
 	"solution/internal/domain/promocode"
 	"solution/pkg"
 	"strconv"
+	"time"
 )
 
 type BusinessAPI struct {
@@ -43,6 +47,50 @@ func (b *BusinessAPI) SignIn(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusOK).JSON(response)
 }
 
+func (b *BusinessAPI) GetProfile(c *fiber.Ctx) error {
+	companyID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	response, er := b.as.GetProfile(companyID)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (b *BusinessAPI) EditProfile(c *fiber.Ctx) error {
+	companyID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	request := &business.EditProfileRequest{}
+	if err := request.Bind(c, v); err != nil {
+		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
+	}
+	response, er := b.as.EditProfile(companyID, request)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (b *BusinessAPI) SetExportEmailConsent(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("id " + err.Error()).ToFiber(c)
+	}
+	request := &business.SetExportEmailConsentRequest{}
+	if err := request.Bind(c, v); err != nil {
+		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
+	}
+	response, er := b.as.SetExportEmailConsent(id, request)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
 func (b *BusinessAPI) CreatePromoCode(c *fiber.Ctx) error {
 	request := &business.CreatePromoCodeRequest{}
 	if err := request.Bind(c, v); err != nil {
@@ -78,12 +126,19 @@ func (b *BusinessAPI) GetPromoCodes(c *fiber.Ctx) error {
 	if err != nil {
 		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
 	}
-	response, count, er := b.as.GetAllPromoCodes(companyID, params)
+	lastModified := b.as.PromoCodesLastModified(companyID)
+	if c.Get(fiber.HeaderIfNoneMatch) == "" && notModified(c, "", lastModified) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+	response, count, etag, er := b.as.GetAllPromoCodes(companyID, params)
 	if er != nil {
 		return er.ToFiber(c)
 	}
 	zap.S().Debugw("get promo codes response", "c", count, "offset", params.Offset)
 	c.Set("X-Total-Count", strconv.Itoa(count))
+	if notModified(c, etag, lastModified) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
 	return c.Status(fiber.StatusOK).JSON(response)
 }
 func (b *BusinessAPI) GetPromoCode(c *fiber.Ctx) error {
@@ -95,11 +150,14 @@ func (b *BusinessAPI) GetPromoCode(c *fiber.Ctx) error {
 	if err != nil {
 		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
 	}
-	response, er := b.as.GetPromoCode(companyID, promoID)
+	response, rev, er := b.as.GetPromoCode(companyID, promoID)
 	if er != nil {
 		return er.ToFiber(c)
 	}
 	c.Set("X-Total-Count", "1")
+	if notModified(c, rev.ETag, rev.LastModified) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
 	return c.Status(fiber.StatusOK).JSON(response)
 }
 
@@ -137,6 +195,308 @@ func (b *BusinessAPI) EditPromoCode(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusOK).JSON(response)
 }
 
+func (b *BusinessAPI) OfficialReply(c *fiber.Ctx) error {
+	promoID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("promo_id " + err.Error()).ToFiber(c)
+	}
+	companyID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	request := &business.OfficialReplyRequest{}
+	if err := request.Bind(c, v); err != nil {
+		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
+	}
+	response, er := b.as.OfficialReply(companyID, promoID, request)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+func (b *BusinessAPI) DeleteOfficialReply(c *fiber.Ctx) error {
+	promoID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("promo_id " + err.Error()).ToFiber(c)
+	}
+	commentID, err := uuid.Parse(c.Params("comment_id"))
+	if err != nil {
+		return customerrors.BadRequest("comment_id " + err.Error()).ToFiber(c)
+	}
+	companyID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	if er := b.as.DeleteOfficialReply(companyID, commentID, promoID); er != nil {
+		return er.ToFiber(c)
+	}
+	return c.Status(fiber.StatusOK).JSON(
+		fiber.Map{
+			"status": "ok",
+		},
+	)
+}
+
+func (b *BusinessAPI) HideComment(c *fiber.Ctx) error {
+	return b.setCommentHidden(c, true)
+}
+
+func (b *BusinessAPI) UnhideComment(c *fiber.Ctx) error {
+	return b.setCommentHidden(c, false)
+}
+
+func (b *BusinessAPI) setCommentHidden(c *fiber.Ctx, hidden bool) error {
+	promoID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("promo_id " + err.Error()).ToFiber(c)
+	}
+	commentID, err := uuid.Parse(c.Params("comment_id"))
+	if err != nil {
+		return customerrors.BadRequest("comment_id " + err.Error()).ToFiber(c)
+	}
+	companyID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	if er := b.as.SetCommentHidden(companyID, commentID, promoID, hidden); er != nil {
+		return er.ToFiber(c)
+	}
+	return c.Status(fiber.StatusOK).JSON(
+		fiber.Map{
+			"status": "ok",
+		},
+	)
+}
+
+func (b *BusinessAPI) GetCommentHistory(c *fiber.Ctx) error {
+	promoID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("promo_id " + err.Error()).ToFiber(c)
+	}
+	commentID, err := uuid.Parse(c.Params("comment_id"))
+	if err != nil {
+		return customerrors.BadRequest("comment_id " + err.Error()).ToFiber(c)
+	}
+	companyID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	response, er := b.as.GetCommentHistory(companyID, commentID, promoID)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	c.Set("X-Total-Count", strconv.Itoa(len(response)))
+	if len(response) == 0 {
+		return c.Status(fiber.StatusOK).JSON([]fiber.Map{})
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (b *BusinessAPI) GetModerationQueue(c *fiber.Ctx) error {
+	companyID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	params := &business.GetModerationQueueQueryParams{}
+	if err := params.Bind(c, v); err != nil {
+		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
+	}
+	response, count, er := b.as.GetModerationQueue(companyID, params)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	c.Set("X-Total-Count", strconv.Itoa(count))
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (b *BusinessAPI) GetFunnel(c *fiber.Ctx) error {
+	promoID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("promo_id " + err.Error()).ToFiber(c)
+	}
+	companyID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	params := &business.FunnelQueryParams{}
+	if err := params.Bind(c, v); err != nil {
+		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
+	}
+	response, er := b.as.GetFunnel(companyID, promoID, params)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (b *BusinessAPI) GetCompanyFunnel(c *fiber.Ctx) error {
+	companyID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	params := &business.FunnelQueryParams{}
+	if err := params.Bind(c, v); err != nil {
+		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
+	}
+	response, er := b.as.GetCompanyFunnel(companyID, params)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (b *BusinessAPI) RedeemPromoCode(c *fiber.Ctx) error {
+	promoID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("promo_id " + err.Error()).ToFiber(c)
+	}
+	companyID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	request := &business.RedeemPromoRequest{}
+	if err := request.Bind(c, v); err != nil {
+		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
+	}
+	response, er := b.as.RedeemPromoCode(companyID, promoID, request)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (b *BusinessAPI) CompanyStatistic(c *fiber.Ctx) error {
+	companyID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	params := &business.CompanyStatsQueryParams{}
+	if err := params.Bind(c, v); err != nil {
+		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
+	}
+	response, er := b.as.GetCompanyStatistic(companyID, params)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (b *BusinessAPI) ExportUses(c *fiber.Ctx) error {
+	promoID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("promo_id " + err.Error()).ToFiber(c)
+	}
+	companyID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	params := &business.ExportUsesQueryParams{}
+	if err := params.Bind(c, v); err != nil {
+		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
+	}
+	response, er := b.as.ExportUses(companyID, promoID, params)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	return streamExport(c, response)
+}
+
+func (b *BusinessAPI) ExportStatistic(c *fiber.Ctx) error {
+	companyID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	params := &business.ExportStatsQueryParams{}
+	if err := params.Bind(c, v); err != nil {
+		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
+	}
+	response, er := b.as.ExportStatistic(companyID, params)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	return streamExport(c, response)
+}
+
+// streamExport sends the file as a chunked body. The status is already sent
+// when rows are written, so a failure midway only truncates the file.
+func streamExport(c *fiber.Ctx, e *business.ExportResponse) error {
+	c.Set(fiber.HeaderContentType, e.ContentType)
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="`+e.Filename+`"`)
+	c.Status(fiber.StatusOK).Context().SetBodyStreamWriter(
+		func(w *bufio.Writer) {
+			if err := e.Write(w); err != nil {
+				zap.S().Errorw("export", "file", e.Filename, "error", err)
+				return
+			}
+			_ = w.Flush()
+		},
+	)
+	return nil
+}
+
+// sseHeartbeat is how often an idle stream gets a comment line, which keeps
+// proxies from closing it and notices clients that went away.
+const sseHeartbeat = 15 * time.Second
+
+func (b *BusinessAPI) StreamPromoCode(c *fiber.Ctx) error {
+	promoID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("promo_id " + err.Error()).ToFiber(c)
+	}
+	companyID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	subscription, er := b.as.StreamPromoCode(companyID, promoID, c.Get("Last-Event-ID"))
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+	c.Status(fiber.StatusOK).Context().SetBodyStreamWriter(
+		func(w *bufio.Writer) {
+			defer subscription.Close()
+			heartbeat := time.NewTicker(sseHeartbeat)
+			defer heartbeat.Stop()
+
+			_, _ = w.WriteString("retry: 3000\n\n")
+			for _, e := range subscription.Backlog {
+				writeEvent(w, e)
+			}
+			if w.Flush() != nil {
+				return
+			}
+			for {
+				select {
+				case e, ok := <-subscription.Events:
+					if !ok {
+						return
+					}
+					writeEvent(w, e)
+				case <-heartbeat.C:
+					_, _ = w.WriteString(": heartbeat\n\n")
+				}
+				if w.Flush() != nil {
+					return
+				}
+			}
+		},
+	)
+	return nil
+}
+
+func writeEvent(w *bufio.Writer, e *promocode.Event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		zap.S().Errorw("stream event", "event", e.ID, "error", err)
+		return
+	}
+	_, _ = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+}
+
 func (b *BusinessAPI) UsageStatistic(c *fiber.Ctx) error {
 	promoID, err := uuid.Parse(c.Params("id"))
 	if err != nil {
@@ -146,7 +506,11 @@ func (b *BusinessAPI) UsageStatistic(c *fiber.Ctx) error {
 	if err != nil {
 		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
 	}
-	response, er := b.as.GetUsageStatistic(companyID, promoID)
+	params := &business.UsageStatisticQueryParams{}
+	if err := params.Bind(c, v); err != nil {
+		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
+	}
+	response, er := b.as.GetUsageStatistic(companyID, promoID, params)
 	if er != nil {
 		return er.ToFiber(c)
 	}
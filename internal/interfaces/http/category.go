@@ -0,0 +1,26 @@
+package http
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"solution/internal/application/category"
+	"strings"
+)
+
+type CategoryAPI struct {
+	as *category.ApplicationService
+}
+
+func NewCategoryAPI(as *category.ApplicationService) *CategoryAPI {
+	return &CategoryAPI{as: as}
+}
+
+func (a *CategoryAPI) GetCategories(c *fiber.Ctx) error {
+	locale := c.Query("locale")
+	if locale == "" {
+		// "ru-RU,ru;q=0.9,en;q=0.8" -> "ru"
+		locale, _, _ = strings.Cut(c.Get(fiber.HeaderAcceptLanguage), ",")
+		locale, _, _ = strings.Cut(locale, ";")
+		locale, _, _ = strings.Cut(strings.TrimSpace(locale), "-")
+	}
+	return c.Status(fiber.StatusOK).JSON(a.as.GetCategories(strings.ToLower(locale)))
+}
@@ -0,0 +1,41 @@
+package http
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+	"strings"
+	"time"
+)
+
+// notModified writes the validators and caching hints to the response and
+// reports whether the request preconditions allow a 304 Not Modified.
+// If-None-Match takes precedence over If-Modified-Since (RFC 9110 13.1.3).
+func notModified(c *fiber.Ctx, etag string, lastModified time.Time) bool {
+	c.Set(fiber.HeaderCacheControl, "private, no-cache")
+	c.Set(fiber.HeaderVary, fiber.HeaderAuthorization)
+	if etag != "" {
+		c.Set(fiber.HeaderETag, etag)
+	}
+	if !lastModified.IsZero() {
+		c.Set(fiber.HeaderLastModified, string(fasthttp.AppendHTTPDate(nil, lastModified)))
+	}
+
+	if inm := c.Get(fiber.HeaderIfNoneMatch); inm != "" {
+		return etag != "" && etagMatches(inm, etag)
+	}
+	if ims := c.Get(fiber.HeaderIfModifiedSince); ims != "" && !lastModified.IsZero() {
+		since, err := fasthttp.ParseHTTPDate([]byte(ims))
+		return err == nil && !lastModified.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
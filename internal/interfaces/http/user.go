@@ -10,6 +10,7 @@ import (
 	customerrors "solution/internal/domain/errors"
 	"solution/pkg"
 	"strconv"
+	"time"
 )
 
 type UserAPI struct {
@@ -110,17 +111,82 @@ func (u *UserAPI) GetFeed(c *fiber.Ctx) error {
 	if err := params.Bind(c, v); err != nil {
 		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
 	}
-	response, count, er := u.userAS.GetFeed(userID, params)
+	response, count, etag, er := u.userAS.GetFeed(userID, params)
 	if er != nil {
 		return er.ToFiber(c)
 	}
+	c.Set("X-Total-Count", strconv.Itoa(count))
+	if notModified(c, etag, time.Time{}) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
 	log.Info(len(response))
 	pkg.RecursiveRemoveNulls(response)
 	log.Info(len(response))
-	c.Set("X-Total-Count", strconv.Itoa(count))
 	return c.Status(fiber.StatusOK).JSON(response)
 }
 
+func (u *UserAPI) GetCompany(c *fiber.Ctx) error {
+	companyID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("company_id " + err.Error()).ToFiber(c)
+	}
+	sub, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	params := &user.GetCompanyQueryParams{}
+	if err := params.Bind(c, v); err != nil {
+		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
+	}
+	response, er := u.userAS.GetCompany(sub, companyID, params)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	pkg.RecursiveRemoveNulls(response)
+	if response["promos"] == nil {
+		response["promos"] = []fiber.Map{}
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (u *UserAPI) FollowCompany(c *fiber.Ctx) error {
+	companyID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("company_id " + err.Error()).ToFiber(c)
+	}
+	sub, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	if er := u.userAS.FollowCompany(sub, companyID); er != nil {
+		return er.ToFiber(c)
+	}
+	return c.Status(fiber.StatusOK).JSON(
+		fiber.Map{
+			"status": "ok",
+		},
+	)
+}
+
+func (u *UserAPI) UnfollowCompany(c *fiber.Ctx) error {
+	companyID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("company_id " + err.Error()).ToFiber(c)
+	}
+	sub, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	if er := u.userAS.UnfollowCompany(sub, companyID); er != nil {
+		return er.ToFiber(c)
+	}
+	return c.Status(fiber.StatusOK).JSON(
+		fiber.Map{
+			"status": "ok",
+		},
+	)
+}
+
 func (u *UserAPI) GetPromoCode(c *fiber.Ctx) error {
 	promoID, err := uuid.Parse(c.Params("id"))
 	if err != nil {
@@ -130,10 +196,13 @@ func (u *UserAPI) GetPromoCode(c *fiber.Ctx) error {
 	if err != nil {
 		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
 	}
-	response, er := u.userAS.GetPromoCode(sub, promoID)
+	response, rev, er := u.userAS.GetPromoCode(sub, promoID)
 	if er != nil {
 		return er.ToFiber(c)
 	}
+	if notModified(c, rev.ETag, rev.LastModified) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
 	pkg.RecursiveRemoveNulls(response)
 	c.Set("X-Total-Count", strconv.Itoa(len(response)))
 
@@ -180,6 +249,125 @@ func (u *UserAPI) Unlike(c *fiber.Ctx) error {
 	)
 }
 
+func (u *UserAPI) RatePromoCode(c *fiber.Ctx) error {
+	userID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	promoID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("promo_id " + err.Error()).ToFiber(c)
+	}
+	request := &user.RatePromoRequest{}
+	if err := request.Bind(c, v); err != nil {
+		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
+	}
+	response, er := u.userAS.RatePromoCode(userID, promoID, request)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (u *UserAPI) UnratePromoCode(c *fiber.Ctx) error {
+	userID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	promoID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("promo_id " + err.Error()).ToFiber(c)
+	}
+	if er := u.userAS.UnratePromoCode(userID, promoID); er != nil {
+		return er.ToFiber(c)
+	}
+	return c.Status(fiber.StatusOK).JSON(
+		fiber.Map{
+			"status": "ok",
+		},
+	)
+}
+
+func (u *UserAPI) SavePromoCode(c *fiber.Ctx) error {
+	userID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	promoID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("promo_id " + err.Error()).ToFiber(c)
+	}
+	if er := u.userAS.SavePromoCode(userID, promoID); er != nil {
+		return er.ToFiber(c)
+	}
+	return c.Status(fiber.StatusOK).JSON(
+		fiber.Map{
+			"status": "ok",
+		},
+	)
+}
+
+func (u *UserAPI) UnsavePromoCode(c *fiber.Ctx) error {
+	userID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	promoID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("promo_id " + err.Error()).ToFiber(c)
+	}
+	if er := u.userAS.UnsavePromoCode(userID, promoID); er != nil {
+		return er.ToFiber(c)
+	}
+	return c.Status(fiber.StatusOK).JSON(
+		fiber.Map{
+			"status": "ok",
+		},
+	)
+}
+
+func (u *UserAPI) GetWallet(c *fiber.Ctx) error {
+	sub, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	params := &user.GetWalletQueryParams{}
+	if err := params.Bind(c, v); err != nil {
+		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
+	}
+	response, count, er := u.userAS.GetWallet(sub, params)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	c.Set("X-Total-Count", strconv.Itoa(count))
+	if len(response) == 0 {
+		return c.Status(fiber.StatusOK).JSON([]fiber.Map{})
+	}
+	pkg.RecursiveRemoveNulls(response)
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (u *UserAPI) GetExpiringWallet(c *fiber.Ctx) error {
+	sub, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	params := &user.GetExpiringWalletQueryParams{}
+	if err := params.Bind(c, v); err != nil {
+		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
+	}
+	response, er := u.userAS.GetExpiringWallet(sub, params)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	c.Set("X-Total-Count", strconv.Itoa(len(response)))
+	if len(response) == 0 {
+		return c.Status(fiber.StatusOK).JSON([]fiber.Map{})
+	}
+	pkg.RecursiveRemoveNulls(response)
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
 func (u *UserAPI) CommentPromoCode(c *fiber.Ctx) error {
 	userID, err := uuid.Parse(c.Locals("sub").(string))
 	if err != nil {
@@ -193,7 +381,7 @@ func (u *UserAPI) CommentPromoCode(c *fiber.Ctx) error {
 	if err := request.Bind(c, v); err != nil {
 		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
 	}
-	response, er := u.userAS.CommentPromoCode(userID, promoID, request.Content)
+	response, er := u.userAS.CommentPromoCode(userID, promoID, request)
 	if er != nil {
 		return er.ToFiber(c)
 	}
@@ -210,7 +398,11 @@ func (u *UserAPI) GetPromoCodeComment(c *fiber.Ctx) error {
 	if err != nil {
 		return customerrors.BadRequest("promo_id" + err.Error())
 	}
-	response, er := u.userAS.GetComment(commentID, promoID)
+	sub, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	response, er := u.userAS.GetComment(sub, commentID, promoID)
 	if er != nil {
 		return er.ToFiber(c)
 	}
@@ -268,17 +460,115 @@ func (u *UserAPI) DeletePromoCodeComment(c *fiber.Ctx) error {
 	)
 }
 
+func (u *UserAPI) ReportPromoCodeComment(c *fiber.Ctx) error {
+	commentID, err := uuid.Parse(c.Params("comment_id"))
+	if err != nil {
+		return customerrors.BadRequest("comment_id " + err.Error()).ToFiber(c)
+	}
+	promoID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("promo_id " + err.Error()).ToFiber(c)
+	}
+	sub, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	request := &user.ReportCommentRequest{}
+	if err := request.Bind(c, v); err != nil {
+		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
+	}
+	if er := u.userAS.ReportComment(sub, commentID, promoID, request); er != nil {
+		return er.ToFiber(c)
+	}
+	return c.Status(fiber.StatusOK).JSON(
+		fiber.Map{
+			"status": "ok",
+		},
+	)
+}
+
+func (u *UserAPI) GetPromoCodeCommentHistory(c *fiber.Ctx) error {
+	commentID, err := uuid.Parse(c.Params("comment_id"))
+	if err != nil {
+		return customerrors.BadRequest("comment_id " + err.Error()).ToFiber(c)
+	}
+	promoID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("promo_id " + err.Error()).ToFiber(c)
+	}
+	sub, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	response, er := u.userAS.GetCommentHistory(sub, commentID, promoID)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	c.Set("X-Total-Count", strconv.Itoa(len(response)))
+	if len(response) == 0 {
+		return c.Status(fiber.StatusOK).JSON([]fiber.Map{})
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (u *UserAPI) ReactToPromoCodeComment(c *fiber.Ctx) error {
+	return u.setCommentReaction(c, true)
+}
+
+func (u *UserAPI) RemovePromoCodeCommentReaction(c *fiber.Ctx) error {
+	return u.setCommentReaction(c, false)
+}
+
+func (u *UserAPI) setCommentReaction(c *fiber.Ctx, add bool) error {
+	commentID, err := uuid.Parse(c.Params("comment_id"))
+	if err != nil {
+		return customerrors.BadRequest("comment_id " + err.Error()).ToFiber(c)
+	}
+	promoID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("promo_id " + err.Error()).ToFiber(c)
+	}
+	sub, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	var er *customerrors.DomainError
+	if add {
+		er = u.userAS.ReactToComment(sub, commentID, promoID, c.Params("reaction"))
+	} else {
+		er = u.userAS.RemoveCommentReaction(sub, commentID, promoID, c.Params("reaction"))
+	}
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	return c.Status(fiber.StatusOK).JSON(
+		fiber.Map{
+			"status": "ok",
+		},
+	)
+}
+
 func (u *UserAPI) GetPromoCodeComments(c *fiber.Ctx) error {
 	promoID, err := uuid.Parse(c.Params("id"))
 	if err != nil {
 		return customerrors.BadRequest("promo_id" + err.Error())
 	}
-	response, er := u.userAS.GetPromoCodeComments(promoID)
+	sub, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	params := &user.GetCommentsQueryParams{}
+	if err := params.Bind(c, v); err != nil {
+		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
+	}
+	response, count, er := u.userAS.GetPromoCodeComments(sub, promoID, params)
 	if er != nil {
 		return er.ToFiber(c)
 	}
-	c.Set("X-Total-Count", strconv.Itoa(len(response)))
-	pkg.RecursiveRemoveNulls(response)
+	c.Set("X-Total-Count", strconv.Itoa(count))
+	if len(response) == 0 {
+		return c.Status(fiber.StatusOK).JSON([]fiber.Map{})
+	}
 	return c.Status(fiber.StatusOK).JSON(response)
 }
 
@@ -0,0 +1,149 @@
+package http
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"solution/internal/application/webhook"
+	customerrors "solution/internal/domain/errors"
+	"strconv"
+)
+
+type WebhookAPI struct {
+	as *webhook.ApplicationService
+}
+
+func NewWebhookAPI(as *webhook.ApplicationService) *WebhookAPI {
+	return &WebhookAPI{as: as}
+}
+
+func (a *WebhookAPI) CreateWebhook(c *fiber.Ctx) error {
+	companyID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	request := &webhook.CreateWebhookRequest{}
+	if err := request.Bind(c, v); err != nil {
+		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
+	}
+	response, er := a.as.CreateWebhook(companyID, request)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+func (a *WebhookAPI) GetWebhooks(c *fiber.Ctx) error {
+	companyID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	return c.Status(fiber.StatusOK).JSON(a.as.GetWebhooks(companyID))
+}
+
+func (a *WebhookAPI) GetWebhook(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("id " + err.Error()).ToFiber(c)
+	}
+	companyID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	response, er := a.as.GetWebhook(companyID, id)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (a *WebhookAPI) EditWebhook(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("id " + err.Error()).ToFiber(c)
+	}
+	companyID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	request := &webhook.EditWebhookRequest{}
+	if err := request.Bind(c, v); err != nil {
+		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
+	}
+	response, er := a.as.EditWebhook(companyID, id, request)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (a *WebhookAPI) DeleteWebhook(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("id " + err.Error()).ToFiber(c)
+	}
+	companyID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	if er := a.as.DeleteWebhook(companyID, id); er != nil {
+		return er.ToFiber(c)
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "ok"})
+}
+
+func (a *WebhookAPI) TestWebhook(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("id " + err.Error()).ToFiber(c)
+	}
+	companyID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	response, er := a.as.TestWebhook(c.Context(), companyID, id)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (a *WebhookAPI) GetDeliveries(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("id " + err.Error()).ToFiber(c)
+	}
+	companyID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	params := &webhook.GetDeliveriesQueryParams{}
+	if err := params.Bind(c, v); err != nil {
+		return customerrors.BadRequest("req " + err.Error()).ToFiber(c)
+	}
+	response, count, er := a.as.GetDeliveries(companyID, id, params)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	c.Set("X-Total-Count", strconv.Itoa(count))
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+func (a *WebhookAPI) RetryDelivery(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return customerrors.BadRequest("id " + err.Error()).ToFiber(c)
+	}
+	deliveryID, err := uuid.Parse(c.Params("delivery_id"))
+	if err != nil {
+		return customerrors.BadRequest("delivery_id " + err.Error()).ToFiber(c)
+	}
+	companyID, err := uuid.Parse(c.Locals("sub").(string))
+	if err != nil {
+		return customerrors.BadRequest("sub " + err.Error()).ToFiber(c)
+	}
+	response, er := a.as.RetryDelivery(companyID, id, deliveryID)
+	if er != nil {
+		return er.ToFiber(c)
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
+}
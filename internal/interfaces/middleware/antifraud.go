@@ -1,88 +1,212 @@
 package middleware
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/log"
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
-	"net/http"
+	"solution/internal/domain/antifraud"
 	customerrors "solution/internal/domain/errors"
-	"strings"
 	"time"
 )
 
-type RequestForAntiFraud struct {
-	Email   string    `json:"user_email" validate:"required,email"`
-	PromoID uuid.UUID `json:"promo_id" validate:"required"`
+// Outcome is what an antifraud check decided about an activation.
+type Outcome string
+
+const (
+	Allow Outcome = antifraud.OutcomeAllow
+	// Review puts the activation on hold until the company approves or
+	// rejects it.
+	Review Outcome = antifraud.OutcomeReview
+	Deny   Outcome = antifraud.OutcomeDeny
+)
+
+// severity orders outcomes so the strictest one wins in a chain.
+func (o Outcome) severity() int {
+	switch o {
+	case Allow:
+		return 0
+	case Review:
+		return 1
+	default:
+		return 2
+	}
 }
 
-func AntiFraudForbidden(c *fiber.Ctx) error {
-	return c.Status(fiber.StatusForbidden).JSON(
-		fiber.Map{
-			"message": "forbidden",
-			"debug":   "forbidden by antifraud service",
-		},
-	)
+type AntiFraudRequest struct {
+	UserID  uuid.UUID
+	Email   string
+	IP      string
+	PromoID uuid.UUID
 }
 
-type AntiFraudServiceResponse struct {
-	Ok         bool   `json:"ok"`
-	CacheUntil string `json:"cache_until,omitempty"`
+// Verdict is the decision of a checker. CacheUntil, when set, is how long an
+// Allow may be reused for the same user. Raw is the response of an external
+// service, kept for the audit log. Hits are the rules that did not allow the
+// activation, shadow rules included.
+type Verdict struct {
+	Outcome    Outcome
+	Reason     string
+	CacheUntil time.Time
+	Raw        string
+	Hits       []RuleHit
 }
-type AntiFraudServiceRequest struct {
-	Email   string    `json:"user_email" validate:"required,email"`
-	PromoID uuid.UUID `json:"promo_id" validate:"required"`
+
+// RuleHit is a rule that wanted to review or deny the activation. Shadow
+// rules are only evaluated, their outcome doesn't count.
+type RuleHit struct {
+	Rule    string
+	Outcome Outcome
+	Reason  string
+	Shadow  bool
 }
 
-type antiFraudService struct {
-	url    string
-	client *http.Client
+// AntiFraudChecker decides whether an activation goes through. An error means
+// the checker could not decide.
+type AntiFraudChecker interface {
+	Check(ctx context.Context, r *AntiFraudRequest) (*Verdict, error)
 }
 
-func (a *antiFraudService) sendToService(user string, promo uuid.UUID) (*AntiFraudServiceResponse, error) {
-	body := AntiFraudServiceRequest{
-		user,
-		promo,
-	}
-	jsonBody, _ := json.Marshal(body)
+// ChainLink is a checker in a chain. Shadow checkers are evaluated and their
+// hits recorded, but they don't affect the verdict and their errors are only
+// logged.
+type ChainLink struct {
+	Name    string
+	Checker AntiFraudChecker
+	Shadow  bool
+}
 
-	req, _ := http.NewRequest("POST", a.url, bytes.NewBuffer(jsonBody))
-	req.Header.Set("Content-Type", "application/json")
+// Chain runs checkers in order and returns the strictest verdict; after a
+// Deny only shadow checkers run. An Allow is cacheable only as long as every
+// checker said it may be. The raw response is that of the checker the
+// outcome came from, or of the last one that had one. Hits are named after
+// the checker and, if it has several, the rule.
+type Chain []ChainLink
 
-	response, err := a.client.Do(req)
-	if err != nil {
-		zap.S().Error(err)
-		return nil, err
+func (ch Chain) Check(ctx context.Context, r *AntiFraudRequest) (*Verdict, error) {
+	result := &Verdict{Outcome: Allow}
+	first := true
+	for _, link := range ch {
+		if !link.Shadow && result.Outcome == Deny {
+			continue
+		}
+		v, err := link.Checker.Check(ctx, r)
+		if err != nil {
+			if link.Shadow {
+				zap.S().Warnw("antifraud shadow checker", "checker", link.Name, "error", err)
+				continue
+			}
+			return nil, err
+		}
+		result.Hits = append(result.Hits, link.hits(v)...)
+		if link.Shadow {
+			continue
+		}
+		if v.Outcome.severity() > result.Outcome.severity() {
+			result.Outcome = v.Outcome
+			result.Reason = v.Reason
+			result.Raw = v.Raw
+		} else if v.Raw != "" && result.Outcome == Allow {
+			result.Raw = v.Raw
+		}
+		if first || (!result.CacheUntil.IsZero() && v.CacheUntil.Before(result.CacheUntil)) {
+			result.CacheUntil = v.CacheUntil
+		}
+		first = false
 	}
-	if response.StatusCode != 200 {
-		response, _ = a.client.Do(req)
+	if result.Outcome != Allow {
+		result.CacheUntil = time.Time{}
 	}
-	defer response.Body.Close()
-	zap.S().Debugw(
-		"response",
-		"body", response.Body,
-		"status", response.StatusCode,
-		"request", response.Request.Body,
-		"remoteAddr", response.Request.RequestURI,
-	)
-	antiFraudResp := &AntiFraudServiceResponse{}
-	_ = json.NewDecoder(response.Body).Decode(antiFraudResp)
-	return antiFraudResp, nil
+	return result, nil
 }
 
-func AntiFraud(redisURL string, serviceURL string) fiber.Handler {
-	if !strings.Contains(serviceURL, "://") {
-		serviceURL = "http://" + serviceURL
+// Shadows returns the shadow links of the chain.
+func (ch Chain) Shadows() Chain {
+	var result Chain
+	for _, l := range ch {
+		if l.Shadow {
+			result = append(result, l)
+		}
 	}
-	service := &antiFraudService{
-		url:    serviceURL + "/api/validate",
-		client: &http.Client{},
+	return result
+}
+
+func (l ChainLink) hits(v *Verdict) []RuleHit {
+	hits := v.Hits
+	if len(hits) == 0 && v.Outcome != Allow {
+		hits = []RuleHit{{Outcome: v.Outcome, Reason: v.Reason}}
 	}
-	client := redis.NewClient(&redis.Options{Addr: redisURL})
+	result := make([]RuleHit, 0, len(hits))
+	for _, h := range hits {
+		rule := l.Name
+		if h.Rule != "" {
+			rule += ":" + h.Rule
+		}
+		h.Rule, h.Shadow = rule, h.Shadow || l.Shadow
+		if h.Shadow {
+			zap.S().Infow("antifraud shadow hit", "rule", h.Rule, "outcome", h.Outcome, "reason", h.Reason)
+		}
+		result = append(result, h)
+	}
+	return result
+}
+
+// AntiFraudForbidden answers a blocked activation with the id of the
+// decision, for the user to quote to support.
+func AntiFraudForbidden(c *fiber.Ctx, decision uuid.UUID) error {
+	return c.Status(fiber.StatusForbidden).JSON(
+		fiber.Map{
+			"message":     "forbidden",
+			"debug":       "forbidden by antifraud service",
+			"decision_id": decision,
+		},
+	)
+}
+
+// antiFraudHeld answers an activation put on hold for review.
+func antiFraudHeld(c *fiber.Ctx, hold *antifraud.Hold) error {
+	return c.Status(fiber.StatusAccepted).JSON(
+		fiber.Map{
+			"status":      "pending_review",
+			"hold_id":     hold.ID,
+			"decision_id": hold.DecisionID,
+		},
+	)
+}
+
+// FailsOpen tells whether activations of the promo code go through when the
+// checker can't decide.
+type FailsOpen func(ctx context.Context, promo uuid.UUID) bool
+
+// Verdicts are cached per user and promo code, or per user for services
+// whose verdicts don't depend on the promo code.
+const (
+	CacheByUserPromo = "user_promo"
+	CacheByUser      = "user"
+)
+
+type AntiFraudOptions struct {
+	FailsOpen FailsOpen
+	// CacheBy is CacheByUserPromo or CacheByUser.
+	CacheBy string
+	// DenyTTL is how long a Deny is cached; zero disables negative caching.
+	DenyTTL time.Duration
+	// MaxCacheTTL caps the cache_until of Allow verdicts.
+	MaxCacheTTL time.Duration
+	// Decisions records every decision and holds activations for review.
+	Decisions *antifraud.DomainService
+	// Shadow runs on cache hits, where the checker doesn't, so shadow
+	// checkers see every activation; see Chain.Shadows.
+	Shadow Chain
+}
+
+// AntiFraud asks checker about every activation. Verdicts are cached in redis,
+// Allow for as long as the verdict said and Deny for DenyTTL. When the
+// checker fails, FailsOpen decides. Every decision is recorded, and Review
+// puts the activation on hold.
+func AntiFraud(client *redis.Client, checker AntiFraudChecker, options AntiFraudOptions) fiber.Handler {
 	for {
 		if client.Ping(context.Background()).Err() == nil {
 			break
@@ -95,28 +219,112 @@ func AntiFraud(redisURL string, serviceURL string) fiber.Handler {
 		if err != nil {
 			return customerrors.BadRequest("promo_id" + err.Error())
 		}
-		request := &RequestForAntiFraud{
+		sub, _ := uuid.Parse(c.Locals("sub").(string))
+		request := &AntiFraudRequest{
+			UserID:  sub,
 			Email:   c.Locals("email").(string),
+			IP:      c.IP(),
 			PromoID: pID,
 		}
-		err = client.Get(c.Context(), request.Email).Err()
-		if err == nil {
-			return c.Next()
+		decision := &antifraud.Decision{
+			PromoCodeID: pID,
+			UserID:      sub,
+			Email:       request.Email,
 		}
-		res, err := service.sendToService(request.Email, request.PromoID)
-		if err != nil {
-			zap.S().Info(err)
-			return AntiFraudForbidden(c)
+
+		key := verdictKey(options.CacheBy, request)
+		if cached, err := client.Get(c.Context(), key).Result(); err == nil {
+			antifraudStats.Add("cache_hits", 1)
+			decision.Outcome, decision.Source = cached, antifraud.SourceCache
+			decision.Reason = "cached verdict"
+			if len(options.Shadow) > 0 {
+				// Shadow links never fail, their errors are only logged.
+				v, _ := options.Shadow.Check(c.Context(), request)
+				decision.Hits = decisionHits(v.Hits)
+			}
+			options.Decisions.Record(decision)
+			if Outcome(cached) == Allow {
+				return c.Next()
+			}
+			return AntiFraudForbidden(c, decision.ID)
 		}
-		if !res.Ok { // forbidden
-			zap.S().Info(err)
-			return AntiFraudForbidden(c)
+
+		start := time.Now()
+		verdict, err := checker.Check(c.Context(), request)
+		decision.LatencyMS = time.Since(start).Milliseconds()
+		if err != nil {
+			open := options.FailsOpen(c.Context(), pID)
+			zap.S().Warnw("antifraud unavailable", "promo", pID, "fail_open", open, "error", err)
+			verdict = &Verdict{Outcome: Deny, Reason: err.Error()}
+			decision.Source = antifraud.SourceUnavailable
+			if open {
+				antifraudStats.Add("failed_open", 1)
+				verdict.Outcome = Allow
+			} else {
+				antifraudStats.Add("failed_closed", 1)
+			}
+		} else {
+			decision.Source = antifraud.SourceChecker
+			if ttl := verdictTTL(verdict, options); ttl > 0 {
+				client.Set(c.Context(), key, string(verdict.Outcome), ttl)
+			}
 		}
-		if res.CacheUntil == "" {
+		decision.Outcome, decision.Reason, decision.Raw = string(verdict.Outcome), verdict.Reason, verdict.Raw
+		decision.Hits = decisionHits(verdict.Hits)
+		options.Decisions.Record(decision)
+
+		switch verdict.Outcome {
+		case Allow:
 			return c.Next()
+		case Review:
+			hold, err := options.Decisions.Hold(decision)
+			if err != nil {
+				return err.ToFiber(c)
+			}
+			return antiFraudHeld(c, hold)
+		}
+		zap.S().Infow("antifraud", "outcome", verdict.Outcome, "reason", verdict.Reason, "promo", pID)
+		return AntiFraudForbidden(c, decision.ID)
+	}
+}
+
+func decisionHits(hits []RuleHit) []antifraud.RuleHit {
+	result := make([]antifraud.RuleHit, 0, len(hits))
+	for _, h := range hits {
+		result = append(
+			result, antifraud.RuleHit{
+				Rule:    h.Rule,
+				Outcome: string(h.Outcome),
+				Reason:  h.Reason,
+				Shadow:  h.Shadow,
+			},
+		)
+	}
+	return result
+}
+
+func verdictKey(cacheBy string, r *AntiFraudRequest) string {
+	if cacheBy == CacheByUser {
+		return "antifraud:verdict:" + r.UserID.String()
+	}
+	return "antifraud:verdict:" + r.UserID.String() + ":" + r.PromoID.String()
+}
+
+// verdictTTL is how long the verdict may be cached; zero means not at all.
+// Review verdicts are never cached, so every activation is held.
+func verdictTTL(v *Verdict, options AntiFraudOptions) time.Duration {
+	switch v.Outcome {
+	case Allow:
+		if v.CacheUntil.IsZero() {
+			return 0
+		}
+		ttl := time.Until(v.CacheUntil)
+		if options.MaxCacheTTL > 0 {
+			ttl = min(ttl, options.MaxCacheTTL)
 		}
-		t, _ := time.Parse(time.RFC3339, res.CacheUntil)
-		client.Set(c.Context(), request.Email, request.PromoID, time.Until(t))
-		return c.Next()
+		return max(ttl, 0)
+	case Deny:
+		return options.DenyTTL
 	}
+	return 0
 }
@@ -0,0 +1,219 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"solution/internal/pkg/breaker"
+	"strings"
+	"time"
+)
+
+var antifraudStats = expvar.NewMap("antifraud")
+
+// latencyBuckets are the upper bounds of the cumulative latency histogram, in
+// milliseconds.
+var latencyBuckets = []int64{10, 25, 50, 100, 250, 500, 1000, 2500}
+
+type AntiFraudServiceResponse struct {
+	Ok         *bool  `json:"ok"`
+	CacheUntil string `json:"cache_until,omitempty"`
+	raw        string
+}
+
+// maxResponseSize bounds the response read from the service.
+const maxResponseSize = 64 << 10
+
+type AntiFraudServiceRequest struct {
+	Email   string    `json:"user_email" validate:"required,email"`
+	PromoID uuid.UUID `json:"promo_id" validate:"required"`
+}
+
+type HTTPCheckerOptions struct {
+	// Timeout bounds every attempt.
+	Timeout time.Duration
+	// Retries is how many times a failed attempt is repeated. Only network
+	// errors, 5xx and 429 are retried.
+	Retries int
+	// Retries wait a random time up to Backoff, doubling per retry up to
+	// BackoffMax.
+	Backoff    time.Duration
+	BackoffMax time.Duration
+	// The breaker opens after BreakerThreshold failed checks in a row and
+	// lets a probe through after BreakerCooldown.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// HTTPChecker asks the external antifraud service's /api/validate.
+type HTTPChecker struct {
+	url     string
+	client  *http.Client
+	options HTTPCheckerOptions
+	breaker *breaker.Breaker
+}
+
+// errRetryable marks failures worth another attempt.
+type errRetryable struct{ error }
+
+func (e errRetryable) Unwrap() error { return e.error }
+
+func NewHTTPChecker(address string, options HTTPCheckerOptions) *HTTPChecker {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	state := new(expvar.String)
+	state.Set(breaker.Closed.String())
+	antifraudStats.Set("breaker_state", state)
+	b := breaker.New(options.BreakerThreshold, options.BreakerCooldown)
+	b.OnChange = func(s breaker.State) {
+		state.Set(s.String())
+		if s == breaker.Open {
+			antifraudStats.Add("breaker_opened", 1)
+		}
+	}
+	return &HTTPChecker{
+		url:     address + "/api/validate",
+		client:  &http.Client{},
+		options: options,
+		breaker: b,
+	}
+}
+
+func (a *HTTPChecker) Check(ctx context.Context, r *AntiFraudRequest) (*Verdict, error) {
+	if !a.breaker.Allow() {
+		antifraudStats.Add("rejected_open", 1)
+		return nil, breaker.ErrOpen
+	}
+	res, err := a.sendToService(ctx, r.Email, r.PromoID)
+	var retryable errRetryable
+	switch {
+	case err == nil:
+		a.breaker.Success()
+	case ctx.Err() != nil:
+		// The caller gave up, which tells nothing about the service.
+		a.breaker.Ignore()
+		antifraudStats.Add("canceled", 1)
+		return nil, err
+	case errors.As(err, &retryable):
+		// The service is unreachable or failing.
+		a.breaker.Failure()
+		antifraudStats.Add("errors", 1)
+		return nil, err
+	default:
+		// The service answered, if not as expected; another attempt would
+		// get the same, so it doesn't count against it.
+		a.breaker.Ignore()
+		antifraudStats.Add("errors", 1)
+		return nil, err
+	}
+	if !*res.Ok {
+		return &Verdict{Outcome: Deny, Reason: "rejected by antifraud service", Raw: res.raw}, nil
+	}
+	v := &Verdict{Outcome: Allow, Raw: res.raw}
+	if res.CacheUntil != "" {
+		// A cache_until that can't be read or is already over just means
+		// the verdict isn't cached.
+		until, err := time.Parse(time.RFC3339, res.CacheUntil)
+		switch {
+		case err != nil:
+			antifraudStats.Add("invalid_cache_until", 1)
+			zap.S().Warnw("antifraud: invalid cache_until", "value", res.CacheUntil, "error", err)
+		case until.After(time.Now()):
+			v.CacheUntil = until
+		}
+	}
+	return v, nil
+}
+
+func (a *HTTPChecker) sendToService(ctx context.Context, user string, promo uuid.UUID) (
+	*AntiFraudServiceResponse,
+	error,
+) {
+	body, _ := json.Marshal(AntiFraudServiceRequest{user, promo})
+	for attempt := 0; ; attempt++ {
+		res, err := a.attempt(ctx, body)
+		var retryable errRetryable
+		if err == nil || !errors.As(err, &retryable) || attempt >= a.options.Retries {
+			return res, err
+		}
+		antifraudStats.Add("retries", 1)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(a.backoff(attempt)):
+		}
+	}
+}
+
+func (a *HTTPChecker) attempt(ctx context.Context, body []byte) (*AntiFraudServiceResponse, error) {
+	if a.options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.options.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", a.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	antifraudStats.Add("requests", 1)
+	start := time.Now()
+	response, err := a.client.Do(req)
+	observeLatency(time.Since(start))
+	if err != nil {
+		return nil, errRetryable{err}
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests {
+		return nil, errRetryable{fmt.Errorf("antifraud service: status %d", response.StatusCode)}
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("antifraud service: status %d", response.StatusCode)
+	}
+	raw, err := io.ReadAll(io.LimitReader(response.Body, maxResponseSize))
+	if err != nil {
+		return nil, errRetryable{err}
+	}
+	res := &AntiFraudServiceResponse{raw: string(raw)}
+	if err := json.Unmarshal(raw, res); err != nil {
+		return nil, fmt.Errorf("antifraud service: %w", err)
+	}
+	if res.Ok == nil {
+		return nil, errors.New("antifraud service: no verdict in response")
+	}
+	return res, nil
+}
+
+// backoff returns a random delay up to the exponential backoff of the
+// attempt, so clients retrying together spread out.
+func (a *HTTPChecker) backoff(attempt int) time.Duration {
+	if a.options.Backoff <= 0 {
+		return 0
+	}
+	d := a.options.Backoff << attempt
+	if a.options.BackoffMax > 0 && (d <= 0 || d > a.options.BackoffMax) {
+		d = a.options.BackoffMax
+	}
+	return rand.N(d)
+}
+
+func observeLatency(d time.Duration) {
+	ms := d.Milliseconds()
+	antifraudStats.Add("latency_ms_total", ms)
+	for _, le := range latencyBuckets {
+		if ms <= le {
+			antifraudStats.Add(fmt.Sprintf("latency_ms_le_%d", le), 1)
+		}
+	}
+	antifraudStats.Add("latency_ms_le_inf", 1)
+}
@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"net/http"
+	"solution/internal/pkg/antifraudstub"
+	"solution/internal/pkg/breaker"
+	"testing"
+	"time"
+)
+
+var testCheckerOptions = HTTPCheckerOptions{
+	Timeout:          100 * time.Millisecond,
+	Retries:          2,
+	Backoff:          5 * time.Millisecond,
+	BackoffMax:       20 * time.Millisecond,
+	BreakerThreshold: 2,
+	BreakerCooldown:  200 * time.Millisecond,
+}
+
+var serverError = antifraudstub.Response{Status: http.StatusInternalServerError}
+
+// newTestChecker returns a checker asking a fresh stub, which allows
+// everyone once its script is over.
+func newTestChecker(t *testing.T) (*HTTPChecker, *antifraudstub.Stub) {
+	t.Helper()
+	stub := antifraudstub.New(antifraudstub.OK(time.Time{}))
+	server := stub.Start()
+	t.Cleanup(server.Close)
+	return NewHTTPChecker(server.URL, testCheckerOptions), stub
+}
+
+func testRequest() *AntiFraudRequest {
+	return &AntiFraudRequest{UserID: uuid.New(), Email: "user@example.com", PromoID: uuid.New()}
+}
+
+func expectRequests(t *testing.T, stub *antifraudstub.Stub, n int) {
+	t.Helper()
+	if got := len(stub.Requests()); got != n {
+		t.Errorf("%d requests, want %d", got, n)
+	}
+}
+
+func TestHTTPCheckerVerdicts(t *testing.T) {
+	c, stub := newTestChecker(t)
+
+	stub.Script(antifraudstub.OK(time.Now().Add(time.Minute)))
+	v, err := c.Check(context.Background(), testRequest())
+	if err != nil || v.Outcome != Allow || v.CacheUntil.IsZero() {
+		t.Errorf("allow with cache_until: got %+v, %v", v, err)
+	}
+
+	stub.Script(antifraudstub.Deny())
+	v, err = c.Check(context.Background(), testRequest())
+	if err != nil || v.Outcome != Deny {
+		t.Errorf("deny: got %+v, %v", v, err)
+	}
+}
+
+func TestHTTPCheckerErrors(t *testing.T) {
+	slow := antifraudstub.OK(time.Time{})
+	slow.Delay = time.Second
+	tests := []struct {
+		name     string
+		script   []antifraudstub.Response
+		requests int
+	}{
+		{"empty body is an error, not a deny", []antifraudstub.Response{{Status: http.StatusOK}}, 1},
+		{"4xx is not retried", []antifraudstub.Response{{Status: http.StatusBadRequest}}, 1},
+		{"retries are bounded", []antifraudstub.Response{serverError, serverError, serverError}, 3},
+		{"slow answers time out", []antifraudstub.Response{slow, slow, slow}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				c, stub := newTestChecker(t)
+				stub.Script(tt.script...)
+				start := time.Now()
+				if v, err := c.Check(context.Background(), testRequest()); err == nil {
+					t.Errorf("got %+v, want an error", v)
+				}
+				if took := time.Since(start); took > 500*time.Millisecond {
+					t.Errorf("took %s", took)
+				}
+				expectRequests(t, stub, tt.requests)
+			},
+		)
+	}
+}
+
+func TestHTTPCheckerRetries5xx(t *testing.T) {
+	c, stub := newTestChecker(t)
+	stub.Script(serverError, serverError)
+	v, err := c.Check(context.Background(), testRequest())
+	if err != nil || v.Outcome != Allow {
+		t.Errorf("got %+v, %v", v, err)
+	}
+	expectRequests(t, stub, 3)
+}
+
+func TestHTTPCheckerBreakerOpensAndRecovers(t *testing.T) {
+	c, stub := newTestChecker(t)
+	for i := 0; i < testCheckerOptions.BreakerThreshold; i++ {
+		stub.Script(serverError, serverError, serverError)
+		if _, err := c.Check(context.Background(), testRequest()); err == nil {
+			t.Fatal("failure not reported")
+		}
+	}
+	stub.Reset()
+	if _, err := c.Check(context.Background(), testRequest()); !errors.Is(err, breaker.ErrOpen) {
+		t.Fatalf("breaker not open: %v", err)
+	}
+	expectRequests(t, stub, 0)
+
+	time.Sleep(testCheckerOptions.BreakerCooldown)
+	if v, err := c.Check(context.Background(), testRequest()); err != nil || v.Outcome != Allow {
+		t.Errorf("probe: got %+v, %v", v, err)
+	}
+	if v, err := c.Check(context.Background(), testRequest()); err != nil || v.Outcome != Allow {
+		t.Errorf("after probe: got %+v, %v", v, err)
+	}
+}
+
+// Only an unreachable or failing service counts against the breaker.
+func TestHTTPCheckerBreakerIgnoresOtherErrors(t *testing.T) {
+	c, stub := newTestChecker(t)
+	for i := 0; i < 2*testCheckerOptions.BreakerThreshold; i++ {
+		stub.Script(antifraudstub.Response{Status: http.StatusBadRequest})
+		c.Check(context.Background(), testRequest())
+		stub.Script(antifraudstub.Response{Status: http.StatusOK, Body: "not json"})
+		c.Check(context.Background(), testRequest())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := c.Check(ctx, testRequest()); !errors.Is(err, context.Canceled) {
+			t.Errorf("canceled: got %v", err)
+		}
+	}
+	if got := c.breaker.State(); got != breaker.Closed {
+		t.Errorf("breaker is %s, want closed", got)
+	}
+}
@@ -0,0 +1,212 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gopkg.in/yaml.v3"
+	"strings"
+	"time"
+)
+
+// VelocityRule limits activation attempts per key within a fixed window.
+// Key is one of user, ip, promo and user_promo. Name defaults to
+// velocity_<key>_<window> and is unique; every rule has its own counters.
+type VelocityRule struct {
+	Name    string        `yaml:"name"`
+	Key     string        `yaml:"key"`
+	Limit   int64         `yaml:"limit"`
+	Window  time.Duration `yaml:"window"`
+	Outcome Outcome       `yaml:"outcome"`
+	Shadow  bool          `yaml:"shadow"`
+}
+
+// NewAccountRule applies to accounts younger than MinAge.
+type NewAccountRule struct {
+	MinAge  time.Duration `yaml:"min_age"`
+	Outcome Outcome       `yaml:"outcome"`
+	Shadow  bool          `yaml:"shadow"`
+}
+
+// AntiFraudRules configure the rules engine. Shadow rules, and email domains
+// in ShadowEmailDomains, are evaluated and recorded without being enforced.
+type AntiFraudRules struct {
+	Velocity            []VelocityRule  `yaml:"velocity"`
+	NewAccounts         *NewAccountRule `yaml:"new_accounts"`
+	BlockedEmailDomains []string        `yaml:"blocked_email_domains"`
+	ShadowEmailDomains  []string        `yaml:"shadow_email_domains"`
+}
+
+// Names of the rules besides velocity ones.
+const (
+	ruleNewAccount    = "new_account"
+	ruleBlockedDomain = "blocked_email_domain"
+	ruleShadowDomain  = "shadow_email_domain"
+)
+
+// ParseAntiFraudRules reads rules from YAML. Outcomes default to deny.
+func ParseAntiFraudRules(data []byte) (*AntiFraudRules, error) {
+	rules := &AntiFraudRules{}
+	if err := yaml.Unmarshal(data, rules); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(rules.Velocity))
+	for i := range rules.Velocity {
+		r := &rules.Velocity[i]
+		switch r.Key {
+		case "user", "ip", "promo", "user_promo":
+		default:
+			return nil, fmt.Errorf("velocity rule %d: unknown key %q", i, r.Key)
+		}
+		if r.Limit <= 0 || r.Window <= 0 {
+			return nil, fmt.Errorf("velocity rule %d: limit and window must be positive", i)
+		}
+		if r.Name == "" {
+			r.Name = "velocity_" + r.Key + "_" + shortDuration(r.Window)
+		}
+		var err error
+		if r.Outcome, err = parseOutcome(r.Outcome); err != nil {
+			return nil, fmt.Errorf("velocity rule %q: %w", r.Name, err)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("velocity rule %d: duplicate name %q", i, r.Name)
+		}
+		names[r.Name] = true
+	}
+	if rules.NewAccounts != nil {
+		var err error
+		if rules.NewAccounts.Outcome, err = parseOutcome(rules.NewAccounts.Outcome); err != nil {
+			return nil, fmt.Errorf("new_accounts: %w", err)
+		}
+	}
+	for _, domains := range [][]string{rules.BlockedEmailDomains, rules.ShadowEmailDomains} {
+		for i, d := range domains {
+			domains[i] = strings.ToLower(strings.TrimPrefix(d, "@"))
+		}
+	}
+	return rules, nil
+}
+
+// shortDuration prints 1h for 1h0m0s.
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+// parseOutcome defaults the outcome of a rule to deny. A rule can't allow:
+// it would never change a verdict.
+func parseOutcome(o Outcome) (Outcome, error) {
+	switch o {
+	case "":
+		return Deny, nil
+	case Review, Deny:
+		return o, nil
+	}
+	return "", fmt.Errorf("outcome %q must be review or deny", o)
+}
+
+// AccountCreated returns when the user registered, or false when it's not
+// known.
+type AccountCreated func(ctx context.Context, user uuid.UUID) (time.Time, bool)
+
+// RulesChecker is the built-in antifraud engine. Velocity counters live in
+// redis, so the limits hold across instances.
+type RulesChecker struct {
+	rules   *AntiFraudRules
+	redis   *redis.Client
+	created AccountCreated
+	blocked map[string]bool
+	shadow  map[string]bool
+}
+
+func NewRulesChecker(rules *AntiFraudRules, client *redis.Client, created AccountCreated) *RulesChecker {
+	set := func(domains []string) map[string]bool {
+		m := make(map[string]bool, len(domains))
+		for _, d := range domains {
+			m[d] = true
+		}
+		return m
+	}
+	return &RulesChecker{
+		rules:   rules,
+		redis:   client,
+		created: created,
+		blocked: set(rules.BlockedEmailDomains),
+		shadow:  set(rules.ShadowEmailDomains),
+	}
+}
+
+// Check evaluates every rule, so shadow rules are recorded even when an
+// enforced one already denied.
+func (c *RulesChecker) Check(ctx context.Context, r *AntiFraudRequest) (*Verdict, error) {
+	verdict := &Verdict{Outcome: Allow}
+	hit := func(rule string, o Outcome, reason string, shadow bool) {
+		verdict.Hits = append(verdict.Hits, RuleHit{Rule: rule, Outcome: o, Reason: reason, Shadow: shadow})
+		if !shadow && o.severity() > verdict.Outcome.severity() {
+			verdict.Outcome = o
+			verdict.Reason = reason
+		}
+	}
+
+	if at := strings.LastIndexByte(r.Email, '@'); at >= 0 {
+		domain := strings.ToLower(r.Email[at+1:])
+		if c.blocked[domain] {
+			hit(ruleBlockedDomain, Deny, "blocked email domain", false)
+		}
+		if c.shadow[domain] {
+			hit(ruleShadowDomain, Deny, "blocked email domain", true)
+		}
+	}
+
+	if rule := c.rules.NewAccounts; rule != nil && c.created != nil {
+		if created, ok := c.created(ctx, r.UserID); ok && time.Since(created) < rule.MinAge {
+			hit(ruleNewAccount, rule.Outcome, "new account", rule.Shadow)
+		}
+	}
+
+	for _, rule := range c.rules.Velocity {
+		n, err := c.count(ctx, rule, r)
+		if err != nil {
+			return nil, err
+		}
+		if n > rule.Limit {
+			hit(rule.Name, rule.Outcome, "too many activations per "+rule.Key, rule.Shadow)
+		}
+	}
+	return verdict, nil
+}
+
+// count adds the attempt to the current window of the rule and returns the
+// number of attempts in it.
+func (c *RulesChecker) count(ctx context.Context, rule VelocityRule, r *AntiFraudRequest) (int64, error) {
+	var subject string
+	switch rule.Key {
+	case "user":
+		subject = r.UserID.String()
+	case "ip":
+		subject = r.IP
+	case "promo":
+		subject = r.PromoID.String()
+	case "user_promo":
+		subject = r.UserID.String() + ":" + r.PromoID.String()
+	}
+	window := time.Now().UnixNano() / int64(rule.Window)
+	key := fmt.Sprintf(
+		"antifraud:velocity:%s:%s:%d:%s:%d", rule.Name, rule.Key, int64(rule.Window.Seconds()), subject, window,
+	)
+
+	pipe := c.redis.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, rule.Window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
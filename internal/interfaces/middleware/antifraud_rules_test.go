@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseAntiFraudRulesNames(t *testing.T) {
+	rules, err := ParseAntiFraudRules(
+		[]byte(`
+velocity:
+  - {key: user, limit: 5, window: 1h}
+  - {key: user, limit: 3, window: 1h, shadow: true, name: user_hourly_strict}
+`),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rules.Velocity[0].Name; got != "velocity_user_1h" {
+		t.Errorf("default name %q, want velocity_user_1h", got)
+	}
+
+	// A shadow rule named like an enforced one would share its counters.
+	_, err = ParseAntiFraudRules(
+		[]byte(`
+velocity:
+  - {key: user, limit: 5, window: 1h}
+  - {key: user, limit: 3, window: 1h, shadow: true}
+`),
+	)
+	if err == nil || !strings.Contains(err.Error(), "duplicate name") {
+		t.Errorf("got %v, want a duplicate name error", err)
+	}
+}
+
+func TestParseAntiFraudRulesOutcomes(t *testing.T) {
+	tests := []struct {
+		name   string
+		yaml   string
+		errHas string
+	}{
+		{"default", "velocity:\n  - {key: ip, limit: 5, window: 1h}", ""},
+		{"review", "velocity:\n  - {key: ip, limit: 5, window: 1h, outcome: review}", ""},
+		{"allow", "velocity:\n  - {key: ip, limit: 5, window: 1h, outcome: allow}", `velocity rule "velocity_ip_1h"`},
+		{"unknown", "velocity:\n  - {name: burst, key: ip, limit: 5, window: 1h, outcome: block}", `velocity rule "burst"`},
+		{"new accounts allow", "new_accounts: {min_age: 10m, outcome: allow}", "new_accounts"},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				_, err := ParseAntiFraudRules([]byte(tt.yaml))
+				switch {
+				case tt.errHas == "" && err != nil:
+					t.Errorf("unexpected error: %v", err)
+				case tt.errHas != "" && (err == nil || !strings.Contains(err.Error(), tt.errHas)):
+					t.Errorf("got %v, want an error about %s", err, tt.errHas)
+				}
+			},
+		)
+	}
+}
@@ -0,0 +1,97 @@
+package antifraudstub
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Config is the behaviour of a long-running stub. The zero value allows
+// everyone without cache_until.
+type Config struct {
+	// CacheFor is how far ahead the cache_until of an allow is; zero leaves
+	// it out.
+	CacheFor time.Duration `yaml:"cache_for"`
+	// Requests matching the deny lists are denied, case-insensitively.
+	DenyEmails       []string `yaml:"deny_emails"`
+	DenyEmailDomains []string `yaml:"deny_email_domains"`
+	DenyPromos       []string `yaml:"deny_promos"`
+	// DenyRate is the share of the other requests denied at random.
+	DenyRate float64 `yaml:"deny_rate"`
+	// ErrorRate is the share of requests answered with ErrorStatus, 500 by
+	// default, before anything else is decided.
+	ErrorRate   float64 `yaml:"error_rate"`
+	ErrorStatus int     `yaml:"error_status"`
+	// Every answer is delayed by Latency plus a random time up to
+	// LatencyJitter.
+	Latency       time.Duration `yaml:"latency"`
+	LatencyJitter time.Duration `yaml:"latency_jitter"`
+
+	denied map[string]bool
+}
+
+// ParseConfig reads a Config from YAML.
+func ParseConfig(data []byte) (*Config, error) {
+	c := &Config{}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	for name, rate := range map[string]float64{"deny_rate": c.DenyRate, "error_rate": c.ErrorRate} {
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("%s must be between 0 and 1", name)
+		}
+	}
+	if c.ErrorStatus == 0 {
+		c.ErrorStatus = http.StatusInternalServerError
+	}
+	if c.ErrorStatus < 400 || c.ErrorStatus > 599 {
+		return nil, fmt.Errorf("error_status %d is not an error", c.ErrorStatus)
+	}
+	if c.CacheFor < 0 || c.Latency < 0 || c.LatencyJitter < 0 {
+		return nil, fmt.Errorf("durations must not be negative")
+	}
+	c.denied = make(map[string]bool)
+	for _, e := range c.DenyEmails {
+		c.denied["email:"+strings.ToLower(e)] = true
+	}
+	for _, d := range c.DenyEmailDomains {
+		c.denied["domain:"+strings.ToLower(strings.TrimPrefix(d, "@"))] = true
+	}
+	for _, p := range c.DenyPromos {
+		c.denied["promo:"+strings.ToLower(p)] = true
+	}
+	return c, nil
+}
+
+// Decide answers a request as configured; use it as the Decide of a Stub.
+func (c *Config) Decide(r Request) Response {
+	var res Response
+	switch {
+	case c.ErrorRate > 0 && rand.Float64() < c.ErrorRate:
+		res = Response{Status: c.ErrorStatus, Body: `{"error":"injected failure"}`}
+	case c.Denies(r) || (c.DenyRate > 0 && rand.Float64() < c.DenyRate):
+		res = Deny()
+	case c.CacheFor > 0:
+		res = OK(time.Now().Add(c.CacheFor))
+	default:
+		res = OK(time.Time{})
+	}
+	res.Delay = c.Latency
+	if c.LatencyJitter > 0 {
+		res.Delay += rand.N(c.LatencyJitter)
+	}
+	return res
+}
+
+// Denies tells whether the request is on a deny list.
+func (c *Config) Denies(r Request) bool {
+	email := strings.ToLower(r.Email)
+	if c.denied["email:"+email] || c.denied["promo:"+strings.ToLower(r.PromoID)] {
+		return true
+	}
+	at := strings.LastIndexByte(email, '@')
+	return at >= 0 && c.denied["domain:"+email[at+1:]]
+}
@@ -0,0 +1,114 @@
+package antifraudstub
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfigValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		yaml   string
+		errHas string
+	}{
+		{"empty", ``, ""},
+		{"rates at the bounds", "deny_rate: 1\nerror_rate: 0", ""},
+		{"negative deny rate", "deny_rate: -0.1", "deny_rate"},
+		{"error rate above one", "error_rate: 1.5", "error_rate"},
+		{"client error status", "error_status: 429", ""},
+		{"success status", "error_status: 200", "error_status 200"},
+		{"status out of range", "error_status: 600", "error_status 600"},
+		{"negative latency", "latency: -1s", "negative"},
+		{"negative cache_for", "cache_for: -5s", "negative"},
+		{"not yaml", "deny_emails: {", "yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				_, err := ParseConfig([]byte(tt.yaml))
+				switch {
+				case tt.errHas == "" && err != nil:
+					t.Errorf("unexpected error: %v", err)
+				case tt.errHas != "" && (err == nil || !strings.Contains(err.Error(), tt.errHas)):
+					t.Errorf("got %v, want an error about %s", err, tt.errHas)
+				}
+			},
+		)
+	}
+}
+
+func TestParseConfigDefaultErrorStatus(t *testing.T) {
+	c, err := ParseConfig([]byte("error_rate: 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := c.Decide(Request{}); res.Status != http.StatusInternalServerError {
+		t.Errorf("status %d, want 500", res.Status)
+	}
+}
+
+func TestConfigDecide(t *testing.T) {
+	lists := `
+deny_emails: [Fraud@Example.com]
+deny_email_domains: ["@Spam.test"]
+deny_promos: [1F0E6A4C-0000-4000-8000-000000000000]
+`
+	allowed := OK(time.Time{})
+	tests := []struct {
+		name    string
+		yaml    string
+		request Request
+		status  int
+		body    string
+	}{
+		{"allow by default", ``, Request{Email: "user@example.com"}, http.StatusOK, allowed.Body},
+		{"denied email", lists, Request{Email: "fraud@example.com"}, http.StatusOK, Deny().Body},
+		{"denied email domain", lists, Request{Email: "someone@SPAM.test"}, http.StatusOK, Deny().Body},
+		{"subdomain not denied", lists, Request{Email: "someone@mail.spam.test"}, http.StatusOK, allowed.Body},
+		{
+			"denied promo", lists,
+			Request{Email: "user@example.com", PromoID: "1f0e6a4c-0000-4000-8000-000000000000"},
+			http.StatusOK, Deny().Body,
+		},
+		{"other email allowed", lists, Request{Email: "user@example.com"}, http.StatusOK, allowed.Body},
+		{"deny rate one", "deny_rate: 1", Request{Email: "user@example.com"}, http.StatusOK, Deny().Body},
+		{"deny rate zero", "deny_rate: 0", Request{Email: "user@example.com"}, http.StatusOK, allowed.Body},
+		{
+			"errors come before deny lists", lists + "error_rate: 1\nerror_status: 503",
+			Request{Email: "fraud@example.com"}, http.StatusServiceUnavailable, `{"error":"injected failure"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				c, err := ParseConfig([]byte(tt.yaml))
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Rates of 0 and 1 decide the same way every time.
+				for i := 0; i < 20; i++ {
+					res := c.Decide(tt.request)
+					if res.Status != tt.status || res.Body != tt.body {
+						t.Fatalf("got %d %s, want %d %s", res.Status, res.Body, tt.status, tt.body)
+					}
+				}
+			},
+		)
+	}
+}
+
+func TestConfigDecideCacheAndLatency(t *testing.T) {
+	c, err := ParseConfig([]byte("cache_for: 1m\nlatency: 10ms\nlatency_jitter: 5ms"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := c.Decide(Request{Email: "user@example.com"})
+	if !strings.Contains(res.Body, "cache_until") {
+		t.Errorf("body %s has no cache_until", res.Body)
+	}
+	if res.Delay < 10*time.Millisecond || res.Delay >= 15*time.Millisecond {
+		t.Errorf("delay %s, want between 10ms and 15ms", res.Delay)
+	}
+}
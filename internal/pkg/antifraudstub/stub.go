@@ -0,0 +1,124 @@
+// Package antifraudstub is a stand-in for the external antifraud service:
+// an /api/validate handler whose answers are scripted, for exercising the
+// client without the real service.
+package antifraudstub
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// Request is what the client sent.
+type Request struct {
+	Email   string `json:"user_email"`
+	PromoID string `json:"promo_id"`
+}
+
+// Response is one scripted answer. An empty Body with status 200 is sent
+// as is, to mimic a broken service.
+type Response struct {
+	Status int
+	Body   string
+	Delay  time.Duration
+}
+
+// OK and Deny are the answers of a healthy service.
+func OK(cacheUntil time.Time) Response {
+	body := map[string]interface{}{"ok": true}
+	if !cacheUntil.IsZero() {
+		body["cache_until"] = cacheUntil.Format(time.RFC3339)
+	}
+	b, _ := json.Marshal(body)
+	return Response{Status: http.StatusOK, Body: string(b)}
+}
+
+func Deny() Response {
+	return Response{Status: http.StatusOK, Body: `{"ok":false}`}
+}
+
+// Stub answers with the scripted responses in order, then with the
+// fallback, or with Decide when it is set.
+type Stub struct {
+	mu       sync.Mutex
+	script   []Response
+	fallback Response
+	requests []Request
+	// Decide, if set, answers requests the script doesn't.
+	Decide func(r Request) Response
+	// MaxRequests bounds the requests kept, the oldest are dropped first;
+	// zero keeps them all.
+	MaxRequests int
+}
+
+func New(fallback Response) *Stub {
+	return &Stub{fallback: fallback}
+}
+
+// Script queues responses for the next requests.
+func (s *Stub) Script(responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script = append(s.script, responses...)
+}
+
+// Requests returns the requests received so far.
+func (s *Stub) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Reset forgets the script and the requests.
+func (s *Stub) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script = nil
+	s.requests = nil
+}
+
+func (s *Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/validate" || r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	if s.MaxRequests > 0 && len(s.requests) > s.MaxRequests {
+		s.requests = s.requests[len(s.requests)-s.MaxRequests:]
+	}
+	res := s.fallback
+	decide := s.Decide
+	if len(s.script) > 0 {
+		res, s.script = s.script[0], s.script[1:]
+		decide = nil
+	}
+	s.mu.Unlock()
+	if decide != nil {
+		res = decide(req)
+	}
+
+	if res.Delay > 0 {
+		select {
+		case <-time.After(res.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(res.Status)
+	_, _ = w.Write([]byte(res.Body))
+}
+
+// Start serves the stub on a local port until Close.
+func (s *Stub) Start() *httptest.Server {
+	return httptest.NewServer(s)
+}
@@ -0,0 +1,90 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	b := New(2, time.Hour)
+	b.Allow()
+	b.Failure()
+	if !b.Allow() || b.State() != Closed {
+		t.Fatalf("opened after one failure of two")
+	}
+	b.Failure()
+	if b.Allow() || b.State() != Open {
+		t.Errorf("still letting calls through after two failures")
+	}
+}
+
+func TestBreakerSuccessResetsFailures(t *testing.T) {
+	b := New(2, time.Hour)
+	b.Allow()
+	b.Failure()
+	b.Allow()
+	b.Success()
+	b.Allow()
+	b.Failure()
+	if b.State() != Closed {
+		t.Errorf("failures not reset by a success")
+	}
+}
+
+func TestBreakerProbe(t *testing.T) {
+	tests := []struct {
+		name string
+		end  func(b *Breaker)
+		want State
+	}{
+		{"success closes", (*Breaker).Success, Closed},
+		{"failure opens again", (*Breaker).Failure, Open},
+		{"ignore waits for another probe", (*Breaker).Ignore, HalfOpen},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				cooldown := 20 * time.Millisecond
+				b := New(1, cooldown)
+				b.Allow()
+				b.Failure()
+				if b.Allow() {
+					t.Fatal("open breaker let a call through")
+				}
+				time.Sleep(cooldown)
+				if !b.Allow() {
+					t.Fatal("no probe after the cooldown")
+				}
+				if b.Allow() {
+					t.Fatal("second call let through while probing")
+				}
+				tt.end(b)
+				if got := b.State(); got != tt.want {
+					t.Errorf("state %s, want %s", got, tt.want)
+				}
+				if tt.want == HalfOpen && !b.Allow() {
+					t.Errorf("no new probe after an ignored one")
+				}
+			},
+		)
+	}
+}
+
+func TestBreakerOnChange(t *testing.T) {
+	b := New(1, 0)
+	var states []State
+	b.OnChange = func(s State) { states = append(states, s) }
+	b.Allow()
+	b.Failure()
+	b.Allow()
+	b.Success()
+	want := []State{Open, HalfOpen, Closed}
+	if len(states) != len(want) {
+		t.Fatalf("transitions %v, want %v", states, want)
+	}
+	for i := range want {
+		if states[i] != want[i] {
+			t.Errorf("transitions %v, want %v", states, want)
+		}
+	}
+}
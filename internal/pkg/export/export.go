@@ -0,0 +1,85 @@
+// Package export writes tabular data as CSV, XLSX or NDJSON one row at a
+// time, so exports never have to be built in memory.
+package export
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	CSV    = "csv"
+	XLSX   = "xlsx"
+	NDJSON = "ndjson"
+)
+
+// Writer writes rows of values in the order of the columns it was created
+// with. Values are strings, bools, integers, floats, times, fmt.Stringers or
+// pointers to them; nil pointers are empty cells.
+type Writer interface {
+	Row(values ...interface{}) error
+	// Close finishes the file. It does not close the underlying writer.
+	Close() error
+}
+
+// New starts a file of the format on w; CSV and XLSX get a header row.
+func New(format string, w io.Writer, columns []string) (Writer, error) {
+	switch format {
+	case CSV:
+		return newCSV(w, columns)
+	case XLSX:
+		return newXLSX(w, columns)
+	case NDJSON:
+		return newNDJSON(w, columns), nil
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+func ContentType(format string) string {
+	switch format {
+	case CSV:
+		return "text/csv; charset=utf-8"
+	case XLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case NDJSON:
+		return "application/x-ndjson"
+	}
+	return "application/octet-stream"
+}
+
+// value dereferences pointers and turns times and Stringers into text, leaving
+// nil, strings, bools and numbers.
+func value(v interface{}) interface{} {
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case *string:
+		if x == nil {
+			return nil
+		}
+		return *x
+	case *int:
+		if x == nil {
+			return nil
+		}
+		return *x
+	case *bool:
+		if x == nil {
+			return nil
+		}
+		return *x
+	case *time.Time:
+		if x == nil {
+			return nil
+		}
+		return x.UTC().Format(time.RFC3339)
+	case time.Time:
+		return x.UTC().Format(time.RFC3339)
+	case string, bool, int, int64, float64:
+		return x
+	case fmt.Stringer:
+		return x.String()
+	}
+	return fmt.Sprint(v)
+}
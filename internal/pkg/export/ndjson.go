@@ -0,0 +1,49 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// ndjsonWriter writes every row as a JSON object with the keys in column
+// order.
+type ndjsonWriter struct {
+	w    *bufio.Writer
+	keys [][]byte
+}
+
+func newNDJSON(w io.Writer, columns []string) *ndjsonWriter {
+	keys := make([][]byte, len(columns))
+	for i, c := range columns {
+		keys[i], _ = json.Marshal(c)
+	}
+	return &ndjsonWriter{w: bufio.NewWriter(w), keys: keys}
+}
+
+func (n *ndjsonWriter) Row(values ...interface{}) error {
+	n.w.WriteByte('{')
+	for i, key := range n.keys {
+		if i > 0 {
+			n.w.WriteByte(',')
+		}
+		n.w.Write(key)
+		n.w.WriteByte(':')
+		var v interface{}
+		if i < len(values) {
+			v = value(values[i])
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		n.w.Write(b)
+	}
+	// bufio.Writer errors stick, so this also reports the writes above.
+	_, err := n.w.WriteString("}\n")
+	return err
+}
+
+func (n *ndjsonWriter) Close() error {
+	return n.w.Flush()
+}
@@ -1,6 +1,12 @@
 package pkg
 
-import "github.com/intezya/pkglib"
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/intezya/pkglib"
+	"strings"
+)
 
 type CryptoProvider struct{}
 
@@ -11,3 +17,11 @@ func (CryptoProvider) Salt() string {
 func (CryptoProvider) Encrypt(value string) string {
 	return pkglib.Crypto.EncodeBase64(pkglib.Crypto.HashSHA256(value))
 }
+
+// Pseudonym is a stable stand-in for an email: the same secret and email,
+// in any case, always give the same pseudonym, which doesn't reveal the email.
+func Pseudonym(secret string, email string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.ToLower(email)))
+	return "user-" + hex.EncodeToString(mac.Sum(nil))[:16]
+}